@@ -10,7 +10,8 @@ import (
 
 // Ctl implements functions to work with apachectl cli utility
 type Ctl struct {
-	BinPath string
+	BinPath         string
+	compileSettings *CompileSettings
 }
 
 // GetApacheCtl returns apache2/httpd manager
@@ -90,10 +91,112 @@ func (a *Ctl) GetVersion() (string, error) {
 	return result[0], nil
 }
 
+// CompileSettings are apache build settings
+type CompileSettings struct {
+	Version string
+	MPM     string
+	// HTTPDRoot is the default server root
+	HTTPDRoot string
+	// ServerConfigFile is the default config file. It is relative to HTTPDRoot if it is not absolute.
+	ServerConfigFile string
+	// Modules are names of the modules compiled into the binary, e.g. core and so
+	Modules []string
+}
+
+// GetCompileSettings returns build settings of "-V" output.
+// The settings are detected once, since they do not change without the binary update.
+func (a *Ctl) GetCompileSettings() (*CompileSettings, error) {
+	if a.compileSettings != nil {
+		return a.compileSettings, nil
+	}
+
+	output, err := a.execCmd([]string{"-V"})
+
+	if err != nil {
+		return nil, fmt.Errorf("could not get apache compile settings: %v", err)
+	}
+
+	settings := ParseCompileSettings(output)
+
+	if settings.HTTPDRoot == "" && settings.ServerConfigFile == "" {
+		return nil, errors.New("could not detect apache compile settings")
+	}
+
+	if output, err = a.execCmd([]string{"-l"}); err == nil {
+		settings.Modules = ParseCompiledModules(output)
+	}
+
+	a.compileSettings = settings
+
+	return settings, nil
+}
+
+// ParseCompileSettings parses "-V" output
+func ParseCompileSettings(output []byte) *CompileSettings {
+	settings := &CompileSettings{}
+	patterns := map[*string]*regexp.Regexp{
+		&settings.Version:          regexp.MustCompile(`(?m)^Server version:\s*Apache/([0-9.]+)`),
+		&settings.MPM:              regexp.MustCompile(`(?m)^Server MPM:\s*(\S+)`),
+		&settings.HTTPDRoot:        regexp.MustCompile(`(?m)^\s*-D HTTPD_ROOT="(.*)"`),
+		&settings.ServerConfigFile: regexp.MustCompile(`(?m)^\s*-D SERVER_CONFIG_FILE="(.*)"`),
+	}
+
+	for value, pattern := range patterns {
+		if match := pattern.FindSubmatch(output); match != nil {
+			*value = string(match[1])
+		}
+	}
+
+	return settings
+}
+
+// ParseCompiledModules parses "-l" output and returns module names, e.g. so for mod_so.c and http for http_core.c
+func ParseCompiledModules(output []byte) []string {
+	var modules []string
+	matches := regexp.MustCompile(`(?m)^\s+(\S+)\.c\s*$`).FindAllSubmatch(output, -1)
+
+	for _, match := range matches {
+		module := string(match[1])
+
+		switch {
+		case module == "http_core":
+			module = "http"
+		case strings.HasPrefix(module, "mod_"):
+			module = strings.TrimPrefix(module, "mod_")
+		}
+
+		modules = append(modules, module)
+	}
+
+	return modules
+}
+
+// ConfigurationError is returned when apache configuration test fails. It keeps apachectl output.
+type ConfigurationError struct {
+	Output string
+	Err    error
+}
+
+func (ce *ConfigurationError) Error() string {
+	if ce.Output == "" {
+		return fmt.Sprintf("invalid apache configuration: %v", ce.Err)
+	}
+
+	return fmt.Sprintf("invalid apache configuration: %v: %s", ce.Err, ce.Output)
+}
+
+func (ce *ConfigurationError) Unwrap() error {
+	return ce.Err
+}
+
 // TestConfiguration checks the syntax of apache configuration files
 func (a *Ctl) TestConfiguration() error {
-	if _, err := a.execCmd([]string{"-t"}); err != nil {
-		return fmt.Errorf("invalid apache configuration: %v", err)
+	// apachectl writes syntax errors to stderr, so the combined output is captured
+	cmd := exec.Command(a.BinPath, "-t")
+	output, err := cmd.CombinedOutput()
+
+	if err != nil {
+		return &ConfigurationError{Output: strings.TrimSpace(string(output)), Err: err}
 	}
 
 	return nil
@@ -0,0 +1,47 @@
+package apache
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testCompileSettings = `Server version: Apache/2.4.54 (Unix)
+Server built:   Jun  9 2022 18:21:34
+Server's Module Magic Number: 20120211:124
+Server loaded:  APR 1.7.0, APR-UTIL 1.6.1
+Compiled using: APR 1.7.0, APR-UTIL 1.6.1
+Architecture:   64-bit
+Server MPM:     event
+  threaded:     yes (fixed thread count)
+    forked:     yes (variable process count)
+Server compiled with....
+ -D APR_HAS_SENDFILE
+ -D HTTPD_ROOT="/usr/local/apache2"
+ -D SUEXEC_BIN="/usr/local/apache2/bin/suexec"
+ -D DEFAULT_PIDLOG="logs/httpd.pid"
+ -D SERVER_CONFIG_FILE="conf/httpd.conf"
+`
+
+const testCompiledModules = `Compiled in modules:
+  core.c
+  mod_so.c
+  http_core.c
+  event.c
+`
+
+func TestParseCompileSettings(t *testing.T) {
+	settings := ParseCompileSettings([]byte(testCompileSettings))
+	assert.Equal(t, &CompileSettings{
+		Version:          "2.4.54",
+		MPM:              "event",
+		HTTPDRoot:        "/usr/local/apache2",
+		ServerConfigFile: "conf/httpd.conf",
+	}, settings)
+	assert.Equal(t, &CompileSettings{}, ParseCompileSettings([]byte("unsupported")))
+}
+
+func TestParseCompiledModules(t *testing.T) {
+	assert.Equal(t, []string{"core", "so", "http", "event"}, ParseCompiledModules([]byte(testCompiledModules)))
+	assert.Empty(t, ParseCompiledModules([]byte("unsupported")))
+}
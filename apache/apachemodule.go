@@ -0,0 +1,79 @@
+package apache
+
+import (
+	"fmt"
+	"os/exec"
+
+	opts "github.com/r2dtools/a2conf/options"
+	"github.com/r2dtools/a2conf/utils"
+)
+
+// Module implements functionality for module enabling/disabling
+type Module struct {
+	DismodBin, EnmodBin string
+}
+
+// Enable enables module via a2enmod utility
+func (m *Module) Enable(module string) error {
+	if !utils.IsCommandExist(m.getEnmodCmd()) {
+		return fmt.Errorf("could not enable module '%s': a2enmod utility is not available", module)
+	}
+
+	_, err := m.execCmd(m.getEnmodCmd(), []string{module})
+
+	if err != nil {
+		return fmt.Errorf("could not enable module '%s': %v", module, err)
+	}
+
+	return nil
+}
+
+// Disable disables module via a2dismod utility
+func (m *Module) Disable(module string) error {
+	if !utils.IsCommandExist(m.getDismodCmd()) {
+		return fmt.Errorf("could not disable module '%s': a2dismod utility is not available", module)
+	}
+
+	_, err := m.execCmd(m.getDismodCmd(), []string{module})
+
+	if err != nil {
+		return fmt.Errorf("could not disable module '%s': %v", module, err)
+	}
+
+	return nil
+}
+
+func (m *Module) execCmd(command string, params []string) ([]byte, error) {
+	cmd := exec.Command(command, params...)
+	output, err := cmd.Output()
+
+	if err != nil {
+		return nil, fmt.Errorf("could not execute '%s' command: %v", command, err)
+	}
+
+	return output, nil
+}
+
+func (m *Module) getEnmodCmd() string {
+	if m.EnmodBin == "" {
+		return "a2enmod"
+	}
+
+	return m.EnmodBin
+}
+
+func (m *Module) getDismodCmd() string {
+	if m.DismodBin == "" {
+		return "a2dismod"
+	}
+
+	return m.DismodBin
+}
+
+// GetApacheModule returns Module structure instance
+func GetApacheModule(options map[string]string) *Module {
+	enmodBin := opts.GetOption(opts.ApacheEnmod, options)
+	dismodBin := opts.GetOption(opts.ApacheDismod, options)
+
+	return &Module{EnmodBin: enmodBin, DismodBin: dismodBin}
+}
@@ -0,0 +1,86 @@
+package a2conf
+
+import (
+	"strings"
+)
+
+// QuoteArg returns the argument as it must be written to the config.
+// The argument is enclosed in double quotes if it is empty, contains spaces or starts with a quote.
+// Backslashes are escaped only where Apache would treat them as escape characters.
+func QuoteArg(arg string) string {
+	if !isQuotingRequired(arg) {
+		return escapeArg(arg, 0)
+	}
+
+	return "\"" + escapeArg(arg, '"') + "\""
+}
+
+// UnquoteArg returns the value of the argument written in the config.
+// Quotes are removed and escape sequences are replaced the same way as Apache does it:
+// \\ is replaced with \ and an escaped quote is replaced with the quote. The other backslashes are kept.
+func UnquoteArg(arg string) string {
+	var quote byte
+
+	if len(arg) >= 2 && (arg[0] == '"' || arg[0] == '\'') && arg[len(arg)-1] == arg[0] {
+		quote = arg[0]
+		arg = arg[1 : len(arg)-1]
+	}
+
+	if !strings.Contains(arg, "\\") {
+		return arg
+	}
+
+	var builder strings.Builder
+
+	for i := 0; i < len(arg); i++ {
+		if arg[i] == '\\' && i+1 < len(arg) && (arg[i+1] == '\\' || (quote != 0 && arg[i+1] == quote)) {
+			i++
+		}
+
+		builder.WriteByte(arg[i])
+	}
+
+	return builder.String()
+}
+
+// formatDirective returns the directive as it must be written to the config: the name followed by the quoted arguments
+func formatDirective(name string, args []string) string {
+	text := name
+
+	for _, arg := range args {
+		text += " " + QuoteArg(arg)
+	}
+
+	return text
+}
+
+func isQuotingRequired(arg string) bool {
+	if arg == "" || strings.ContainsAny(arg, " \t\r\n>") {
+		return true
+	}
+
+	// a bare argument can not start with a quote or a word list and can not end with a backslash,
+	// since it is treated as a line continuation
+	return strings.ContainsAny(arg[:1], "\"'{") || strings.HasSuffix(arg, "\\")
+}
+
+// escapeArg escapes backslashes followed by a backslash or the quote and the quotes.
+// If quote is 0, the argument is a bare one.
+func escapeArg(arg string, quote byte) string {
+	var builder strings.Builder
+
+	for i := 0; i < len(arg); i++ {
+		switch {
+		case arg[i] == '\\':
+			if i+1 == len(arg) || arg[i+1] == '\\' || (quote != 0 && arg[i+1] == quote) {
+				builder.WriteByte('\\')
+			}
+		case quote != 0 && arg[i] == quote:
+			builder.WriteByte('\\')
+		}
+
+		builder.WriteByte(arg[i])
+	}
+
+	return builder.String()
+}
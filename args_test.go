@@ -0,0 +1,80 @@
+package a2conf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testArgs = []struct {
+	value, raw string
+}{
+	{"/var/www/html", "/var/www/html"},
+	{"/var/www/my site", "\"/var/www/my site\""},
+	{"", "\"\""},
+	{"say \"hello\"", "\"say \\\"hello\\\"\""},
+	{"\"quoted\"", "\"\\\"quoted\\\"\""},
+	{"'single'", "\"'single'\""},
+	{"it's", "it's"},
+	{"^/foo\\.html$", "^/foo\\.html$"},
+	{"C:\\\\share", "C:\\\\\\share"},
+	{"C:\\dir\\", "\"C:\\dir\\\\\""},
+	{"a \\\"b", "\"a \\\\\\\"b\""},
+	{"${APACHE_LOG_DIR}/error.log", "${APACHE_LOG_DIR}/error.log"},
+	{"${APACHE_LOG_DIR}/my log", "\"${APACHE_LOG_DIR}/my log\""},
+	{"{a,b}", "\"{a,b}\""},
+	{"<b>", "\"<b>\""},
+}
+
+func TestQuoteArg(t *testing.T) {
+	for _, item := range testArgs {
+		assert.Equal(t, item.raw, QuoteArg(item.value), "value: %s", item.value)
+		assert.Equal(t, item.value, UnquoteArg(item.raw), "raw: %s", item.raw)
+	}
+
+	assert.Equal(t, "/var/www/html", UnquoteArg("'/var/www/html'"))
+	assert.Equal(t, "it's", UnquoteArg("'it\\'s'"))
+	assert.Equal(t, "\"unclosed", UnquoteArg("\"unclosed"))
+}
+
+func TestArgsRoundTrip(t *testing.T) {
+	tree, filePath := getTestConfigTree(t)
+	fileSection, _ := GetFileSection(tree, filePath)
+	vhost, _ := fileSection.FindSection("VirtualHost", "*:80")
+
+	for _, item := range testArgs {
+		_, err := vhost.AddDirective("Header", "set", "X-Test", item.value)
+		assert.Nilf(t, err, "could not add directive: %v", err)
+	}
+
+	_, err := vhost.AddSection("Directory", "/var/www/my site")
+	assert.Nilf(t, err, "could not add section: %v", err)
+
+	err = tree.(*backendTree).backend.Save()
+	assert.Nilf(t, err, "could not save config: %v", err)
+
+	tree = loadTestConfigTree(t, filePath)
+	fileSection, _ = GetFileSection(tree, filePath)
+	vhost, _ = fileSection.FindSection("VirtualHost", "*:80")
+	headers, err := vhost.Directives("Header")
+	assert.Nilf(t, err, "could not get directives: %v", err)
+	assert.Equal(t, len(testArgs), len(headers))
+
+	for i, header := range headers {
+		assert.Equal(t, []string{"set", "X-Test", testArgs[i].value}, header.Args)
+	}
+
+	directory, _ := vhost.FindSection("Directory", "/var/www/my site")
+	assert.NotNil(t, directory)
+}
+
+func TestInterpretArg(t *testing.T) {
+	parser := &Parser{variables: map[string]string{"APACHE_LOG_DIR": "/var/log/apache2"}}
+
+	value, err := parser.InterpretArg(UnquoteArg("\"${APACHE_LOG_DIR}/my log\""))
+	assert.Nilf(t, err, "could not interpret argument: %v", err)
+	assert.Equal(t, "/var/log/apache2/my log", value)
+
+	_, err = parser.InterpretArg("${UNKNOWN}/error.log")
+	assert.NotNil(t, err)
+}
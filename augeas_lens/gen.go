@@ -0,0 +1,31 @@
+//go:build ignore
+// +build ignore
+
+// gen.go generates lens_httpd.go with the content of httpd.aug, so the lens is bundled into the package
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+)
+
+func main() {
+	content, err := ioutil.ReadFile("augeas_lens/httpd.aug")
+
+	if err != nil {
+		log.Fatalf("could not read lens: %v", err)
+	}
+
+	code := fmt.Sprintf(`// Code generated by augeas_lens/gen.go. DO NOT EDIT.
+
+package a2conf
+
+// httpdLens is the content of augeas_lens/httpd.aug
+const httpdLens = %q
+`, content)
+
+	if err = ioutil.WriteFile("lens_httpd.go", []byte(code), 0644); err != nil {
+		log.Fatalf("could not write lens: %v", err)
+	}
+}
@@ -0,0 +1,49 @@
+package a2conf
+
+import (
+	"fmt"
+
+	"github.com/r2dtools/a2conf/native"
+)
+
+// Parser backends
+const (
+	// BackendAugeas uses libaugeas via cgo
+	BackendAugeas = "augeas"
+	// BackendNative uses the pure Go parser
+	BackendNative = "native"
+)
+
+// Backend is a config tree storage with Augeas path API. It is implemented by libaugeas and by the native parser.
+type Backend interface {
+	Match(path string) ([]string, error)
+	Get(path string) (string, error)
+	Label(path string) (string, error)
+	Set(path, value string) error
+	Insert(path, label string, before bool) error
+	Remove(path string) int
+	Move(src, dst string) error
+	Span(path string) (native.Span, error)
+	Load() error
+	LoadFile(filePath string) error
+	Save() error
+	Close()
+}
+
+// newBackend creates backend by its name. If the name is empty, the default backend is used:
+// libaugeas or the native parser if the package is built with "noaugeas" tag.
+// lens is a source of the httpd lens for libaugeas, see getLensLoadPath.
+func newBackend(name, lens string) (Backend, error) {
+	if name == "" {
+		name = defaultBackend
+	}
+
+	switch name {
+	case BackendAugeas:
+		return newAugeasBackend(lens)
+	case BackendNative:
+		return native.New(), nil
+	}
+
+	return nil, fmt.Errorf("unknown parser backend '%s'", name)
+}
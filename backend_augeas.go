@@ -0,0 +1,72 @@
+//go:build !noaugeas
+// +build !noaugeas
+
+package a2conf
+
+import (
+	"os"
+
+	"github.com/r2dtools/a2conf/native"
+	"honnef.co/go/augeas"
+)
+
+const defaultBackend = BackendAugeas
+
+// augeasHandle is a type of the deprecated Parser.Augeas field
+type augeasHandle = augeas.Augeas
+
+type augeasBackend struct {
+	augeas.Augeas
+	// lensDir is a temporary directory with the bundled lens
+	lensDir string
+}
+
+func newAugeasBackend(lens string) (Backend, error) {
+	loadPath, lensDir, err := getLensLoadPath(lens)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// the lens from the load path takes precedence over the system one
+	aug, err := augeas.New("/", loadPath, augeas.NoLoad|augeas.NoModlAutoload|augeas.EnableSpan)
+
+	if err != nil {
+		if lensDir != "" {
+			os.RemoveAll(lensDir)
+		}
+
+		return nil, err
+	}
+
+	return &augeasBackend{Augeas: aug, lensDir: lensDir}, nil
+}
+
+// Close closes augeas and removes the bundled lens
+func (b *augeasBackend) Close() {
+	b.Augeas.Close()
+
+	if b.lensDir != "" {
+		os.RemoveAll(b.lensDir)
+	}
+}
+
+// Span returns position of the node in the file
+func (b *augeasBackend) Span(path string) (native.Span, error) {
+	span, err := b.Augeas.Span(path)
+
+	if err != nil {
+		return native.Span{}, err
+	}
+
+	return native.Span{Filename: span.Filename, SpanStart: span.SpanStart, SpanEnd: span.SpanEnd}, nil
+}
+
+// getAugeasHandle returns libaugeas handle of the backend. Zero value is returned for the other backends.
+func getAugeasHandle(backend Backend) augeasHandle {
+	if b, ok := backend.(*augeasBackend); ok {
+		return b.Augeas
+	}
+
+	return augeasHandle{}
+}
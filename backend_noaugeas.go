@@ -0,0 +1,19 @@
+//go:build noaugeas
+// +build noaugeas
+
+package a2conf
+
+import "errors"
+
+const defaultBackend = BackendNative
+
+// augeasHandle is a type of the deprecated Parser.Augeas field, libaugeas is not available with "noaugeas" tag
+type augeasHandle struct{}
+
+func newAugeasBackend(lens string) (Backend, error) {
+	return nil, errors.New("augeas backend is not available: the package is built with 'noaugeas' tag")
+}
+
+func getAugeasHandle(backend Backend) augeasHandle {
+	return augeasHandle{}
+}
@@ -0,0 +1,390 @@
+package a2conf
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// runtimeSections are evaluated by apache per request, so their directives could not be excluded statically
+var runtimeSections = []string{"if", "elseif", "else"}
+
+// moduleDirectives are directives and sections provided by the modules. It is used to evaluate IfDirective and IfSection.
+// Directives and sections which are not listed are considered available.
+var moduleDirectives = map[string]struct {
+	directives []string
+	sections   []string
+}{
+	"core": {
+		directives: []string{
+			"AcceptFilter", "AcceptPathInfo", "AccessFileName", "AddDefaultCharset", "AllowEncodedSlashes", "AllowOverride",
+			"AllowOverrideList", "DefaultRuntimeDir", "Define", "DocumentRoot", "EnableMMAP", "EnableSendfile", "Error",
+			"ErrorDocument", "ErrorLog", "ErrorLogFormat", "FileETag", "HostnameLookups", "Include", "IncludeOptional",
+			"KeepAlive", "KeepAliveTimeout", "LimitRequestBody", "LimitRequestFields", "LimitRequestFieldSize",
+			"LimitRequestLine", "LogLevel", "MaxKeepAliveRequests", "Mutex", "Options", "Protocol", "Protocols", "ServerAdmin",
+			"ServerAlias", "ServerName", "ServerPath", "ServerRoot", "ServerSignature", "ServerTokens", "SetHandler",
+			"SetInputFilter", "SetOutputFilter", "TimeOut", "TraceEnable", "UnDefine", "UseCanonicalName",
+		},
+		sections: []string{
+			"Directory", "DirectoryMatch", "Else", "ElseIf", "Files", "FilesMatch", "If", "IfDefine", "IfDirective", "IfFile",
+			"IfModule", "IfSection", "Limit", "LimitExcept", "Location", "LocationMatch", "VirtualHost",
+		},
+	},
+	"alias": {
+		directives: []string{
+			"Alias", "AliasMatch", "Redirect", "RedirectMatch", "RedirectPermanent", "RedirectTemp", "ScriptAlias",
+			"ScriptAliasMatch",
+		},
+	},
+	"authz_core": {
+		directives: []string{"Require"},
+		sections:   []string{"RequireAll", "RequireAny", "RequireNone"},
+	},
+	"dir": {
+		directives: []string{"DirectoryIndex", "DirectorySlash", "FallbackResource"},
+	},
+	"env": {
+		directives: []string{"PassEnv", "SetEnv", "UnsetEnv"},
+	},
+	"expires": {
+		directives: []string{"ExpiresActive", "ExpiresByType", "ExpiresDefault"},
+	},
+	"headers": {
+		directives: []string{"Header", "RequestHeader"},
+	},
+	"http2": {
+		directives: []string{"H2Direct", "H2Push"},
+	},
+	"log_config": {
+		directives: []string{"CustomLog", "LogFormat", "TransferLog"},
+	},
+	"macro": {
+		directives: []string{"UndefMacro", "Use"},
+		sections:   []string{"Macro"},
+	},
+	"mime": {
+		directives: []string{"AddCharset", "AddEncoding", "AddHandler", "AddType", "TypesConfig"},
+	},
+	"proxy": {
+		directives: []string{
+			"BalancerMember", "ProxyPass", "ProxyPassMatch", "ProxyPassReverse", "ProxyPreserveHost", "ProxyRequests",
+			"ProxySet", "ProxyTimeout",
+		},
+		sections: []string{"Proxy", "ProxyMatch"},
+	},
+	"rewrite": {
+		directives: []string{"RewriteBase", "RewriteCond", "RewriteEngine", "RewriteMap", "RewriteOptions", "RewriteRule"},
+	},
+	"setenvif": {
+		directives: []string{"BrowserMatch", "BrowserMatchNoCase", "SetEnvIf", "SetEnvIfExpr", "SetEnvIfNoCase"},
+	},
+	"so": {
+		directives: []string{"LoadFile", "LoadModule"},
+	},
+	"ssl": {
+		directives: []string{
+			"SSLCACertificateFile", "SSLCertificateChainFile", "SSLCertificateFile", "SSLCertificateKeyFile", "SSLCipherSuite",
+			"SSLCompression", "SSLEngine", "SSLHonorCipherOrder", "SSLOpenSSLConfCmd", "SSLOptions", "SSLPassPhraseDialog",
+			"SSLProtocol", "SSLProxyEngine", "SSLRandomSeed", "SSLSessionCache", "SSLSessionTickets", "SSLStaplingCache",
+			"SSLUseStapling", "SSLVerifyClient", "SSLVerifyDepth",
+		},
+	},
+	"unixd": {
+		directives: []string{"ChrootDir", "Group", "User"},
+	},
+	"version": {
+		sections: []string{"IfVersion"},
+	},
+}
+
+var directiveModules, sectionModules = getDirectiveModules()
+
+func getDirectiveModules() (map[string]string, map[string]string) {
+	directives := make(map[string]string)
+	sections := make(map[string]string)
+
+	for module, names := range moduleDirectives {
+		for _, name := range names.directives {
+			directives[strings.ToLower(name)] = module
+		}
+
+		for _, name := range names.sections {
+			sections[strings.ToLower(name)] = module
+		}
+	}
+
+	return directives, sections
+}
+
+// isNodeActive checks if the node is read by apache: all conditional sections containing the node are evaluated.
+// Nodes inside of the runtime sections are considered active.
+func (p *Parser) isNodeActive(path string) (bool, error) {
+	node, err := p.Tree.GetNode(path)
+
+	if err != nil {
+		return false, err
+	}
+
+	for {
+		node, err = p.Tree.GetParent(node)
+
+		if err != nil {
+			return false, err
+		}
+
+		if node == nil || node.Type != NodeSection || !strings.HasPrefix(node.Path, "/files/") {
+			return true, nil
+		}
+
+		args, err := p.getInterpretedArgs(node)
+
+		if err != nil {
+			return false, err
+		}
+
+		defines, err := p.getDefinesAt(node.Path)
+
+		if err != nil {
+			return false, err
+		}
+
+		isActive, err := p.isSectionActive(node.Name, args, defines, p.Modules)
+
+		if err != nil || !isActive {
+			return false, err
+		}
+	}
+}
+
+// IsRuntimeConditional checks if the node is inside of <If>, <ElseIf> or <Else> section.
+// Such sections are evaluated per request, so it is unknown if the node is applied.
+func (p *Parser) IsRuntimeConditional(path string) (bool, error) {
+	node, err := p.Tree.GetNode(path)
+
+	if err != nil {
+		return false, err
+	}
+
+	for node != nil && node.Type != NodeFile && strings.HasPrefix(node.Path, "/files/") {
+		if node.Type == NodeSection && isRuntimeSection(node.Name) {
+			return true, nil
+		}
+
+		if node, err = p.Tree.GetParent(node); err != nil {
+			return false, err
+		}
+	}
+
+	return false, nil
+}
+
+// getInterpretedArgs returns arguments of the node with the variables resolved with the defines active at the node
+func (p *Parser) getInterpretedArgs(node *Node) ([]string, error) {
+	argNodes, err := p.Tree.GetArgs(node)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var args []string
+
+	for _, argNode := range argNodes {
+		value, err := p.InterpretArgAt(argNode.Value, node.Path)
+
+		// apache keeps unknown variables as is
+		if err != nil {
+			value = argNode.Value
+		}
+
+		args = append(args, value)
+	}
+
+	return args, nil
+}
+
+// isSectionActive evaluates the conditional section. Sections which are not conditional and runtime sections are active.
+func (p *Parser) isSectionActive(name string, args []string, defines map[string]string, modules map[string]bool) (bool, error) {
+	if len(args) == 0 {
+		return true, nil
+	}
+
+	name = strings.ToLower(name)
+
+	// apache refuses to start with the invalid version, the section is considered inactive to keep reading the config
+	if name == "ifversion" {
+		isMatched, err := p.isVersionMatched(args)
+
+		return err == nil && isMatched, nil
+	}
+
+	isNegated := strings.HasPrefix(args[0], "!")
+	arg := strings.TrimPrefix(args[0], "!")
+	var isMet bool
+
+	switch name {
+	case "ifdefine":
+		_, isMet = defines[arg]
+	case "ifmodule":
+		isMet = isModuleLoaded(modules, arg)
+	case "iffile":
+		if !filepath.IsAbs(arg) {
+			arg = filepath.Join(p.ServerRoot, arg)
+		}
+
+		_, err := os.Stat(arg)
+		isMet = err == nil
+	case "ifdirective":
+		isMet = isDirectiveAvailable(directiveModules, arg, modules)
+	case "ifsection":
+		isMet = isDirectiveAvailable(sectionModules, arg, modules)
+	default:
+		return true, nil
+	}
+
+	return isMet != isNegated, nil
+}
+
+// isVersionMatched evaluates IfVersion section: <IfVersion [[!]operator] version>
+func (p *Parser) isVersionMatched(args []string) (bool, error) {
+	operator := "="
+	version := args[0]
+
+	if len(args) > 1 {
+		operator = args[0]
+		version = args[1]
+	}
+
+	isNegated := strings.HasPrefix(operator, "!")
+	operator = strings.TrimPrefix(operator, "!")
+
+	// single '!' negates the default operator
+	if operator == "" {
+		operator = "="
+	}
+
+	if p.version == "" {
+		return false, fmt.Errorf("could not evaluate IfVersion: apache version is unknown")
+	}
+
+	if operator == "=" && len(version) > 1 && strings.HasPrefix(version, "/") && strings.HasSuffix(version, "/") {
+		operator = "~"
+		version = version[1 : len(version)-1]
+	}
+
+	if operator == "~" {
+		re, err := regexp.Compile(version)
+
+		if err != nil {
+			return false, fmt.Errorf("could not parse IfVersion expression '%s': %v", version, err)
+		}
+
+		return re.MatchString(p.version) != isNegated, nil
+	}
+
+	cmp, err := compareVersions(p.version, version)
+
+	if err != nil {
+		return false, err
+	}
+
+	var isMet bool
+
+	switch operator {
+	case "=", "==":
+		isMet = cmp == 0
+	case ">":
+		isMet = cmp > 0
+	case ">=":
+		isMet = cmp >= 0
+	case "<":
+		isMet = cmp < 0
+	case "<=":
+		isMet = cmp <= 0
+	default:
+		return false, fmt.Errorf("invalid IfVersion operator '%s'", operator)
+	}
+
+	return isMet != isNegated, nil
+}
+
+// compareVersions compares major, minor and patch numbers of the versions. Missing numbers are considered zero.
+func compareVersions(a, b string) (int, error) {
+	aParts, err := parseVersion(a)
+
+	if err != nil {
+		return 0, err
+	}
+
+	bParts, err := parseVersion(b)
+
+	if err != nil {
+		return 0, err
+	}
+
+	for i := range aParts {
+		if aParts[i] != bParts[i] {
+			if aParts[i] > bParts[i] {
+				return 1, nil
+			}
+
+			return -1, nil
+		}
+	}
+
+	return 0, nil
+}
+
+func parseVersion(version string) ([3]int, error) {
+	var parts [3]int
+	numbers := strings.Split(version, ".")
+
+	if len(numbers) > len(parts) {
+		return parts, fmt.Errorf("invalid version '%s'", version)
+	}
+
+	for i, number := range numbers {
+		value, err := strconv.Atoi(number)
+
+		if err != nil {
+			return parts, fmt.Errorf("invalid version '%s'", version)
+		}
+
+		parts[i] = value
+	}
+
+	return parts, nil
+}
+
+// isModuleLoaded checks the module of IfModule section. The module is given by the identifier, e.g. ssl_module,
+// or by the source file name, e.g. mod_ssl.c. The file name without extension is also accepted.
+func isModuleLoaded(modules map[string]bool, module string) bool {
+	switch {
+	case modules[module]:
+		return true
+	case strings.HasSuffix(module, "_module"):
+		return false
+	case strings.HasPrefix(module, "mod_"):
+		return modules[strings.TrimSuffix(strings.TrimPrefix(module, "mod_"), ".c")+"_module"]
+	}
+
+	return false
+}
+
+func isDirectiveAvailable(index map[string]string, name string, modules map[string]bool) bool {
+	module, ok := index[strings.ToLower(name)]
+
+	return !ok || module == "core" || modules[module+"_module"]
+}
+
+func isRuntimeSection(name string) bool {
+	for _, section := range runtimeSections {
+		if strings.EqualFold(name, section) {
+			return true
+		}
+	}
+
+	return false
+}
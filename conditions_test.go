@@ -0,0 +1,253 @@
+package a2conf
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testConditionsConfig = `LoadModule ssl_module modules/mod_ssl.so
+<IfVersion >= 2.4.30>
+	ServerName newer.example.com
+</IfVersion>
+<IfVersion < 2.4>
+	ServerName older.example.com
+</IfVersion>
+<IfVersion >= 2.4.x>
+	ServerName invalid.example.com
+</IfVersion>
+<IfVersion ~ ^2\.4\.>
+	<IfVersion ! /^2\.2/>
+		ServerAlias regex.example.com
+	</IfVersion>
+</IfVersion>
+<IfFile conf.d/exists.conf>
+	DocumentRoot /var/www/exists
+</IfFile>
+<IfFile !/tmp/a2conf-missing-file>
+	ServerAdmin admin@example.com
+</IfFile>
+<IfDirective SSLEngine>
+	SSLEngine on
+</IfDirective>
+<IfDirective RewriteEngine>
+	RewriteEngine on
+</IfDirective>
+<IfSection !Proxy>
+	ErrorLog /var/log/no-proxy.log
+</IfSection>
+<If "%{HTTP_HOST} == 'example.com'">
+	Header set X-Host example
+</If>
+`
+
+func TestConditionalSections(t *testing.T) {
+	runWithBackends(t, func(t *testing.T, backend string) {
+		parser, dir := getTestParser(t, backend, map[string]string{
+			"apache2.conf":       testConditionsConfig,
+			"conf.d/exists.conf": "",
+		})
+		defer os.RemoveAll(dir)
+		defer parser.Close()
+
+		expected := map[string][]string{
+			"ServerName":    {"newer.example.com"},
+			"ServerAlias":   {"regex.example.com"},
+			"DocumentRoot":  {"/var/www/exists"},
+			"ServerAdmin":   {"admin@example.com"},
+			"SSLEngine":     {"on"},
+			"RewriteEngine": nil,
+			"ErrorLog":      {"/var/log/no-proxy.log"},
+			"Header":        {"set", "X-Host", "example"},
+		}
+
+		for directive, expectedArgs := range expected {
+			matches, err := parser.FindDirective(directive, "", "", true)
+			assert.Nilf(t, err, "could not find directive %s: %v", directive, err)
+
+			var args []string
+
+			for _, match := range matches {
+				arg, err := parser.GetArg(match)
+				assert.Nilf(t, err, "could not get argument: %v", err)
+				args = append(args, arg)
+			}
+
+			assert.Equal(t, expectedArgs, args, directive)
+		}
+
+		matches, _ := parser.FindDirective("Header", "", "", true)
+		isRuntime, err := parser.IsRuntimeConditional(matches[0])
+		assert.Nilf(t, err, "could not check runtime condition: %v", err)
+		assert.True(t, isRuntime)
+
+		matches, _ = parser.FindDirective("ServerName", "", "", true)
+		isRuntime, err = parser.IsRuntimeConditional(matches[0])
+		assert.Nilf(t, err, "could not check runtime condition: %v", err)
+		assert.False(t, isRuntime)
+	})
+}
+
+func TestIsVersionMatched(t *testing.T) {
+	parser := &Parser{version: "2.4.41"}
+	items := []struct {
+		args     []string
+		expected bool
+	}{
+		{[]string{"2.4.41"}, true},
+		{[]string{"=", "2.4"}, false},
+		{[]string{"==", "2.4.41"}, true},
+		{[]string{">", "2.4.40"}, true},
+		{[]string{">=", "2.4.42"}, false},
+		{[]string{"<", "2.5"}, true},
+		{[]string{"<=", "2.4"}, false},
+		{[]string{"!=", "2.4.41"}, false},
+		{[]string{"!<", "2.4"}, true},
+		{[]string{"~", `^2\.4`}, true},
+		{[]string{"!~", `^2\.4`}, false},
+		{[]string{"/^2\\.2/"}, false},
+		{[]string{"!", "/^2\\.2/"}, true},
+	}
+
+	for _, item := range items {
+		isMatched, err := parser.isVersionMatched(item.args)
+		assert.Nilf(t, err, "could not evaluate version %v: %v", item.args, err)
+		assert.Equal(t, item.expected, isMatched, item.args)
+	}
+
+	_, err := parser.isVersionMatched([]string{"=>", "2.4"})
+	assert.NotNil(t, err)
+	_, err = parser.isVersionMatched([]string{"2.x"})
+	assert.NotNil(t, err)
+	_, err = (&Parser{}).isVersionMatched([]string{"2.4"})
+	assert.NotNil(t, err)
+}
+
+func TestInvalidIfVersion(t *testing.T) {
+	runWithBackends(t, func(t *testing.T, backend string) {
+		configurator, dir := getTestConfigurator(t, backend, map[string]string{
+			"apache2.conf": "<IfVersion >= 2.4.x>\n\t<VirtualHost *:80>\n\t\tServerName example.com\n\t</VirtualHost>\n</IfVersion>\n",
+		})
+		defer os.RemoveAll(dir)
+		defer configurator.Close()
+
+		vhosts, err := configurator.GetVhosts()
+		assert.Nilf(t, err, "could not get vhosts: %v", err)
+		assert.Equal(t, 1, len(vhosts))
+
+		if len(vhosts) == 1 {
+			assert.False(t, vhosts[0].Active, "section with the invalid version must be inactive")
+		}
+	})
+}
+
+func TestIsModuleLoaded(t *testing.T) {
+	parser := &Parser{}
+	parser.AddModule("ssl")
+	items := map[string]bool{
+		"ssl_module":      true,
+		"mod_ssl.c":       true,
+		"mod_ssl":         true,
+		"rewrite_module":  false,
+		"mod_rewrite.c":   false,
+		"mod_rewrite":     false,
+		"ssl":             false,
+		"mod_ssl_module":  false,
+		"sapi_apache2.c":  false,
+		"!mod_rewrite.c":  false,
+		"":                false,
+		"mod_ssl.c.extra": false,
+	}
+
+	for module, expected := range items {
+		assert.Equal(t, expected, isModuleLoaded(parser.Modules, module), module)
+	}
+}
+
+const testIfModuleVhosts = `LoadModule ssl_module modules/mod_ssl.so
+<IfModule mod_ssl.c>
+	<VirtualHost *:443>
+		ServerName file.example.com
+	</VirtualHost>
+</IfModule>
+<IfModule ssl_module>
+	<VirtualHost *:443>
+		ServerName identifier.example.com
+	</VirtualHost>
+</IfModule>
+<IfModule mod_ssl>
+	<VirtualHost *:443>
+		ServerName short.example.com
+	</VirtualHost>
+</IfModule>
+<IfModule !mod_rewrite.c>
+	<VirtualHost *:80>
+		ServerName norewrite.example.com
+		<IfModule mod_rewrite.c>
+			SSLEngine on
+		</IfModule>
+	</VirtualHost>
+</IfModule>
+<IfModule mod_rewrite.c>
+	<VirtualHost *:80>
+		ServerName rewrite.example.com
+	</VirtualHost>
+</IfModule>
+<IfModule ssl_module>
+	<IfModule !mod_ssl.c>
+		<VirtualHost *:80>
+			ServerName nested.example.com
+		</VirtualHost>
+	</IfModule>
+	<IfModule !rewrite_module>
+		<VirtualHost *:80>
+			ServerName nested-norewrite.example.com
+			<IfModule mod_ssl>
+				SSLEngine on
+			</IfModule>
+		</VirtualHost>
+	</IfModule>
+</IfModule>
+`
+
+func TestIfModuleVhosts(t *testing.T) {
+	runWithBackends(t, func(t *testing.T, backend string) {
+		configurator, dir := getTestConfigurator(t, backend, map[string]string{"apache2.conf": testIfModuleVhosts})
+		defer os.RemoveAll(dir)
+		defer configurator.Close()
+
+		vhosts, err := configurator.GetVhosts()
+		assert.Nilf(t, err, "could not get vhosts: %v", err)
+
+		ssl := make(map[string]bool)
+		active := make(map[string]bool)
+
+		for _, vhost := range vhosts {
+			ssl[vhost.ServerName] = vhost.Ssl
+			active[vhost.ServerName] = vhost.Active
+		}
+
+		expectedSsl := map[string]bool{
+			"file.example.com":             true,
+			"identifier.example.com":       true,
+			"short.example.com":            true,
+			"norewrite.example.com":        false,
+			"rewrite.example.com":          false,
+			"nested.example.com":           false,
+			"nested-norewrite.example.com": true,
+		}
+		assert.Equal(t, expectedSsl, ssl)
+
+		expectedActive := map[string]bool{
+			"file.example.com":             true,
+			"identifier.example.com":       true,
+			"short.example.com":            true,
+			"norewrite.example.com":        true,
+			"rewrite.example.com":          false,
+			"nested.example.com":           false,
+			"nested-norewrite.example.com": true,
+		}
+		assert.Equal(t, expectedActive, active)
+	})
+}
@@ -0,0 +1,521 @@
+package a2conf
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var pathIndexRegexp = regexp.MustCompile(`^(.*)\[(\d+)\]$`)
+
+// NodeType is a type of the config tree node
+type NodeType string
+
+// Config tree node types
+const (
+	NodeFile      NodeType = "file"
+	NodeDirective NodeType = "directive"
+	NodeSection   NodeType = "section"
+	NodeArg       NodeType = "arg"
+	NodeComment   NodeType = "comment"
+)
+
+const (
+	directiveLabel = "directive"
+	argLabel       = "arg"
+	commentLabel   = "#comment"
+)
+
+// Node is a node of the config tree: a config file, a directive, a section, an argument or a comment.
+// Path is an opaque reference to the node within the tree backend and must not be parsed by clients.
+type Node struct {
+	Type NodeType
+	// Name is a name of the directive or the section, or a path of the file
+	Name string
+	// Value is a value of the argument or a text of the comment. Arguments are unquoted and unescaped.
+	Value string
+	Path  string
+}
+
+// NodeSpan is a position of the node in the config file
+type NodeSpan struct {
+	Filename string
+	Start    uint
+	End      uint
+}
+
+// ConfigTree is a backend neutral tree of the config files.
+// Arguments are passed to and returned from the tree as plain values, they are quoted and escaped when written to the config.
+type ConfigTree interface {
+	// GetNode returns the node by its path
+	GetNode(path string) (*Node, error)
+	// GetFileNode returns the root node of the file or the directory with loaded files. Nil is returned if there is no such node.
+	GetFileNode(filePath string) (*Node, error)
+	// GetParent returns the parent node. Nil is returned for a file node.
+	GetParent(node *Node) (*Node, error)
+	// GetChildren returns directives, sections and comments of the node. Arguments are not included.
+	GetChildren(node *Node) ([]*Node, error)
+	// GetArgs returns arguments of the directive or the section
+	GetArgs(node *Node) ([]*Node, error)
+	// FindSections returns all descendant sections of the node with the name. The name is case insensitive.
+	FindSections(node *Node, name string) ([]*Node, error)
+	// FindDirectives returns child directives of the node with the name. The name is case insensitive.
+	FindDirectives(node *Node, name string) ([]*Node, error)
+	// SetValue sets value of the argument or the comment, or name of the directive
+	SetValue(node *Node, value string) error
+	// SetArgs replaces arguments of the directive or the section
+	SetArgs(node *Node, args []string) error
+	// AddDirective appends the directive to the section or the file
+	AddDirective(node *Node, name string, args []string) (*Node, error)
+	// InsertDirective inserts the directive before or after the anchor node.
+	// Paths of the following directives obtained before the insertion are not valid anymore.
+	InsertDirective(anchor *Node, name string, args []string, before bool) (*Node, error)
+	// AddSection appends the section to the section or the file
+	AddSection(node *Node, name string, args []string) (*Node, error)
+	// MoveNode moves the directive or the section to the end of the parent section.
+	// Paths of the following siblings of the node obtained before the moving are not valid anymore.
+	MoveNode(node, parent *Node) error
+	// Remove removes the node with its descendants
+	Remove(node *Node) error
+	// GetSpan returns position of the node in the file
+	GetSpan(node *Node) (*NodeSpan, error)
+	// Load loads changed files. Unsaved changes of the reloaded files are lost.
+	Load() error
+}
+
+// backendTree is a config tree over a backend with Augeas path API
+type backendTree struct {
+	backend Backend
+}
+
+func newConfigTree(backend Backend) ConfigTree {
+	return &backendTree{backend: backend}
+}
+
+// GetNode returns the node by its path
+func (t *backendTree) GetNode(path string) (*Node, error) {
+	label, err := t.backend.Label(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not get node '%s': %v", path, err)
+	}
+
+	value, err := t.backend.Get(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not get node '%s': %v", path, err)
+	}
+
+	node := &Node{Path: path}
+
+	switch {
+	case label == directiveLabel:
+		node.Type = NodeDirective
+		node.Name = value
+	case label == argLabel:
+		node.Type = NodeArg
+		node.Value = UnquoteArg(value)
+	case strings.HasPrefix(label, "#"):
+		node.Type = NodeComment
+		node.Value = value
+	default:
+		node.Type = NodeSection
+		node.Name = label
+	}
+
+	return node, nil
+}
+
+// GetFileNode returns the root node of the file or the directory with loaded files
+func (t *backendTree) GetFileNode(filePath string) (*Node, error) {
+	path := "/files" + escape(strings.TrimRight(filePath, "/"))
+	matches, err := t.backend.Match(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not find file '%s' in the tree: %v", filePath, err)
+	}
+
+	if len(matches) == 0 {
+		return nil, nil
+	}
+
+	return &Node{Type: NodeFile, Name: filePath, Path: path}, nil
+}
+
+// GetParent returns the parent node
+func (t *backendTree) GetParent(node *Node) (*Node, error) {
+	if node.Type == NodeFile {
+		return nil, nil
+	}
+
+	matches, err := t.backend.Match(node.Path + "/..")
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(matches) != 1 {
+		return nil, fmt.Errorf("could not find parent of the node '%s'", node.Path)
+	}
+
+	if metadata, _ := t.backend.Match(fmt.Sprintf("/augeas%s/path", matches[0])); len(metadata) > 0 {
+		filePath, err := t.backend.Get(metadata[0])
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &Node{Type: NodeFile, Name: strings.TrimPrefix(filePath, "/files"), Path: matches[0]}, nil
+	}
+
+	return t.GetNode(matches[0])
+}
+
+// GetChildren returns directives, sections and comments of the node
+func (t *backendTree) GetChildren(node *Node) ([]*Node, error) {
+	return t.matchNodes(node.Path + "/*[label() != 'arg']")
+}
+
+// GetArgs returns arguments of the directive or the section
+func (t *backendTree) GetArgs(node *Node) ([]*Node, error) {
+	return t.matchNodes(node.Path + "/arg")
+}
+
+// FindSections returns all descendant sections of the node with the name. The name is compared case-insensitively.
+func (t *backendTree) FindSections(node *Node, name string) ([]*Node, error) {
+	nodes, err := t.matchNodes(node.Path + "//*[label() != 'arg' and label() != 'directive']")
+
+	if err != nil {
+		return nil, err
+	}
+
+	return filterNodesByName(nodes, NodeSection, name), nil
+}
+
+// FindDirectives returns child directives of the node with the name. The name is compared case-insensitively.
+func (t *backendTree) FindDirectives(node *Node, name string) ([]*Node, error) {
+	nodes, err := t.matchNodes(node.Path + "/directive")
+
+	if err != nil {
+		return nil, err
+	}
+
+	return filterNodesByName(nodes, NodeDirective, name), nil
+}
+
+// SetValue sets value of the node
+func (t *backendTree) SetValue(node *Node, value string) error {
+	if node.Type == NodeFile || node.Type == NodeSection {
+		return fmt.Errorf("could not set value of the %s '%s'", node.Type, node.Path)
+	}
+
+	rawValue := value
+
+	if node.Type == NodeArg {
+		rawValue = QuoteArg(value)
+	}
+
+	if err := t.backend.Set(node.Path, rawValue); err != nil {
+		return err
+	}
+
+	if node.Type == NodeDirective {
+		node.Name = value
+	} else {
+		node.Value = value
+	}
+
+	return nil
+}
+
+// SetArgs replaces arguments of the directive or the section
+func (t *backendTree) SetArgs(node *Node, args []string) error {
+	if node.Type != NodeDirective && node.Type != NodeSection {
+		return fmt.Errorf("could not set arguments of the %s '%s'", node.Type, node.Path)
+	}
+
+	argPaths, err := t.backend.Match(node.Path + "/arg")
+
+	if err != nil {
+		return err
+	}
+
+	for i, arg := range args {
+		argPath := fmt.Sprintf("%s/arg[%d]", node.Path, i+1)
+
+		if i < len(argPaths) {
+			if err = t.backend.Set(argPath, QuoteArg(arg)); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		// arguments must precede the other children of the section
+		if i > 0 {
+			err = t.backend.Insert(fmt.Sprintf("%s/arg[%d]", node.Path, i), argLabel, false)
+		} else if children, _ := t.backend.Match(node.Path + "/*"); len(children) > 0 {
+			err = t.backend.Insert(children[0], argLabel, true)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if err = t.backend.Set(argPath, QuoteArg(arg)); err != nil {
+			return err
+		}
+	}
+
+	for i := len(argPaths); i > len(args); i-- {
+		t.backend.Remove(fmt.Sprintf("%s/arg[%d]", node.Path, i))
+	}
+
+	return nil
+}
+
+// AddDirective appends the directive to the section or the file
+func (t *backendTree) AddDirective(node *Node, name string, args []string) (*Node, error) {
+	if node.Type != NodeFile && node.Type != NodeSection {
+		return nil, fmt.Errorf("could not add directive '%s' to the %s '%s'", name, node.Type, node.Path)
+	}
+
+	if err := t.backend.Set(node.Path+"/directive[last() + 1]", name); err != nil {
+		return nil, fmt.Errorf("could not add directive '%s': %v", name, err)
+	}
+
+	directives, err := t.backend.Match(node.Path + "/directive[last()]")
+
+	if err != nil || len(directives) != 1 {
+		return nil, fmt.Errorf("could not find added directive '%s': %v", name, err)
+	}
+
+	for i, arg := range args {
+		if err := t.backend.Set(fmt.Sprintf("%s/arg[%d]", directives[0], i+1), QuoteArg(arg)); err != nil {
+			return nil, fmt.Errorf("could not set argument '%s' of directive '%s': %v", arg, name, err)
+		}
+	}
+
+	return &Node{Type: NodeDirective, Name: name, Path: directives[0]}, nil
+}
+
+// InsertDirective inserts the directive before or after the anchor node
+func (t *backendTree) InsertDirective(anchor *Node, name string, args []string, before bool) (*Node, error) {
+	if anchor.Type == NodeFile || anchor.Type == NodeArg {
+		return nil, fmt.Errorf("could not insert directive '%s' next to the %s '%s'", name, anchor.Type, anchor.Path)
+	}
+
+	parent, err := t.GetParent(anchor)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// the position of the new directive among the directive siblings is detected before the insertion,
+	// since the anchor path could change after it
+	anchorPaths, err := t.backend.Match(anchor.Path)
+
+	if err != nil || len(anchorPaths) != 1 {
+		return nil, fmt.Errorf("could not find anchor node '%s': %v", anchor.Path, err)
+	}
+
+	siblings, err := t.backend.Match(parent.Path + "/*")
+
+	if err != nil {
+		return nil, err
+	}
+
+	var position int
+
+	for _, sibling := range siblings {
+		if sibling == anchorPaths[0] {
+			break
+		}
+
+		if label, _ := t.backend.Label(sibling); label == directiveLabel {
+			position++
+		}
+	}
+
+	position++
+
+	if !before && anchor.Type == NodeDirective {
+		position++
+	}
+
+	if err = t.backend.Insert(anchor.Path, directiveLabel, before); err != nil {
+		return nil, fmt.Errorf("could not insert directive '%s': %v", name, err)
+	}
+
+	// the anchor directive is shifted by the new one
+	if before && anchor.Type == NodeDirective {
+		anchor.Path = fmt.Sprintf("%s/directive[%d]", parent.Path, position+1)
+	}
+
+	node := &Node{Type: NodeDirective, Name: name, Path: fmt.Sprintf("%s/directive[%d]", parent.Path, position)}
+
+	if err = t.backend.Set(node.Path, name); err != nil {
+		return nil, fmt.Errorf("could not set directive '%s': %v", name, err)
+	}
+
+	if err = t.SetArgs(node, args); err != nil {
+		return nil, fmt.Errorf("could not set arguments of directive '%s': %v", name, err)
+	}
+
+	return node, nil
+}
+
+// AddSection appends the section to the section or the file
+func (t *backendTree) AddSection(node *Node, name string, args []string) (*Node, error) {
+	if node.Type != NodeFile && node.Type != NodeSection {
+		return nil, fmt.Errorf("could not add section '%s' to the %s '%s'", name, node.Type, node.Path)
+	}
+
+	if err := t.backend.Set(fmt.Sprintf("%s/%s[last() + 1]", node.Path, name), ""); err != nil {
+		return nil, fmt.Errorf("could not add section '%s': %v", name, err)
+	}
+
+	sections, err := t.backend.Match(fmt.Sprintf("%s/%s[last()]", node.Path, name))
+
+	if err != nil || len(sections) != 1 {
+		return nil, fmt.Errorf("could not find added section '%s': %v", name, err)
+	}
+
+	section := &Node{Type: NodeSection, Name: name, Path: sections[0]}
+
+	if err = t.SetArgs(section, args); err != nil {
+		return nil, fmt.Errorf("could not set arguments of section '%s': %v", name, err)
+	}
+
+	return section, nil
+}
+
+// MoveNode moves the directive or the section to the end of the parent section
+func (t *backendTree) MoveNode(node, parent *Node) error {
+	if node.Type != NodeDirective && node.Type != NodeSection {
+		return fmt.Errorf("could not move the %s '%s'", node.Type, node.Path)
+	}
+
+	if parent.Type != NodeFile && parent.Type != NodeSection {
+		return fmt.Errorf("could not move '%s' to the %s '%s'", node.Path, parent.Type, parent.Path)
+	}
+
+	if strings.HasPrefix(parent.Path+"/", node.Path+"/") {
+		return fmt.Errorf("could not move '%s' into itself", node.Path)
+	}
+
+	label := directiveLabel
+
+	if node.Type == NodeSection {
+		label = node.Name
+	}
+
+	if err := t.backend.Move(node.Path, fmt.Sprintf("%s/%s[last() + 1]", parent.Path, label)); err != nil {
+		return fmt.Errorf("could not move '%s': %v", node.Path, err)
+	}
+
+	parent.Path = getShiftedPath(parent.Path, node.Path)
+	matches, err := t.backend.Match(fmt.Sprintf("%s/%s[last()]", parent.Path, label))
+
+	if err != nil || len(matches) != 1 {
+		return fmt.Errorf("could not find moved node '%s': %v", node.Path, err)
+	}
+
+	node.Path = matches[0]
+
+	return nil
+}
+
+// Remove removes the node with its descendants
+func (t *backendTree) Remove(node *Node) error {
+	if t.backend.Remove(node.Path) == 0 {
+		return fmt.Errorf("could not remove node '%s'", node.Path)
+	}
+
+	return nil
+}
+
+// GetSpan returns position of the node in the file
+func (t *backendTree) GetSpan(node *Node) (*NodeSpan, error) {
+	span, err := t.backend.Span(node.Path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &NodeSpan{Filename: span.Filename, Start: span.SpanStart, End: span.SpanEnd}, nil
+}
+
+// Load loads changed files
+func (t *backendTree) Load() error {
+	return t.backend.Load()
+}
+
+// getShiftedPath returns the path of the node after removing the node with removedPath.
+// The index of the path step is decreased if the removed node is its preceding sibling with the same label.
+func getShiftedPath(path, removedPath string) string {
+	match := pathIndexRegexp.FindStringSubmatch(removedPath)
+
+	if match == nil {
+		return path
+	}
+
+	removedIndex, _ := strconv.Atoi(match[2])
+	prefix := match[1] + "["
+
+	if !strings.HasPrefix(path, prefix) {
+		return path
+	}
+
+	rest := path[len(prefix):]
+	closeIdx := strings.Index(rest, "]")
+
+	if closeIdx == -1 {
+		return path
+	}
+
+	index, err := strconv.Atoi(rest[:closeIdx])
+
+	if err != nil || index <= removedIndex {
+		return path
+	}
+
+	return fmt.Sprintf("%s%d%s", prefix, index-1, rest[closeIdx:])
+}
+
+func (t *backendTree) matchNodes(path string) ([]*Node, error) {
+	matches, err := t.backend.Match(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var nodes []*Node
+
+	for _, match := range matches {
+		node, err := t.GetNode(match)
+
+		if err != nil {
+			return nil, err
+		}
+
+		nodes = append(nodes, node)
+	}
+
+	return nodes, nil
+}
+
+// filterNodesByName returns the nodes of the type with the name. Names are compared in Go,
+// since they could contain characters which have a special meaning in the path expressions.
+func filterNodesByName(nodes []*Node, nodeType NodeType, name string) []*Node {
+	var filtered []*Node
+
+	for _, node := range nodes {
+		if node.Type == nodeType && strings.EqualFold(node.Name, name) {
+			filtered = append(filtered, node)
+		}
+	}
+
+	return filtered
+}
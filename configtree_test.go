@@ -0,0 +1,169 @@
+package a2conf
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testTreeConfig = `<Macro VHost $name>
+	<VirtualHost *:80>
+		ServerName $name
+	</VirtualHost>
+</Macro>
+
+<VirtualHost *:80>
+	# main site
+	ServerName example.com
+	ServerAlias www.example.com
+	<Directory /var/www/html>
+		Require all granted
+	</Directory>
+</VirtualHost>
+`
+
+func TestConfigTree(t *testing.T) {
+	tree, filePath := getTestConfigTree(t)
+	fileNode, err := tree.GetFileNode(filePath)
+	assert.Nilf(t, err, "could not get file node: %v", err)
+	assert.Equal(t, NodeFile, fileNode.Type)
+
+	missingNode, err := tree.GetFileNode("/tmp/a2conf-missing.conf")
+	assert.Nilf(t, err, "could not get file node: %v", err)
+	assert.Nil(t, missingNode)
+
+	sections, err := tree.FindSections(fileNode, "virtualhost")
+	assert.Nilf(t, err, "could not find sections: %v", err)
+	assert.Equal(t, 2, len(sections))
+
+	var vhost *Node
+
+	for _, section := range sections {
+		assert.Equal(t, NodeSection, section.Type)
+		assert.Equal(t, "VirtualHost", section.Name)
+
+		parent, err := tree.GetParent(section)
+		assert.Nilf(t, err, "could not get parent: %v", err)
+
+		if parent.Type == NodeFile {
+			assert.Equal(t, filePath, parent.Name)
+			vhost = section
+		} else {
+			assert.Equal(t, NodeSection, parent.Type)
+			assert.Equal(t, "Macro", parent.Name)
+		}
+	}
+
+	assert.NotNil(t, vhost)
+
+	args, err := tree.GetArgs(vhost)
+	assert.Nilf(t, err, "could not get args: %v", err)
+	assert.Equal(t, 1, len(args))
+	assert.Equal(t, NodeArg, args[0].Type)
+	assert.Equal(t, "*:80", args[0].Value)
+
+	children, err := tree.GetChildren(vhost)
+	assert.Nilf(t, err, "could not get children: %v", err)
+	var types []NodeType
+
+	for _, child := range children {
+		types = append(types, child.Type)
+	}
+
+	assert.Equal(t, []NodeType{NodeComment, NodeDirective, NodeDirective, NodeSection}, types)
+	assert.Equal(t, "main site", children[0].Value)
+
+	directives, err := tree.FindDirectives(vhost, "SERVERALIAS")
+	assert.Nilf(t, err, "could not find directives: %v", err)
+	assert.Equal(t, 1, len(directives))
+	assert.Equal(t, "ServerAlias", directives[0].Name)
+
+	// names are not patterns
+	for _, name := range []string{"Server.*", "ServerName|ServerAlias", "Server'Name"} {
+		directives, err = tree.FindDirectives(vhost, name)
+		assert.Nilf(t, err, "could not find directives %s: %v", name, err)
+		assert.Empty(t, directives, name)
+	}
+
+	sections, err = tree.FindSections(fileNode, "Virtual.*")
+	assert.Nilf(t, err, "could not find sections: %v", err)
+	assert.Empty(t, sections)
+}
+
+func TestConfigTreeModification(t *testing.T) {
+	tree, filePath := getTestConfigTree(t)
+	fileNode, _ := tree.GetFileNode(filePath)
+	macros, _ := tree.FindSections(fileNode, "Macro")
+	sections, _ := tree.FindDirectives(fileNode, "VirtualHost")
+	assert.Empty(t, sections, "sections must not be found as directives")
+	sections, _ = tree.FindSections(fileNode, "VirtualHost")
+	assert.Equal(t, 2, len(sections))
+	vhost := sections[0]
+
+	if parent, _ := tree.GetParent(vhost); parent.Path == macros[0].Path {
+		vhost = sections[1]
+	}
+
+	directive, err := tree.AddDirective(vhost, "Redirect", []string{"/old", "/new"})
+	assert.Nilf(t, err, "could not add directive: %v", err)
+	assert.Equal(t, "Redirect", directive.Name)
+
+	args, _ := tree.GetArgs(directive)
+	assert.Equal(t, 2, len(args))
+	err = tree.SetValue(args[1], "/newer")
+	assert.Nilf(t, err, "could not set arg value: %v", err)
+	assert.Equal(t, "/newer", args[1].Value)
+
+	_, err = tree.AddDirective(args[0], "Redirect", nil)
+	assert.NotNil(t, err, "directive must not be added to the argument")
+
+	aliases, _ := tree.FindDirectives(vhost, "ServerAlias")
+	err = tree.Remove(aliases[0])
+	assert.Nilf(t, err, "could not remove directive: %v", err)
+	aliases, _ = tree.FindDirectives(vhost, "ServerAlias")
+	assert.Empty(t, aliases)
+
+	directives, _ := tree.FindDirectives(vhost, "Redirect")
+	assert.Equal(t, 1, len(directives))
+	args, _ = tree.GetArgs(directives[0])
+	assert.Equal(t, "/newer", args[1].Value)
+}
+
+func getTestConfigTree(t *testing.T) (ConfigTree, string) {
+	dir := writeTestConfig(t, map[string]string{"test.conf": testTreeConfig})
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	filePath := filepath.Join(dir, "test.conf")
+
+	return loadTestConfigTree(t, filePath), filePath
+}
+
+func loadTestConfigTree(t *testing.T, filePath string) ConfigTree {
+	backend, err := newBackend(BackendNative, "")
+	assert.Nilf(t, err, "could not create backend: %v", err)
+	t.Cleanup(backend.Close)
+
+	backend.Set("/augeas/load/Httpd/lens", "Httpd.lns")
+	backend.Set("/augeas/load/Httpd/incl", filePath)
+	err = backend.Load()
+	assert.Nilf(t, err, "could not load config: %v", err)
+
+	return newConfigTree(backend)
+}
+
+func TestGetShiftedPath(t *testing.T) {
+	items := []struct {
+		path, removedPath, expected string
+	}{
+		{"/files/a.conf/VirtualHost/Directory[2]", "/files/a.conf/VirtualHost/Directory[1]", "/files/a.conf/VirtualHost/Directory[1]"},
+		{"/files/a.conf/VirtualHost/Directory[3]/Files", "/files/a.conf/VirtualHost/Directory[2]", "/files/a.conf/VirtualHost/Directory[2]/Files"},
+		{"/files/a.conf/VirtualHost/Directory[1]", "/files/a.conf/VirtualHost/Directory[2]", "/files/a.conf/VirtualHost/Directory[1]"},
+		{"/files/a.conf/VirtualHost/Location[2]", "/files/a.conf/VirtualHost/Directory[1]", "/files/a.conf/VirtualHost/Location[2]"},
+		{"/files/a.conf/VirtualHost/Directory", "/files/a.conf/VirtualHost/directive[1]", "/files/a.conf/VirtualHost/Directory"},
+	}
+
+	for _, item := range items {
+		assert.Equal(t, item.expected, getShiftedPath(item.path, item.removedPath))
+	}
+}
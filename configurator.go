@@ -3,9 +3,10 @@ package a2conf
 import (
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
-	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/r2dtools/a2conf/apache"
@@ -24,7 +25,17 @@ const (
 // ApacheConfigurator manipulates with apache configs
 type ApacheConfigurator interface {
 	GetParser() *Parser
+	GetReverter() *Reverter
 	GetVhosts() ([]*entity.VirtualHost, error)
+	GetParseErrors() []*ParseError
+	Dump() (*ConfigDump, error)
+	GetIncludeGraph() (*IncludeGraph, error)
+	RemoveLegacyBackups() ([]string, error)
+	GetVhostSection(vhost *entity.VirtualHost) (*Section, error)
+	GetServerSection() (*Section, error)
+	SetDirective(scope *Section, name string, args []string, options *DirectiveOptions) (*Directive, error)
+	RemoveDirective(scope *Section, name string, argFilter []string) (int, error)
+	EnsureDirective(scope *Section, name string, args []string) (*Directive, error)
 	Save() error
 	DeployCertificate(serverName, certPath, certKeyPath, chainPath, fullChainPath string) error
 	EnableSite(vhost *entity.VirtualHost) error
@@ -34,10 +45,19 @@ type ApacheConfigurator interface {
 	GetSuitableVhosts(serverName string, createIfNoSsl bool) ([]*entity.VirtualHost, error)
 	FindSuitableVhosts(serverName string) ([]*entity.VirtualHost, error)
 	CheckConfiguration() bool
+	TestConfiguration() error
+	Transaction(fn func(configurator ApacheConfigurator) error) error
 	RestartWebServer() error
 	SetLogger(logger logger.Logger)
 	Commit() error
 	Rollback() error
+	SetDryRun(dryRun bool)
+	GetDryRunReport() (*DryRunReport, error)
+	Plan(spec *Spec) (*Plan, error)
+	Apply(plan *Plan) error
+	GetSnapshot() (*Snapshot, error)
+	DetectDrift(snapshot *Snapshot) (*DriftReport, error)
+	Close()
 }
 
 type apacheConfigurator struct {
@@ -45,10 +65,32 @@ type apacheConfigurator struct {
 	reverter *Reverter
 	ctl      *apache.Ctl
 	site     *apache.Site
+	module   *apache.Module
 	logger   logger.Logger
 	version  string
 	vhosts   []*entity.VirtualHost
 	options  map[string]string
+	dryRun   bool
+	planned  []string
+}
+
+// TransactionError is returned when a transaction fails. Err is the reason of the failure,
+// RollbackErr is set if changes could not be rolled back.
+type TransactionError struct {
+	Err         error
+	RollbackErr error
+}
+
+func (te *TransactionError) Error() string {
+	if te.RollbackErr != nil {
+		return fmt.Sprintf("transaction failed: %v; %v", te.Err, te.RollbackErr)
+	}
+
+	return fmt.Sprintf("transaction failed: %v", te.Err)
+}
+
+func (te *TransactionError) Unwrap() error {
+	return te.Err
 }
 
 type vhsotNames struct {
@@ -61,38 +103,69 @@ func (ac *apacheConfigurator) GetParser() *Parser {
 	return ac.parser
 }
 
+// GetReverter returns reverter that tracks changes. Custom rollback actions can be registered via it.
+func (ac *apacheConfigurator) GetReverter() *Reverter {
+	return ac.reverter
+}
+
 // SetLogger sets configurator logger
 func (ac *apacheConfigurator) SetLogger(logger logger.Logger) {
 	ac.logger = logger
 	ac.reverter.SetLogger(logger)
 }
 
+// GetVhostSection returns VirtualHost section of the virtual host to query and change its directives
+func (ac *apacheConfigurator) GetVhostSection(vhost *entity.VirtualHost) (*Section, error) {
+	section, err := GetSection(ac.parser.Tree, vhost.AugPath)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not get section of the virtual host '%s': %v", vhost.ServerName, err)
+	}
+
+	return section, nil
+}
+
+// Dump returns the resolved config with inlined included files, each line is annotated with its source file and line
+func (ac *apacheConfigurator) Dump() (*ConfigDump, error) {
+	return ac.parser.Dump()
+}
+
+// GetIncludeGraph returns the graph of the config files connected by Include directives with the missing and cyclic includes marked
+func (ac *apacheConfigurator) GetIncludeGraph() (*IncludeGraph, error) {
+	return ac.parser.GetIncludeGraph()
+}
+
+// GetParseErrors returns errors of the config files that could not be parsed. Virtual hosts of these files are not available.
+func (ac *apacheConfigurator) GetParseErrors() []*ParseError {
+	return ac.parser.GetParseErrors()
+}
+
 // GetVhosts returns configured Apache vhosts
 func (ac *apacheConfigurator) GetVhosts() ([]*entity.VirtualHost, error) {
 	if ac.vhosts != nil {
 		return ac.vhosts, nil
 	}
 
+	for _, parseErr := range ac.parser.GetParseErrors() {
+		ac.logger.Warn(fmt.Sprintf("virtual hosts of the config file are skipped, it could not be parsed: %v", parseErr))
+	}
+
 	filePaths := make(map[string]string)
 	internalPaths := make(map[string]map[string]bool)
 	var vhosts []*entity.VirtualHost
 
 	for vhostPath := range ac.parser.Paths {
-		paths, err := ac.parser.Augeas.Match(fmt.Sprintf("/files%s//*[label()=~regexp('VirtualHost', 'i')]", vhostPath))
+		sections, err := ac.findVhostSections(vhostPath)
 
 		if err != nil {
 			continue
 		}
 
-		for _, path := range paths {
-			if !strings.Contains(strings.ToLower(path), "virtualhost") {
-				continue
-			}
-
-			vhost, err := ac.createVhost(path)
+		for _, section := range sections {
+			vhost, err := ac.createVhost(section)
 
 			if err != nil {
-				ac.logger.Error(fmt.Sprintf("error occured while creating vhost '%s': %v", vhost.FilePath, err))
+				ac.logger.Error(fmt.Sprintf("error occured while creating vhost '%s': %v", section.Path, err))
 				continue
 			}
 
@@ -148,13 +221,24 @@ func (ac *apacheConfigurator) GetVhosts() ([]*entity.VirtualHost, error) {
 		}
 	}
 
-	ac.vhosts = vhosts
+	macroVhosts, err := ac.getMacroVhosts()
+
+	if err != nil {
+		ac.logger.Error(fmt.Sprintf("could not expand macro virtual hosts: %v", err))
+	}
+
+	ac.vhosts = append(vhosts, macroVhosts...)
 
 	return ac.vhosts, nil
 }
 
-// Save saves all changes
+// Save saves all changes. In dry-run mode changes are kept in the augeas tree only.
 func (ac *apacheConfigurator) Save() error {
+	if ac.dryRun {
+		ac.logger.Debug("dry-run mode: changes are not saved.")
+		return nil
+	}
+
 	err := ac.parser.Save(ac.reverter)
 
 	if err != nil {
@@ -166,12 +250,108 @@ func (ac *apacheConfigurator) Save() error {
 
 // Commit applies all current changes
 func (ac *apacheConfigurator) Commit() error {
-	return ac.reverter.Commit()
+	if err := ac.reverter.Commit(); err != nil {
+		return err
+	}
+
+	ac.parser.commitPaths()
+
+	return nil
 }
 
-// Rollback rollbacks all current changes
+// Rollback rollbacks all current changes.
+// Augeas tree, virtual hosts, modules and variables are reloaded, so the configurator is consistent with the disk again.
 func (ac *apacheConfigurator) Rollback() error {
-	return ac.reverter.Rollback()
+	rollbackErr := ac.reverter.Rollback()
+
+	// reload even if some rollback actions failed: the disk state is changed anyway
+	if err := ac.reload(); err != nil {
+		if rollbackErr != nil {
+			return fmt.Errorf("%v; could not reload configuration: %v", rollbackErr, err)
+		}
+
+		return fmt.Errorf("could not reload configuration after rollback: %v", err)
+	}
+
+	return rollbackErr
+}
+
+// reload drops unsaved changes and cached data and reloads configuration from the disk
+func (ac *apacheConfigurator) reload() error {
+	ac.vhosts = nil
+	ac.planned = nil
+
+	if err := ac.parser.Reload(); err != nil {
+		return err
+	}
+
+	return ac.parser.UpdateRuntime()
+}
+
+// RemoveLegacyBackups removes backups of the config files left next to the original files by previous versions
+// in the server root and the virtual host root and returns their paths. Such backups could be loaded by apache as a live config
+// via wildcard Include directives. It is not called automatically, it should be called once after the upgrade.
+// The configuration is reloaded if any backup is removed, so it should be called before any changes.
+func (ac *apacheConfigurator) RemoveLegacyBackups() ([]string, error) {
+	if ac.dryRun {
+		ac.addDryRunAction("remove legacy backups")
+		return nil, nil
+	}
+
+	var removed []string
+
+	for _, rootPath := range []string{ac.parser.ServerRoot, opts.GetOption(opts.VhostRoot, ac.options)} {
+		backups, err := ac.reverter.RemoveLegacyBackups(rootPath)
+
+		if err != nil {
+			return removed, err
+		}
+
+		removed = append(removed, backups...)
+	}
+
+	if len(removed) > 0 {
+		if err := ac.reload(); err != nil {
+			return removed, fmt.Errorf("could not reload configuration after legacy backups removal: %v", err)
+		}
+	}
+
+	return removed, nil
+}
+
+// Close frees resources of the parser, e.g. removes the temporary directory of the bundled lens.
+// The configurator must not be used after it is closed.
+func (ac *apacheConfigurator) Close() {
+	ac.parser.Close()
+}
+
+// SetDryRun enables or disables dry-run mode. In dry-run mode changes are made in the augeas tree only:
+// nothing is written to the disk and a2ensite/a2enmod utilities are not called.
+// Pending changes can be got via GetDryRunReport and dropped via Rollback.
+func (ac *apacheConfigurator) SetDryRun(dryRun bool) {
+	ac.dryRun = dryRun
+}
+
+// GetDryRunReport returns unified diffs of the pending changes per file and actions that would be made
+func (ac *apacheConfigurator) GetDryRunReport() (*DryRunReport, error) {
+	contents, err := ac.parser.GetUnsavedFilesContent()
+
+	if err != nil {
+		return nil, err
+	}
+
+	diffs, err := getFilesDiffs(contents)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not get diff of pending changes: %v", err)
+	}
+
+	return &DryRunReport{Diffs: diffs, Actions: ac.planned}, nil
+}
+
+func (ac *apacheConfigurator) addDryRunAction(action string) {
+	ac.logger.Debug(fmt.Sprintf("dry-run mode: %s is skipped.", action))
+	ac.planned = append(ac.planned, action)
 }
 
 // DeployCertificate installs certificate to a domain
@@ -216,11 +396,11 @@ func (ac *apacheConfigurator) DeployCertificate(serverName, certPath, certKeyPat
 		}
 
 		if !res || (chainPath != "" && fullChainPath == "") {
-			if err = ac.parser.Augeas.Set(augCertPath[len(augCertPath)-1], certPath); err != nil {
+			if err = ac.setNodeValue(augCertPath[len(augCertPath)-1], certPath); err != nil {
 				return fmt.Errorf("could not set certificate path for vhost '%s': %v", serverName, err)
 			}
 
-			if err = ac.parser.Augeas.Set(augCertKeyPath[len(augCertKeyPath)-1], certKeyPath); err != nil {
+			if err = ac.setNodeValue(augCertKeyPath[len(augCertKeyPath)-1], certKeyPath); err != nil {
 				return fmt.Errorf("could not set certificate key path for vhost '%s': %v", serverName, err)
 			}
 
@@ -236,10 +416,10 @@ func (ac *apacheConfigurator) DeployCertificate(serverName, certPath, certKeyPat
 				return errors.New("SSL certificate fullchain path is required, but is not specified")
 			}
 
-			if err = ac.parser.Augeas.Set(augCertPath[len(augCertPath)-1], fullChainPath); err != nil {
+			if err = ac.setNodeValue(augCertPath[len(augCertPath)-1], fullChainPath); err != nil {
 				return fmt.Errorf("could not set certificate path for vhost '%s': %v", serverName, err)
 			}
-			if err = ac.parser.Augeas.Set(augCertKeyPath[len(augCertKeyPath)-1], certKeyPath); err != nil {
+			if err = ac.setNodeValue(augCertKeyPath[len(augCertKeyPath)-1], certKeyPath); err != nil {
 				return fmt.Errorf("could not set certificate key path for vhost '%s': %v", serverName, err)
 			}
 		}
@@ -261,11 +441,23 @@ func (ac *apacheConfigurator) EnableSite(vhost *entity.VirtualHost) error {
 		return nil
 	}
 
-	// First, try to enable vhost via a2ensite utility
-	err := ac.site.Enable(vhost.GetConfigName())
+	if ac.dryRun {
+		ac.addDryRunAction(fmt.Sprintf("enable site '%s'", vhost.GetConfigName()))
+		vhost.Enabled = true
+		return nil
+	}
+
+	// First, try to enable vhost via a2ensite utility. It changes the live server, so it is not used in offline mode.
+	err := ErrOfflineMode
+
+	if ac.ctl != nil {
+		err = ac.site.Enable(vhost.GetConfigName())
+	}
 
 	if err == nil {
 		ac.reverter.AddSiteConfigToDisable(vhost.GetConfigName())
+		// the site is included via the symlink now
+		ac.parser.addExistingPath(vhost.FilePath)
 		vhost.Enabled = true
 		return nil
 	} else {
@@ -308,12 +500,16 @@ func (ac *apacheConfigurator) PrepareHTTPSModules(temp bool) error {
 		return err
 	}
 
+	if ac.dryRun {
+		return nil
+	}
+
 	// save all changes before
 	if err := ac.Save(); err != nil {
 		return err
 	}
 
-	if err := ac.parser.Augeas.Load(); err != nil {
+	if err := ac.parser.Load(); err != nil {
 		return err
 	}
 
@@ -324,9 +520,34 @@ func (ac *apacheConfigurator) PrepareHTTPSModules(temp bool) error {
 	return nil
 }
 
-// EnableModule enables apache module
+// EnableModule enables apache module via a2enmod utility. The module will be disabled on rollback.
+// If temp is set, the module is disabled on commit as well, i.e. it is enabled until changes are committed or rolled back.
+// Nothing is done if the module is already loaded.
 func (ac *apacheConfigurator) EnableModule(module string, temp bool) error {
-	return fmt.Errorf("apache needs to have module %s active. please install the module manually", module)
+	if _, ok := ac.parser.Modules[module+"_module"]; ok {
+		ac.logger.Debug(fmt.Sprintf("module '%s' is already enabled. Skip module enabling.", module))
+		return nil
+	}
+
+	if ac.dryRun {
+		ac.addDryRunAction(fmt.Sprintf("enable module '%s'", module))
+		ac.parser.AddModule(module)
+		return nil
+	}
+
+	if ac.ctl == nil {
+		return ErrOfflineMode
+	}
+
+	if err := ac.module.Enable(module); err != nil {
+		ac.logger.Debug(err.Error())
+		return fmt.Errorf("apache needs to have module %s active. please install the module manually", module)
+	}
+
+	ac.reverter.AddModuleToDisable(module, temp)
+	ac.parser.AddModule(module)
+
+	return nil
 }
 
 // EnsurePortIsListening ensures that the provided port is listening
@@ -431,10 +652,23 @@ func (ac *apacheConfigurator) removeDirectives(vhPath string, directives []strin
 			return err
 		}
 
-		reg := regexp.MustCompile(`/\w*$`)
-
 		for _, directivePath := range directivePaths {
-			ac.parser.Augeas.Remove(reg.ReplaceAllString(directivePath, ""))
+			// the found paths are paths of the directive arguments
+			arg, err := ac.parser.Tree.GetNode(directivePath)
+
+			if err != nil {
+				return err
+			}
+
+			node, err := ac.parser.Tree.GetParent(arg)
+
+			if err != nil {
+				return err
+			}
+
+			if err = ac.parser.Tree.Remove(node); err != nil {
+				return err
+			}
 		}
 	}
 
@@ -503,7 +737,22 @@ func (ac *apacheConfigurator) GetSuitableVhosts(serverName string, createIfNoSsl
 		return suitableVhosts, nil
 	}
 
-	return ac.makeSslVhosts(suitableVhosts)
+	var vhosts []*entity.VirtualHost
+
+	for _, vhost := range suitableVhosts {
+		if vhost.Macro != "" {
+			ac.logger.Warn(fmt.Sprintf("virtual host '%s' is defined by the macro '%s' in '%s' and could not be modified. Skip it.", serverName, vhost.Macro, vhost.FilePath))
+			continue
+		}
+
+		vhosts = append(vhosts, vhost)
+	}
+
+	if len(vhosts) == 0 {
+		return nil, fmt.Errorf("could not find suitable virtual hosts with ServerName '%s' that are not defined by macros", serverName)
+	}
+
+	return ac.makeSslVhosts(vhosts)
 }
 
 // FindSuitableVhosts tries to find a suitable virtual host for provided serverName.
@@ -563,44 +812,48 @@ func (ac *apacheConfigurator) makeSslVhosts(vhosts []*entity.VirtualHost) ([]*en
 			return nil, fmt.Errorf("could not get config file path for ssl virtual host: %v", err)
 		}
 
-		originMatches, err := ac.parser.Augeas.Match(fmt.Sprintf("/files%s//*[label()=~regexp('VirtualHost', 'i')]", escape(sslFilePath)))
+		originSections, err := ac.findVhostSections(sslFilePath)
 		if err != nil {
 			return nil, err
 		}
 
+		originMatches := getNodePaths(originSections)
+
 		if err = ac.copyCreateSslVhostSkeleton(vhost, sslFilePath); err != nil {
 			return nil, fmt.Errorf("could not create config for ssl virtual host: %v", err)
 		}
 
-		// Reload augeas to take into account the new vhost
-		ac.parser.Augeas.Load()
-		newMatches, err = ac.parser.Augeas.Match(fmt.Sprintf("/files%s//*[label()=~regexp('VirtualHost', 'i')]", escape(sslFilePath)))
+		// Reload the tree to take into account the new vhost
+		// In dry-run mode the new vhost is already in the tree and loading would reset unsaved changes
+		if !ac.dryRun {
+			ac.parser.Load()
+		}
+
+		newSections, err := ac.findVhostSections(sslFilePath)
 
 		if err != nil {
 			return nil, err
 		}
 
+		newMatches = getNodePaths(newSections)
 		sslVhostPath := getNewVhostPathFromAugesMatches(originMatches, newMatches)
-		if sslVhostPath == "" {
-			newMatches, err = ac.parser.Augeas.Match(fmt.Sprintf("/files%s//*[label()=~regexp('VirtualHost', 'i')]", escape(sslFilePath)))
 
-			if err != nil {
-				return nil, err
-			}
+		if sslVhostPath == "" {
+			return nil, errors.New("could not reverse map the HTTPS VirtualHost to the original")
+		}
 
-			sslVhostPath = getNewVhostPathFromAugesMatches(originMatches, newMatches)
+		sslVhostSection, err := ac.parser.Tree.GetNode(sslVhostPath)
 
-			if sslVhostPath == "" {
-				return nil, errors.New("could not reverse map the HTTPS VirtualHost to the original")
-			}
+		if err != nil {
+			return nil, err
 		}
 
-		ac.updateSslVhostAddresses(sslVhostPath)
+		ac.updateSslVhostAddresses(sslVhostSection)
 		if err := ac.Save(); err != nil {
 			return nil, err
 		}
 
-		sslVhost, err := ac.createVhost(sslVhostPath)
+		sslVhost, err := ac.createVhost(sslVhostSection)
 		if err != nil {
 			return nil, err
 		}
@@ -617,20 +870,107 @@ func (ac *apacheConfigurator) makeSslVhosts(vhosts []*entity.VirtualHost) ([]*en
 
 // CheckConfiguration checks if apache configuration is correct
 func (ac *apacheConfigurator) CheckConfiguration() bool {
-	if err := ac.ctl.TestConfiguration(); err != nil {
+	if err := ac.TestConfiguration(); err != nil {
 		return false
 	}
 
 	return true
 }
 
-// RestartWebServer restarts apache web server
+// TestConfiguration checks apache configuration. The returned error contains apachectl output.
+func (ac *apacheConfigurator) TestConfiguration() error {
+	if ac.ctl == nil {
+		return ErrOfflineMode
+	}
+
+	return ac.ctl.TestConfiguration()
+}
+
+// Transaction executes changes made by fn and applies them: saves changes, checks the configuration,
+// restarts the web server and commits changes. If any step fails, all changes are rolled back.
+// In dry-run mode only fn is executed, its changes are kept in the tree for GetDryRunReport.
+func (ac *apacheConfigurator) Transaction(fn func(configurator ApacheConfigurator) error) error {
+	if err := fn(ac); err != nil {
+		return ac.rollbackTransaction(err)
+	}
+
+	// the live configuration is not changed, so there is nothing to test and restart
+	if ac.dryRun {
+		ac.addDryRunAction("configuration test and web server restart")
+		return nil
+	}
+
+	if err := ac.Save(); err != nil {
+		return ac.rollbackTransaction(err)
+	}
+
+	if err := ac.TestConfiguration(); err != nil {
+		return ac.rollbackTransaction(err)
+	}
+
+	if err := ac.RestartWebServer(); err != nil {
+		tErr := ac.rollbackTransaction(err)
+
+		// try to bring the web server back with the restored configuration
+		if rErr := ac.RestartWebServer(); rErr != nil {
+			ac.logger.Error(fmt.Sprintf("could not restart web server after rollback: %v", rErr))
+		}
+
+		return tErr
+	}
+
+	if err := ac.Commit(); err != nil {
+		return &TransactionError{Err: err}
+	}
+
+	return nil
+}
+
+func (ac *apacheConfigurator) rollbackTransaction(err error) error {
+	tErr := &TransactionError{Err: err}
+
+	// Rollback also reloads augeas tree, so unsaved changes do not stay in it
+	if rErr := ac.Rollback(); rErr != nil {
+		tErr.RollbackErr = rErr
+	}
+
+	return tErr
+}
+
+// RestartWebServer restarts apache web server. The web server is not restarted in dry-run mode.
 func (ac *apacheConfigurator) RestartWebServer() error {
+	if ac.ctl == nil {
+		return ErrOfflineMode
+	}
+
+	if ac.dryRun {
+		ac.addDryRunAction("web server restart")
+		return nil
+	}
+
 	return ac.ctl.Restart()
 }
 
 func (ac *apacheConfigurator) copyCreateSslVhostSkeleton(noSslVhost *entity.VirtualHost, sslVhostFilePath string) error {
-	_, err := os.Stat(sslVhostFilePath)
+	noSslVhostContents, err := ac.getVhostBlockContent(noSslVhost)
+
+	if err != nil {
+		return err
+	}
+
+	sslVhostContent, _ := disableDangerousForSslRewriteRules(noSslVhostContents)
+	sslContent := []string{
+		"<IfModule mod_ssl.c>\n",
+		strings.Join(sslVhostContent, "\n"),
+		"</VirtualHost>\n",
+		"</IfModule>\n",
+	}
+
+	if ac.dryRun {
+		return ac.createSslVhostSkeletonInTree(sslVhostFilePath, sslContent)
+	}
+
+	_, err = os.Stat(sslVhostFilePath)
 
 	if os.IsNotExist(err) {
 		ac.reverter.AddFileToDeletion(sslVhostFilePath)
@@ -640,13 +980,6 @@ func (ac *apacheConfigurator) copyCreateSslVhostSkeleton(noSslVhost *entity.Virt
 		return err
 	}
 
-	noSslVhostContents, err := ac.getVhostBlockContent(noSslVhost)
-
-	if err != nil {
-		return err
-	}
-
-	sslVhostContent, _ := disableDangerousForSslRewriteRules(noSslVhostContents)
 	sslVhostFile, err := os.OpenFile(sslVhostFilePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
 
 	if err != nil {
@@ -654,12 +987,6 @@ func (ac *apacheConfigurator) copyCreateSslVhostSkeleton(noSslVhost *entity.Virt
 	}
 
 	defer sslVhostFile.Close()
-	sslContent := []string{
-		"<IfModule mod_ssl.c>\n",
-		strings.Join(sslVhostContent, "\n"),
-		"</VirtualHost>\n",
-		"</IfModule>\n",
-	}
 
 	for _, line := range sslContent {
 		_, err = sslVhostFile.WriteString(line)
@@ -677,14 +1004,40 @@ func (ac *apacheConfigurator) copyCreateSslVhostSkeleton(noSslVhost *entity.Virt
 		}
 	}
 
-	ac.parser.Augeas.Set(fmt.Sprintf("/augeas/files%s/mtime", escape(sslVhostFilePath)), "0")
-	ac.parser.Augeas.Set(fmt.Sprintf("/augeas/files%s/mtime", escape(noSslVhost.FilePath)), "0")
+	ac.parser.invalidateFile(sslVhostFilePath)
+	ac.parser.invalidateFile(noSslVhost.FilePath)
+
+	return nil
+}
+
+// createSslVhostSkeletonInTree creates ssl virtual host in augeas tree only without writing it to the disk
+func (ac *apacheConfigurator) createSslVhostSkeletonInTree(sslVhostFilePath string, sslContent []string) error {
+	var content []byte
+	var err error
+
+	if com.IsFile(sslVhostFilePath) {
+		if content, err = ioutil.ReadFile(sslVhostFilePath); err != nil {
+			return err
+		}
+	}
+
+	content = append(content, []byte(strings.Join(sslContent, ""))...)
+
+	if err = ac.parser.LoadFileContent(sslVhostFilePath, content); err != nil {
+		return fmt.Errorf("could not parse ssl virtual host file '%s': %v", sslVhostFilePath, err)
+	}
 
 	return nil
 }
 
 func (ac *apacheConfigurator) getVhostBlockContent(vhost *entity.VirtualHost) ([]string, error) {
-	span, err := ac.parser.Augeas.Span(vhost.AugPath)
+	section, err := ac.parser.Tree.GetNode(vhost.AugPath)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not get VirtualHost '%s' from the file %s: %v", vhost.ServerName, vhost.FilePath, err)
+	}
+
+	span, err := ac.parser.Tree.GetSpan(section)
 
 	if err != nil {
 		return nil, fmt.Errorf("could not get VirtualHost '%s' from the file %s: %v", vhost.ServerName, vhost.FilePath, err)
@@ -697,13 +1050,13 @@ func (ac *apacheConfigurator) getVhostBlockContent(vhost *entity.VirtualHost) ([
 	}
 
 	defer file.Close()
-	_, err = file.Seek(int64(span.SpanStart), 0)
+	_, err = file.Seek(int64(span.Start), 0)
 
 	if err != nil {
 		return nil, err
 	}
 
-	bContent := make([]byte, span.SpanEnd-span.SpanStart)
+	bContent := make([]byte, span.End-span.Start)
 	_, err = file.Read(bContent)
 
 	if err != nil {
@@ -751,16 +1104,16 @@ func (ac *apacheConfigurator) getSslVhostFilePath(noSslVhostFilePath string) (st
 	return filePath + sslVhostExt, nil
 }
 
-func (ac *apacheConfigurator) updateSslVhostAddresses(sslVhostPath string) ([]*entity.Address, error) {
+func (ac *apacheConfigurator) updateSslVhostAddresses(sslVhostSection *Node) ([]*entity.Address, error) {
 	var sslAddresses []*entity.Address
-	sslAddrMatches, err := ac.parser.Augeas.Match(sslVhostPath + "/arg")
+	args, err := ac.parser.Tree.GetArgs(sslVhostSection)
 
 	if err != nil {
 		return nil, err
 	}
 
-	for _, sslAddrMatch := range sslAddrMatches {
-		addrString, err := ac.parser.GetArg(sslAddrMatch)
+	for _, arg := range args {
+		addrString, err := ac.parser.InterpretArgAt(arg.Value, arg.Path)
 
 		if err != nil {
 			return nil, err
@@ -768,7 +1121,7 @@ func (ac *apacheConfigurator) updateSslVhostAddresses(sslVhostPath string) ([]*e
 
 		oldAddress := entity.CreateVhostAddressFromString(addrString)
 		sslAddress := oldAddress.GetAddressWithNewPort("443") // TODO: it should be passed in an external code
-		err = ac.parser.Augeas.Set(sslAddrMatch, sslAddress.ToString())
+		err = ac.parser.Tree.SetValue(arg, sslAddress.ToString())
 
 		if err != nil {
 			return nil, err
@@ -791,8 +1144,9 @@ func (ac *apacheConfigurator) updateSslVhostAddresses(sslVhostPath string) ([]*e
 	return sslAddresses, nil
 }
 
-func (ac *apacheConfigurator) createVhost(path string) (*entity.VirtualHost, error) {
-	args, err := ac.parser.Augeas.Match(fmt.Sprintf("%s/arg", path))
+func (ac *apacheConfigurator) createVhost(section *Node) (*entity.VirtualHost, error) {
+	path := section.Path
+	args, err := ac.parser.Tree.GetArgs(section)
 
 	if err != nil {
 		return nil, err
@@ -801,18 +1155,18 @@ func (ac *apacheConfigurator) createVhost(path string) (*entity.VirtualHost, err
 	addrs := make(map[string]entity.Address)
 
 	for _, arg := range args {
-		arg, err = ac.parser.GetArg(arg)
+		value, err := ac.parser.InterpretArgAt(arg.Value, arg.Path)
 
 		if err != nil {
 			return nil, err
 		}
 
-		addr := entity.CreateVhostAddressFromString(arg)
+		addr := entity.CreateVhostAddressFromString(value)
 		addrs[addr.GetHash()] = addr
 	}
 
 	var ssl bool
-	sslDirectiveMatches, err := ac.parser.FindDirective("SslEngine", "on", path, false)
+	sslDirectiveMatches, err := ac.parser.FindDirective("SslEngine", "on", path, true)
 
 	if err != nil {
 		return nil, err
@@ -829,25 +1183,25 @@ func (ac *apacheConfigurator) createVhost(path string) (*entity.VirtualHost, err
 		}
 	}
 
-	fPath, err := ac.parser.Augeas.Get(fmt.Sprintf("/augeas/files%s/path", utils.GetFilePathFromAugPath(path)))
+	filename := ac.parser.getFilePath(path)
 
-	if err != nil {
-		return nil, err
+	if filename == "" {
+		return nil, fmt.Errorf("could not detect file of the virtual host '%s'", path)
 	}
 
-	filename := utils.GetFilePathFromAugPath(fPath)
+	macro, err := ac.isWithinSection(section, "Macro")
 
-	if filename == "" {
-		return nil, nil
+	if err != nil {
+		return nil, err
 	}
 
-	var macro bool
+	vhostEnabled := ac.parser.IsFilenameExistInOriginalPaths(filename)
+	active, err := ac.parser.isNodeActive(path)
 
-	if strings.Contains(strings.ToLower(path), "/macro/") {
-		macro = true
+	if err != nil {
+		return nil, err
 	}
 
-	vhostEnabled := ac.parser.IsFilenameExistInOriginalPaths(filename)
 	docRoot, err := ac.getDocumentRoot(path)
 
 	if err != nil {
@@ -861,6 +1215,7 @@ func (ac *apacheConfigurator) createVhost(path string) (*entity.VirtualHost, err
 		Ssl:       ssl,
 		ModMacro:  macro,
 		Enabled:   vhostEnabled,
+		Active:    active,
 		Addresses: addrs,
 	}
 	ac.addServerNames(&virtualhost)
@@ -868,6 +1223,38 @@ func (ac *apacheConfigurator) createVhost(path string) (*entity.VirtualHost, err
 	return &virtualhost, err
 }
 
+// isWithinSection checks if the node is nested in the section with the name
+func (ac *apacheConfigurator) isWithinSection(node *Node, name string) (bool, error) {
+	for {
+		parent, err := ac.parser.Tree.GetParent(node)
+
+		if err != nil {
+			return false, err
+		}
+
+		if parent == nil || parent.Type == NodeFile {
+			return false, nil
+		}
+
+		if parent.Type == NodeSection && strings.EqualFold(parent.Name, name) {
+			return true, nil
+		}
+
+		node = parent
+	}
+}
+
+// findVhostSections returns VirtualHost sections of the file or the files in the directory
+func (ac *apacheConfigurator) findVhostSections(filePath string) ([]*Node, error) {
+	fileNode, err := ac.parser.Tree.GetFileNode(filePath)
+
+	if err != nil || fileNode == nil {
+		return nil, err
+	}
+
+	return ac.parser.Tree.FindSections(fileNode, "VirtualHost")
+}
+
 func (ac *apacheConfigurator) addServerNames(vhost *entity.VirtualHost) error {
 	vhostNames, err := ac.getVhostNames(vhost.AugPath)
 
@@ -951,16 +1338,23 @@ func (ac *apacheConfigurator) getDocumentRoot(path string) (string, error) {
 
 // GetApacheConfigurator returns ApacheConfigurator instance
 func GetApacheConfigurator(options map[string]string) (ApacheConfigurator, error) {
-	ctl, err := getApacheCtl(options)
-
-	if err != nil {
-		return nil, err
-	}
+	var ctl *apache.Ctl
+	offline, _ := strconv.ParseBool(opts.GetOption(opts.Offline, options))
+	version := opts.GetOption(opts.Version, options)
+	var err error
 
-	version, err := ctl.GetVersion()
+	if offline {
+		if version == "" {
+			return nil, errors.New("apache version must be specified in offline mode")
+		}
+	} else {
+		if ctl, err = getApacheCtl(options); err != nil {
+			return nil, err
+		}
 
-	if err != nil {
-		return nil, err
+		if version, err = ctl.GetVersion(); err != nil {
+			return nil, err
+		}
 	}
 
 	isVersionSupported, err := utils.CheckMinVersion(version, minApacheVersion)
@@ -974,11 +1368,20 @@ func GetApacheConfigurator(options map[string]string) (ApacheConfigurator, error
 	}
 
 	// Test apache configuration before creating ApacheConfigurator
-	if err = ctl.TestConfiguration(); err != nil {
-		return nil, err
+	if ctl != nil {
+		if err = ctl.TestConfiguration(); err != nil {
+			return nil, err
+		}
 	}
 
 	log := logger.NilLogger{}
+	reverter := &Reverter{
+		apacheSite:   apache.GetApacheSite(options),
+		apacheModule: apache.GetApacheModule(options),
+		logger:       &log,
+		backupDir:    opts.GetOption(opts.BackupDir, options),
+	}
+
 	parser, err := createParser(ctl, version, options)
 
 	if err != nil {
@@ -987,9 +1390,10 @@ func GetApacheConfigurator(options map[string]string) (ApacheConfigurator, error
 
 	configurator := apacheConfigurator{
 		parser:   parser,
-		reverter: &Reverter{apacheSite: apache.GetApacheSite(options), logger: &log},
+		reverter: reverter,
 		ctl:      ctl,
 		site:     &apache.Site{},
+		module:   apache.GetApacheModule(options),
 		logger:   &log,
 		options:  options,
 		version:  version,
@@ -1010,20 +1414,20 @@ func getApacheCtl(options map[string]string) (*apache.Ctl, error) {
 }
 
 func createParser(apachectl *apache.Ctl, version string, options map[string]string) (*Parser, error) {
-	serverRoot := opts.GetOption(opts.ServerRoot, options)
-	vhostRoot := opts.GetOption(opts.VhostRoot, options)
-	parser, err := GetParser(apachectl, version, serverRoot, vhostRoot)
+	parser, err := GetParserWithOptions(apachectl, version, options)
 
 	if err != nil {
 		return nil, err
 	}
 
+	vhostRoot := opts.GetOption(opts.VhostRoot, options)
 	vhostFiles := opts.GetOption(opts.VhostFiles, options)
 
 	if vhostRoot != "" && vhostFiles != "" {
 		vhostFilesPath := filepath.Join(vhostRoot, vhostFiles)
 
 		if err = parser.ParseFile(vhostFilesPath); err != nil {
+			parser.Close()
 			return nil, err
 		}
 	}
@@ -1145,6 +1549,42 @@ func escape(filePath string) string {
 	return filePath
 }
 
+// unescape removes escaping of the special characters in the path returned by the tree backend
+func unescape(path string) string {
+	var builder strings.Builder
+
+	for i := 0; i < len(path); i++ {
+		if path[i] == '\\' && i+1 < len(path) {
+			i++
+		}
+
+		builder.WriteByte(path[i])
+	}
+
+	return builder.String()
+}
+
+// setNodeValue sets value of the node by its path
+func (ac *apacheConfigurator) setNodeValue(path, value string) error {
+	node, err := ac.parser.Tree.GetNode(path)
+
+	if err != nil {
+		return err
+	}
+
+	return ac.parser.Tree.SetValue(node, value)
+}
+
+func getNodePaths(nodes []*Node) []string {
+	var paths []string
+
+	for _, node := range nodes {
+		paths = append(paths, node.Path)
+	}
+
+	return paths
+}
+
 func getNewVhostPathFromAugesMatches(originMatches []string, newMatches []string) string {
 	var mOriginMatches []string
 
@@ -2,6 +2,7 @@ package a2conf
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"path/filepath"
@@ -9,7 +10,9 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/r2dtools/a2conf/apache"
 	"github.com/r2dtools/a2conf/entity"
+	opts "github.com/r2dtools/a2conf/options"
 	"github.com/stretchr/testify/assert"
 	"github.com/unknwon/com"
 )
@@ -408,6 +411,191 @@ func TestDeployCertificate(t *testing.T) {
 	}
 }
 
+func TestTransactionRollbackOnInvalidConfiguration(t *testing.T) {
+	configurator := getConfigurator(t)
+	vhost := getVhosts(t, configurator, "example2.com")[0]
+	originContent, err := ioutil.ReadFile(vhost.FilePath)
+	assert.Nilf(t, err, "could not read vhost config file '%s' content: %v", vhost.FilePath, err)
+
+	err = configurator.Transaction(func(ac ApacheConfigurator) error {
+		return ac.GetParser().AddDirective(vhost.AugPath, "InvalidDirective", []string{"on"})
+	})
+	assert.NotNil(t, err, "transaction with invalid directive must fail")
+
+	var configErr *apache.ConfigurationError
+	assert.Equal(t, true, errors.As(err, &configErr))
+	assert.Contains(t, configErr.Output, "InvalidDirective")
+
+	content, err := ioutil.ReadFile(vhost.FilePath)
+	assert.Nilf(t, err, "could not read vhost config file '%s' content: %v", vhost.FilePath, err)
+	assert.Equal(t, string(originContent), string(content))
+	assert.Equal(t, true, configurator.CheckConfiguration())
+}
+
+func TestRollbackReloadsConfiguration(t *testing.T) {
+	configurator := getConfigurator(t)
+	vhost := getVhosts(t, configurator, "example2.com")[0]
+	err := configurator.GetParser().Backend.Set(vhost.AugPath+"/directive[last() + 1]", "ServerAlias")
+	assert.Nilf(t, err, "could not add directive: %v", err)
+	err = configurator.GetParser().Backend.Set(vhost.AugPath+"/directive[last()]/arg", "rollback.example2.com")
+	assert.Nilf(t, err, "could not add directive argument: %v", err)
+	err = configurator.Save()
+	assert.Nilf(t, err, "could not save changes: %v", err)
+	configurator.vhosts = nil
+	vhost = getVhosts(t, configurator, "example2.com")[0]
+	assert.Contains(t, vhost.Aliases, "rollback.example2.com")
+
+	err = configurator.Rollback()
+	assert.Nilf(t, err, "could not rollback changes: %v", err)
+	vhost = getVhosts(t, configurator, "example2.com")[0]
+	assert.NotContains(t, vhost.Aliases, "rollback.example2.com")
+	matches, err := configurator.GetParser().FindDirective("ServerAlias", "rollback.example2.com", vhost.AugPath, false)
+	assert.Nilf(t, err, "could not find directive: %v", err)
+	assert.Empty(t, matches)
+	assert.Contains(t, configurator.GetParser().Modules, "ssl_module")
+}
+
+func TestDryRun(t *testing.T) {
+	configurator := getConfigurator(t)
+	configurator.SetDryRun(true)
+	listenConfigPath := configurator.GetParser().СonfigListen
+	originContent, err := ioutil.ReadFile(listenConfigPath)
+	assert.Nilf(t, err, "could not read listen config file '%s' content: %v", listenConfigPath, err)
+
+	err = configurator.EnsurePortIsListening("8081", false)
+	assert.Nilf(t, err, "failed to ensure that port '8081' is listening: %v", err)
+	err = configurator.Save()
+	assert.Nilf(t, err, "could not save changes: %v", err)
+
+	report, err := configurator.GetDryRunReport()
+	assert.Nilf(t, err, "could not get dry-run report: %v", err)
+	assert.Equal(t, 1, len(report.Diffs))
+	assert.Equal(t, listenConfigPath, report.Diffs[0].FilePath)
+	assert.Contains(t, report.Diffs[0].Diff, "+Listen 8081")
+
+	content, err := ioutil.ReadFile(listenConfigPath)
+	assert.Nilf(t, err, "could not read listen config file '%s' content: %v", listenConfigPath, err)
+	assert.Equal(t, string(originContent), string(content))
+	assert.Equal(t, false, com.IsFile(listenConfigPath+".augnew"))
+
+	// report is repeatable, since changes are still pending
+	report, err = configurator.GetDryRunReport()
+	assert.Nilf(t, err, "could not get dry-run report: %v", err)
+	assert.Equal(t, 1, len(report.Diffs))
+
+	err = configurator.Rollback()
+	assert.Nilf(t, err, "could not rollback changes: %v", err)
+	report, err = configurator.GetDryRunReport()
+	assert.Nilf(t, err, "could not get dry-run report: %v", err)
+	assert.Empty(t, report.Diffs)
+}
+
+func TestPlanApply(t *testing.T) {
+	configurator := getConfigurator(t)
+	spec, err := ParseSpec([]byte(`
+sites:
+  - serverName: example-plan.com
+    aliases: [www.example-plan.com]
+    docRoot: /var/www/html
+    proxies:
+      - path: /api
+        url: http://127.0.0.1:8080/
+`))
+	assert.Nilf(t, err, "could not parse spec: %v", err)
+
+	plan, err := configurator.Plan(spec)
+	assert.Nilf(t, err, "could not plan changes: %v", err)
+	assert.Equal(t, 1, len(plan.Changes))
+	assert.Equal(t, PlanCreateVhost, plan.Changes[0].Type)
+
+	err = configurator.Apply(plan)
+	assert.Nilf(t, err, "could not apply plan: %v", err)
+	vhosts := getVhosts(t, configurator, "example-plan.com")
+	assert.Equal(t, "/var/www/html", vhosts[0].DocRoot)
+	assert.Equal(t, true, vhosts[0].Enabled)
+
+	plan, err = configurator.Plan(spec)
+	assert.Nilf(t, err, "could not plan changes: %v", err)
+	assert.Truef(t, plan.IsEmpty(), "plan is not empty after apply: %v", plan.Changes)
+
+	spec.Sites[0].Proxies[0].URL = "http://127.0.0.1:8081/"
+	plan, err = configurator.Plan(spec)
+	assert.Nilf(t, err, "could not plan changes: %v", err)
+	assert.Equal(t, 2, len(plan.Changes))
+	assert.Equal(t, "ProxyPass", plan.Changes[0].Directive)
+	assert.Equal(t, "ProxyPassReverse", plan.Changes[1].Directive)
+
+	err = configurator.Rollback()
+	assert.Nilf(t, err, "could not rollback changes: %v", err)
+	assert.Empty(t, getVhostsByServerName(t, configurator, "example-plan.com"))
+}
+
+func TestDetectDrift(t *testing.T) {
+	configurator := getConfigurator(t)
+	snapshot, err := configurator.GetSnapshot()
+	assert.Nilf(t, err, "could not get snapshot: %v", err)
+	assert.NotEmpty(t, snapshot.Vhosts)
+
+	report, err := configurator.DetectDrift(snapshot)
+	assert.Nilf(t, err, "could not detect drift: %v", err)
+	assert.True(t, report.IsEmpty())
+
+	vhost := getVhosts(t, configurator, "example.com")[0]
+	err = configurator.GetParser().AddDirective(vhost.AugPath, "Redirect", []string{"/old", "/new"})
+	assert.Nilf(t, err, "could not add directive: %v", err)
+	report, err = configurator.DetectDrift(snapshot)
+	assert.Nilf(t, err, "could not detect drift: %v", err)
+	assert.Equal(t, 1, len(report.Modified))
+	assert.Equal(t, []DirectiveSnapshot{{Name: "Redirect", Args: []string{"/old", "/new"}}}, report.Modified[0].AddedDirectives)
+
+	err = configurator.Rollback()
+	assert.Nilf(t, err, "could not rollback changes: %v", err)
+}
+
+func TestGetVhostSection(t *testing.T) {
+	configurator := getConfigurator(t)
+	vhost := getVhosts(t, configurator, "example2.com")[0]
+	section, err := configurator.GetVhostSection(vhost)
+	assert.Nilf(t, err, "could not get vhost section: %v", err)
+	assert.Equal(t, vhost.FilePath, section.FilePath)
+	assert.Equal(t, 1, section.Line)
+
+	directives, err := section.Directives("ServerName")
+	assert.Nilf(t, err, "could not get directives: %v", err)
+	assert.Equal(t, 1, len(directives))
+	assert.Equal(t, []string{"example2.com"}, directives[0].Args)
+	assert.Equal(t, 2, directives[0].Line)
+}
+
+func TestGetServerSection(t *testing.T) {
+	configurator := getConfigurator(t)
+	section, err := configurator.GetServerSection()
+	assert.Nilf(t, err, "could not get server section: %v", err)
+	assert.Equal(t, configurator.parser.ConfigRoot, section.FilePath)
+
+	directive, err := configurator.EnsureDirective(section, "ServerTokens", []string{"Prod"})
+	assert.Nilf(t, err, "could not ensure directive: %v", err)
+	assert.Equal(t, "ServerTokens", directive.Name)
+
+	count, err := configurator.RemoveDirective(section, "ServerTokens", []string{"Prod"})
+	assert.Nilf(t, err, "could not remove directive: %v", err)
+	assert.Equal(t, 1, count)
+}
+
+func getVhostsByServerName(t *testing.T, configurator ApacheConfigurator, serverName string) []*entity.VirtualHost {
+	vhosts, err := configurator.GetVhosts()
+	assert.Nilf(t, err, "could not get vhosts: %v", err)
+	var result []*entity.VirtualHost
+
+	for _, vhost := range vhosts {
+		if vhost.ServerName == serverName {
+			result = append(result, vhost)
+		}
+	}
+
+	return result
+}
+
 func getVhostsJSON(t *testing.T) string {
 	vhostsPath := apacheDir + "/vhosts.json"
 	assert.FileExists(t, vhostsPath, "could not open vhosts file")
@@ -417,9 +605,43 @@ func getVhostsJSON(t *testing.T) string {
 	return prepareStringToCompare(string(data))
 }
 
+func TestOfflineMode(t *testing.T) {
+	configurator := getConfigurator(t)
+	vhosts, err := configurator.GetVhosts()
+	assert.Nilf(t, err, "could not get vhosts: %v", err)
+
+	_, err = GetApacheConfigurator(map[string]string{opts.Offline: "true"})
+	assert.NotNil(t, err, "version must be required in offline mode")
+
+	offlineConfigurator, err := GetApacheConfigurator(map[string]string{opts.Offline: "true", opts.Version: "2.4.41"})
+	assert.Nilf(t, err, "could not create offline apache configurator: %v", err)
+	offlineVhosts, err := offlineConfigurator.GetVhosts()
+	assert.Nilf(t, err, "could not get vhosts: %v", err)
+	assert.NotEmpty(t, offlineVhosts)
+	assert.ElementsMatch(t, vhosts, offlineVhosts)
+
+	parser := offlineConfigurator.GetParser()
+	assert.Contains(t, parser.Modules, "ssl_module")
+	assert.Contains(t, parser.Modules, "mod_ssl.c")
+	assert.Contains(t, parser.Modules, "core_module")
+	assert.Equal(t, configurator.GetParser().Paths, parser.Paths)
+
+	logDir, err := parser.InterpretArg("${APACHE_LOG_DIR}")
+	assert.Nilf(t, err, "could not interpret variable: %v", err)
+	assert.Equal(t, "/var/log/apache2", logDir)
+
+	logDir, err = configurator.GetParser().InterpretArg("${APACHE_LOG_DIR}")
+	assert.Nilf(t, err, "environment variables must be known in online mode: %v", err)
+	assert.Equal(t, "/var/log/apache2", logDir)
+
+	assert.Equal(t, ErrOfflineMode, offlineConfigurator.TestConfiguration())
+	assert.Equal(t, ErrOfflineMode, offlineConfigurator.EnableModule("rewrite", false))
+}
+
 func getConfigurator(t *testing.T) *apacheConfigurator {
 	configurator, err := GetApacheConfigurator(nil)
 	assert.Nil(t, err, fmt.Sprintf("could not creatre apache configurator: %v", err))
+	t.Cleanup(configurator.Close)
 
 	return configurator.(*apacheConfigurator)
 }
@@ -0,0 +1,231 @@
+package a2conf
+
+import (
+	"fmt"
+	"strings"
+)
+
+// defineWalker evaluates Define and UnDefine directives while the config is walked and records defines active at each directive and section
+type defineWalker struct {
+	baseVisitor
+	parser *Parser
+	// defines are active defines. The map is copied on change, since it is shared by the recorded positions.
+	defines map[string]string
+	// positions are defines active at the nodes by their position keys, see getPositionKey
+	positions map[string]map[string]string
+	// declared are names of the defines declared or undeclared in the config
+	declared map[string]bool
+	modules  map[string]bool
+	// includes are patterns of the active Include directives whose files are not loaded into the tree.
+	// The walk does not change the tree, so they are loaded after it.
+	includes []string
+}
+
+// loadDefines walks the config starting with the initial defines, e.g. the defines of the command line.
+// Included files which are not loaded yet are loaded and the config is walked again, since they could declare defines too.
+// The defines active after the config is read and the names of the defines declared in the config are returned.
+func (p *Parser) loadDefines(initial map[string]string) (map[string]string, map[string]bool, error) {
+	loaded := make(map[string]bool)
+
+	for {
+		w, err := p.walkDefines(initial)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		var includes []string
+
+		for _, pattern := range w.includes {
+			if !loaded[pattern] {
+				includes = append(includes, pattern)
+			}
+		}
+
+		if len(includes) == 0 {
+			return w.defines, w.declared, nil
+		}
+
+		for _, pattern := range includes {
+			loaded[pattern] = true
+
+			if err = p.ParseFile(pattern); err != nil {
+				return nil, nil, fmt.Errorf("could not load included files '%s': %v", pattern, err)
+			}
+		}
+	}
+}
+
+// updateDefinePositions walks the loaded config again to update the defines active at the nodes if the tree is reloaded.
+// Nothing is done until the defines are loaded.
+func (p *Parser) updateDefinePositions() error {
+	if p.initialDefines == nil || !p.isDefinesDirty {
+		return nil
+	}
+
+	w, err := p.walkDefines(p.initialDefines)
+
+	if err != nil {
+		return fmt.Errorf("could not evaluate defines: %v", err)
+	}
+
+	p.variables = w.defines
+
+	return nil
+}
+
+// walkDefines walks the loaded config and records defines active at the nodes
+func (p *Parser) walkDefines(initial map[string]string) (*defineWalker, error) {
+	w := &defineWalker{
+		parser:    p,
+		defines:   initial,
+		positions: make(map[string]map[string]string),
+		declared:  make(map[string]bool),
+		modules:   make(map[string]bool),
+	}
+
+	for module := range p.Modules {
+		w.modules[module] = true
+	}
+
+	for _, module := range p.getStaticModules() {
+		w.addModule(module)
+	}
+
+	if err := p.walkConfig(w); err != nil {
+		return nil, err
+	}
+
+	p.definePositions = w.positions
+	p.isDefinesDirty = false
+
+	return w, nil
+}
+
+// getDefinesAt returns defines active at the node. Defines of the parent are returned for the nodes created after the config is walked.
+// Final defines are returned for the nodes outside of the loaded config.
+func (p *Parser) getDefinesAt(path string) (map[string]string, error) {
+	if err := p.updateDefinePositions(); err != nil {
+		return nil, err
+	}
+
+	for path != "" {
+		if defines, ok := p.definePositions[p.getPositionKey(path)]; ok {
+			return defines, nil
+		}
+
+		index := strings.LastIndex(path, "/")
+
+		if index == -1 {
+			break
+		}
+
+		path = path[:index]
+	}
+
+	return p.variables, nil
+}
+
+// getPositionKey returns a key of the node which is not changed when the other nodes are added or removed.
+// It is the position of the node in the file. Positional tree paths like directive[5] are used only for the nodes created in the tree.
+func (p *Parser) getPositionKey(path string) string {
+	span, err := p.Backend.Span(path)
+
+	if err != nil {
+		return path
+	}
+
+	return fmt.Sprintf("%s:%d", span.Filename, span.SpanStart)
+}
+
+func (w *defineWalker) visitDirective(node *Node, args []string) error {
+	w.positions[w.parser.getPositionKey(node.Path)] = w.defines
+
+	if len(args) == 0 {
+		return nil
+	}
+
+	switch strings.ToLower(node.Name) {
+	case "define":
+		value := ""
+
+		if len(args) > 1 {
+			value = args[1]
+		}
+
+		w.setDefine(args[0], &value)
+	case "undefine":
+		w.setDefine(args[0], nil)
+	case "loadmodule":
+		w.addModule(strings.TrimSuffix(args[0], "_module"))
+	}
+
+	return nil
+}
+
+func (w *defineWalker) visitInclude(node *Node, arg, pattern string, filePaths []string) error {
+	for _, includePattern := range getIncludePatterns(pattern, filePaths) {
+		if !w.parser.IsFilenameExistInCurrentPaths(includePattern) {
+			w.includes = append(w.includes, includePattern)
+		}
+	}
+
+	return nil
+}
+
+// enterSection checks if directives of the section are read by apache. Macro definitions are read only at the place of Use directive.
+func (w *defineWalker) enterSection(node *Node, args []string) (bool, error) {
+	w.positions[w.parser.getPositionKey(node.Path)] = w.defines
+
+	if strings.EqualFold(node.Name, "macro") {
+		return false, nil
+	}
+
+	return w.parser.isSectionActive(node.Name, args, w.defines, w.modules)
+}
+
+func (w *defineWalker) getArgs(node *Node) ([]string, error) {
+	argNodes, err := w.parser.Tree.GetArgs(node)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var args []string
+
+	for _, argNode := range argNodes {
+		value, err := w.parser.interpretArg(argNode.Value, w.defines)
+
+		// apache keeps unknown variables as is
+		if err != nil {
+			value = argNode.Value
+		}
+
+		args = append(args, value)
+	}
+
+	return args, nil
+}
+
+// setDefine defines the variable or undefines it if the value is nil
+func (w *defineWalker) setDefine(name string, value *string) {
+	defines := make(map[string]string)
+
+	for dName, dValue := range w.defines {
+		defines[dName] = dValue
+	}
+
+	if value == nil {
+		delete(defines, name)
+	} else {
+		defines[name] = *value
+	}
+
+	w.defines = defines
+	w.declared[name] = true
+}
+
+func (w *defineWalker) addModule(name string) {
+	w.modules[name+"_module"] = true
+	w.modules["mod_"+name+".c"] = true
+}
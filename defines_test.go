@@ -0,0 +1,100 @@
+package a2conf
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testDefinesConfig = `ErrorLog /var/log/${LOG_NAME}.log
+Define SITE one
+Define LOG_NAME site
+Include conf.d/*.conf
+ServerName ${SITE}.example.com
+UnDefine SITE
+<IfDefine SITE>
+	ServerAdmin admin@example.com
+</IfDefine>
+<IfDefine !SITE>
+	ServerAlias alias.example.com
+</IfDefine>
+Define SITE two
+DocumentRoot /var/www/${SITE}
+`
+
+func TestDefinesOrder(t *testing.T) {
+	runWithBackends(t, func(t *testing.T, backend string) {
+		parser, dir := getTestParser(t, backend, map[string]string{
+			"apache2.conf":       testDefinesConfig,
+			"conf.d/ports.conf":  "Define PORT 8080\nListen ${PORT}\n",
+			"conf.d/server.conf": "ServerSignature ${PORT}\n",
+		})
+		defer os.RemoveAll(dir)
+		defer parser.Close()
+
+		getArg := func(directive string) (string, error) {
+			matches, err := parser.FindDirective(directive, "", "", true)
+			assert.Nilf(t, err, "could not find directive %s: %v", directive, err)
+			assert.Equal(t, 1, len(matches), directive)
+
+			if len(matches) != 1 {
+				return "", nil
+			}
+
+			return parser.GetArg(matches[0])
+		}
+
+		_, err := getArg("ErrorLog")
+		assert.NotNil(t, err, "variable must not be resolved before it is defined")
+
+		expected := map[string]string{
+			"ServerName":      "one.example.com",
+			"DocumentRoot":    "/var/www/two",
+			"Listen":          "8080",
+			"ServerSignature": "8080",
+			"ServerAlias":     "alias.example.com",
+		}
+
+		for directive, expectedValue := range expected {
+			value, err := getArg(directive)
+			assert.Nilf(t, err, "could not get %s argument: %v", directive, err)
+			assert.Equal(t, expectedValue, value)
+		}
+
+		matches, err := parser.FindDirective("ServerAdmin", "", "", true)
+		assert.Nilf(t, err, "could not find directive: %v", err)
+		assert.Empty(t, matches, "IfDefine must use the defines active at the section")
+
+		value, err := parser.InterpretArg("${SITE}-${LOG_NAME}-${PORT}")
+		assert.Nilf(t, err, "could not interpret argument: %v", err)
+		assert.Equal(t, "two-site-8080", value)
+
+		// positional paths of the directives are changed, but they keep their defines
+		matches, err = parser.FindDirective("ErrorLog", "", "", true)
+		assert.Nilf(t, err, "could not find directive: %v", err)
+		errorLog, err := parser.Tree.GetNode(matches[0][:strings.LastIndex(matches[0], "/arg")])
+		assert.Nilf(t, err, "could not get directive: %v", err)
+		err = parser.Tree.Remove(errorLog)
+		assert.Nilf(t, err, "could not remove directive: %v", err)
+
+		for _, directive := range []string{"ServerName", "DocumentRoot"} {
+			value, err = getArg(directive)
+			assert.Nilf(t, err, "could not get %s argument: %v", directive, err)
+			assert.Equal(t, expected[directive], value)
+		}
+
+		err = parser.Save(nil)
+		assert.Nilf(t, err, "could not save config: %v", err)
+		assert.True(t, parser.isDefinesDirty, "define positions must be updated on lookup, not on load")
+
+		for _, directive := range []string{"ServerName", "DocumentRoot"} {
+			value, err = getArg(directive)
+			assert.Nilf(t, err, "could not get %s argument after save: %v", directive, err)
+			assert.Equal(t, expected[directive], value)
+		}
+
+		assert.False(t, parser.isDefinesDirty)
+	})
+}
@@ -0,0 +1,143 @@
+package a2conf
+
+import (
+	"fmt"
+
+	"github.com/unknwon/com"
+)
+
+// DirectiveOptions specifies how SetDirective changes the directive
+type DirectiveOptions struct {
+	// ReplaceAll replaces all occurrences of the directive with a single one, otherwise only the last occurrence is changed
+	ReplaceAll bool
+	// Before is a name of the anchor directive. A new directive is inserted before its first occurrence.
+	Before string
+	// After is a name of the anchor directive. A new directive is inserted after its last occurrence.
+	After string
+}
+
+// GetServerSection returns the root section of the main config file, i.e. the server context
+func (ac *apacheConfigurator) GetServerSection() (*Section, error) {
+	return GetFileSection(ac.parser.Tree, ac.parser.ConfigRoot)
+}
+
+// SetDirective sets arguments of the directive within the scope section, e.g. a virtual host, <Directory> or <Location>.
+// If the directive does not exist, it is created next to the anchor directive specified in options or appended to the scope.
+func (ac *apacheConfigurator) SetDirective(scope *Section, name string, args []string, options *DirectiveOptions) (*Directive, error) {
+	if options == nil {
+		options = &DirectiveOptions{}
+	}
+
+	directives, err := scope.Directives(name)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not find '%s' directives: %v", name, err)
+	}
+
+	ac.vhosts = nil
+
+	if len(directives) == 0 {
+		return ac.addDirective(scope, name, args, options)
+	}
+
+	directive := directives[len(directives)-1]
+
+	if options.ReplaceAll {
+		directive = directives[0]
+
+		// directives are removed from the end, so paths of the preceding ones stay valid
+		for i := len(directives) - 1; i > 0; i-- {
+			if err = directives[i].Remove(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err = directive.Set(args...); err != nil {
+		return nil, err
+	}
+
+	return directive, nil
+}
+
+// RemoveDirective removes directives within the scope section. If argFilter is specified,
+// only directives whose arguments start with argFilter are removed. The number of the removed directives is returned.
+func (ac *apacheConfigurator) RemoveDirective(scope *Section, name string, argFilter []string) (int, error) {
+	directives, err := scope.Directives(name)
+
+	if err != nil {
+		return 0, fmt.Errorf("could not find '%s' directives: %v", name, err)
+	}
+
+	var count int
+
+	// directives are removed from the end, so paths of the preceding ones stay valid
+	for i := len(directives) - 1; i >= 0; i-- {
+		directive := directives[i]
+
+		if len(directive.Args) < len(argFilter) || !com.CompareSliceStr(directive.Args[:len(argFilter)], argFilter) {
+			continue
+		}
+
+		if err = directive.Remove(); err != nil {
+			return count, err
+		}
+
+		count++
+	}
+
+	if count > 0 {
+		ac.vhosts = nil
+	}
+
+	return count, nil
+}
+
+// EnsureDirective appends the directive to the scope section if there is no directive with the same arguments.
+// Calling it repeatedly does not change the config.
+func (ac *apacheConfigurator) EnsureDirective(scope *Section, name string, args []string) (*Directive, error) {
+	directives, err := scope.Directives(name)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not find '%s' directives: %v", name, err)
+	}
+
+	for _, directive := range directives {
+		if com.CompareSliceStr(directive.Args, args) {
+			return directive, nil
+		}
+	}
+
+	ac.vhosts = nil
+
+	return ac.addDirective(scope, name, args, &DirectiveOptions{})
+}
+
+// addDirective adds a new directive next to the anchor directive or appends it to the scope if there is no anchor
+func (ac *apacheConfigurator) addDirective(scope *Section, name string, args []string, options *DirectiveOptions) (*Directive, error) {
+	if options.Before != "" {
+		anchors, err := scope.Directives(options.Before)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if len(anchors) > 0 {
+			return anchors[0].InsertBefore(name, args...)
+		}
+	}
+
+	if options.After != "" {
+		anchors, err := scope.Directives(options.After)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if len(anchors) > 0 {
+			return anchors[len(anchors)-1].InsertAfter(name, args...)
+		}
+	}
+
+	return scope.AddDirective(name, args...)
+}
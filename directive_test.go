@@ -0,0 +1,104 @@
+package a2conf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetDirective(t *testing.T) {
+	ac := &apacheConfigurator{}
+	vhost := getTestVhostSection(t)
+
+	_, err := ac.SetDirective(vhost, "ServerName", []string{"example2.com"}, nil)
+	assert.Nilf(t, err, "could not set directive: %v", err)
+	assertDirectiveArgs(t, vhost, "ServerName", [][]string{{"example2.com"}})
+
+	_, err = ac.SetDirective(vhost, "ServerAdmin", []string{"admin@example.com"}, &DirectiveOptions{Before: "ServerName"})
+	assert.Nilf(t, err, "could not set directive: %v", err)
+	_, err = ac.SetDirective(vhost, "DocumentRoot", []string{"/var/www/html"}, &DirectiveOptions{After: "ServerAlias"})
+	assert.Nilf(t, err, "could not set directive: %v", err)
+	_, err = ac.SetDirective(vhost, "DirectoryIndex", []string{"index.html"}, &DirectiveOptions{After: "Unknown"})
+	assert.Nilf(t, err, "could not set directive: %v", err)
+	assert.Equal(t, []string{"ServerAdmin", "ServerName", "ServerAlias", "DocumentRoot", "Directory", "DirectoryIndex"}, getChildrenNames(t, vhost))
+
+	vhost.AddDirective("ServerAlias", "www2.example.com")
+	_, err = ac.SetDirective(vhost, "ServerAlias", []string{"www3.example.com"}, nil)
+	assert.Nilf(t, err, "could not set directive: %v", err)
+	assertDirectiveArgs(t, vhost, "ServerAlias", [][]string{{"www.example.com"}, {"www3.example.com"}})
+
+	_, err = ac.SetDirective(vhost, "ServerAlias", []string{"www4.example.com"}, &DirectiveOptions{ReplaceAll: true})
+	assert.Nilf(t, err, "could not set directive: %v", err)
+	assertDirectiveArgs(t, vhost, "ServerAlias", [][]string{{"www4.example.com"}})
+}
+
+func TestRemoveDirective(t *testing.T) {
+	ac := &apacheConfigurator{}
+	vhost := getTestVhostSection(t)
+	vhost.AddDirective("Header", "set", "X-Frame-Options", "DENY")
+	vhost.AddDirective("Header", "set", "X-Content-Type-Options", "nosniff")
+	vhost.AddDirective("Header", "unset", "ETag")
+
+	count, err := ac.RemoveDirective(vhost, "Header", []string{"set", "X-Frame-Options"})
+	assert.Nilf(t, err, "could not remove directive: %v", err)
+	assert.Equal(t, 1, count)
+	assertDirectiveArgs(t, vhost, "Header", [][]string{{"set", "X-Content-Type-Options", "nosniff"}, {"unset", "ETag"}})
+
+	count, err = ac.RemoveDirective(vhost, "header", nil)
+	assert.Nilf(t, err, "could not remove directive: %v", err)
+	assert.Equal(t, 2, count)
+	assertDirectiveArgs(t, vhost, "Header", nil)
+}
+
+func TestEnsureDirective(t *testing.T) {
+	ac := &apacheConfigurator{}
+	vhost := getTestVhostSection(t)
+
+	for i := 0; i < 2; i++ {
+		_, err := ac.EnsureDirective(vhost, "ServerAlias", []string{"www.example.com"})
+		assert.Nilf(t, err, "could not ensure directive: %v", err)
+		_, err = ac.EnsureDirective(vhost, "ServerAlias", []string{"www2.example.com"})
+		assert.Nilf(t, err, "could not ensure directive: %v", err)
+	}
+
+	assertDirectiveArgs(t, vhost, "ServerAlias", [][]string{{"www.example.com"}, {"www2.example.com"}})
+}
+
+func getTestVhostSection(t *testing.T) *Section {
+	tree, filePath := getTestConfigTree(t)
+	fileSection, err := GetFileSection(tree, filePath)
+	assert.Nilf(t, err, "could not get file section: %v", err)
+	vhosts, err := fileSection.Sections("VirtualHost")
+	assert.Nilf(t, err, "could not get vhost section: %v", err)
+
+	return vhosts[0]
+}
+
+func assertDirectiveArgs(t *testing.T, section *Section, name string, expected [][]string) {
+	directives, err := section.Directives(name)
+	assert.Nilf(t, err, "could not get directives: %v", err)
+	var args [][]string
+
+	for _, directive := range directives {
+		args = append(args, directive.Args)
+	}
+
+	assert.Equal(t, expected, args)
+}
+
+func getChildrenNames(t *testing.T, section *Section) []string {
+	children, err := section.Children()
+	assert.Nilf(t, err, "could not get children: %v", err)
+	var names []string
+
+	for _, child := range children {
+		switch child := child.(type) {
+		case *Directive:
+			names = append(names, child.Name)
+		case *Section:
+			names = append(names, child.Name)
+		}
+	}
+
+	return names
+}
@@ -0,0 +1,320 @@
+package a2conf
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/r2dtools/a2conf/entity"
+)
+
+// Snapshot is a stored state of the virtual hosts used to detect drift of the live configuration
+type Snapshot struct {
+	Vhosts []VhostSnapshot
+}
+
+// VhostSnapshot is a virtual host with all its directives
+type VhostSnapshot struct {
+	entity.VirtualHost
+	Directives []DirectiveSnapshot
+}
+
+// DirectiveSnapshot is a directive of a virtual host.
+// Section is a path of the nested sections the directive belongs to, e.g. "Directory /var/www > IfModule mod_rewrite.c".
+// Args are stored as they are written in the config, i.e. variables are not expanded.
+type DirectiveSnapshot struct {
+	Section string
+	Name    string
+	Args    []string
+}
+
+// DriftReport contains differences between a snapshot and the live configuration
+type DriftReport struct {
+	Added    []VhostSnapshot
+	Removed  []VhostSnapshot
+	Modified []VhostDrift
+}
+
+// VhostDrift contains changes of a virtual host
+type VhostDrift struct {
+	FilePath           string
+	ServerName         string
+	Fields             []FieldDrift
+	AddedDirectives    []DirectiveSnapshot
+	RemovedDirectives  []DirectiveSnapshot
+	ModifiedDirectives []DirectiveDrift
+}
+
+// FieldDrift is a changed field of a virtual host
+type FieldDrift struct {
+	Name string
+	Old  string
+	New  string
+}
+
+// DirectiveDrift is a directive which arguments were changed
+type DirectiveDrift struct {
+	Old DirectiveSnapshot
+	New DirectiveSnapshot
+}
+
+// ParseSnapshot parses snapshot stored in JSON format
+func ParseSnapshot(data []byte) (*Snapshot, error) {
+	var snapshot Snapshot
+
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("could not parse snapshot: %v", err)
+	}
+
+	return &snapshot, nil
+}
+
+// IsEmpty checks if there is no drift
+func (r *DriftReport) IsEmpty() bool {
+	return len(r.Added) == 0 && len(r.Removed) == 0 && len(r.Modified) == 0
+}
+
+// String returns directive as it is written in the config
+func (d DirectiveSnapshot) String() string {
+	directive := strings.TrimSpace(d.Name + " " + strings.Join(d.Args, " "))
+
+	if d.Section == "" {
+		return directive
+	}
+
+	return d.Section + " > " + directive
+}
+
+// GetSnapshot returns the current state of the virtual hosts
+func (ac *apacheConfigurator) GetSnapshot() (*Snapshot, error) {
+	vhosts, err := ac.GetVhosts()
+
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := &Snapshot{}
+
+	for _, vhost := range vhosts {
+		section, err := ac.parser.Tree.GetNode(vhost.AugPath)
+
+		if err != nil {
+			return nil, fmt.Errorf("could not get section of vhost '%s': %v", vhost.FilePath, err)
+		}
+
+		directives, err := ac.getSectionDirectives(section, "")
+
+		if err != nil {
+			return nil, fmt.Errorf("could not get directives of vhost '%s': %v", vhost.FilePath, err)
+		}
+
+		vhostSnapshot := VhostSnapshot{VirtualHost: *vhost, Directives: directives}
+		// snapshot must not depend on the other virtual hosts
+		vhostSnapshot.Ancestor = nil
+		snapshot.Vhosts = append(snapshot.Vhosts, vhostSnapshot)
+	}
+
+	return snapshot, nil
+}
+
+// DetectDrift compares the snapshot with the live configuration
+func (ac *apacheConfigurator) DetectDrift(snapshot *Snapshot) (*DriftReport, error) {
+	current, err := ac.GetSnapshot()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return CompareSnapshots(snapshot, current), nil
+}
+
+// CompareSnapshots returns added, removed and modified virtual hosts of the current snapshot relative to the old one
+func CompareSnapshots(old, current *Snapshot) *DriftReport {
+	report := &DriftReport{}
+	oldVhosts := make(map[string]VhostSnapshot)
+	currentVhosts := make(map[string]VhostSnapshot)
+
+	for _, vhost := range old.Vhosts {
+		oldVhosts[getVhostSnapshotKey(&vhost)] = vhost
+	}
+
+	for _, vhost := range current.Vhosts {
+		key := getVhostSnapshotKey(&vhost)
+		currentVhosts[key] = vhost
+		oldVhost, ok := oldVhosts[key]
+
+		if !ok {
+			report.Added = append(report.Added, vhost)
+			continue
+		}
+
+		if drift := compareVhostSnapshots(&oldVhost, &vhost); drift != nil {
+			report.Modified = append(report.Modified, *drift)
+		}
+	}
+
+	for _, vhost := range old.Vhosts {
+		if _, ok := currentVhosts[getVhostSnapshotKey(&vhost)]; !ok {
+			report.Removed = append(report.Removed, vhost)
+		}
+	}
+
+	return report
+}
+
+// getSectionDirectives recursively collects directives of the section
+func (ac *apacheConfigurator) getSectionDirectives(sectionNode *Node, section string) ([]DirectiveSnapshot, error) {
+	children, err := ac.parser.Tree.GetChildren(sectionNode)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var directives []DirectiveSnapshot
+
+	for _, child := range children {
+		if child.Type == NodeComment {
+			continue
+		}
+
+		args, err := ac.getNodeArgs(child)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if child.Type == NodeDirective {
+			directives = append(directives, DirectiveSnapshot{Section: section, Name: child.Name, Args: args})
+			continue
+		}
+
+		nestedSection := strings.TrimSpace(child.Name + " " + strings.Join(args, " "))
+
+		if section != "" {
+			nestedSection = section + " > " + nestedSection
+		}
+
+		nestedDirectives, err := ac.getSectionDirectives(child, nestedSection)
+
+		if err != nil {
+			return nil, err
+		}
+
+		directives = append(directives, nestedDirectives...)
+	}
+
+	return directives, nil
+}
+
+func (ac *apacheConfigurator) getNodeArgs(node *Node) ([]string, error) {
+	argNodes, err := ac.parser.Tree.GetArgs(node)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var args []string
+
+	for _, argNode := range argNodes {
+		args = append(args, argNode.Value)
+	}
+
+	return args, nil
+}
+
+// getVhostSnapshotKey returns virtual host identifier. AugPath is not used since it depends on the position of the vhost in the file.
+func getVhostSnapshotKey(vhost *VhostSnapshot) string {
+	return fmt.Sprintf("%s|%s|%s", vhost.FilePath, vhost.ServerName, getSortedAddresses(&vhost.VirtualHost))
+}
+
+func getSortedAddresses(vhost *entity.VirtualHost) string {
+	var addresses []string
+
+	for _, address := range vhost.Addresses {
+		addresses = append(addresses, address.ToString())
+	}
+
+	sort.Strings(addresses)
+
+	return strings.Join(addresses, " ")
+}
+
+func compareVhostSnapshots(old, current *VhostSnapshot) *VhostDrift {
+	drift := &VhostDrift{FilePath: current.FilePath, ServerName: current.ServerName}
+	aliases := func(vhost *VhostSnapshot) string {
+		aliases := append([]string{}, vhost.Aliases...)
+		sort.Strings(aliases)
+
+		return strings.Join(aliases, " ")
+	}
+	fields := []FieldDrift{
+		{Name: "DocRoot", Old: old.DocRoot, New: current.DocRoot},
+		{Name: "Aliases", Old: aliases(old), New: aliases(current)},
+		{Name: "Ssl", Old: fmt.Sprint(old.Ssl), New: fmt.Sprint(current.Ssl)},
+		{Name: "Enabled", Old: fmt.Sprint(old.Enabled), New: fmt.Sprint(current.Enabled)},
+		{Name: "ModMacro", Old: fmt.Sprint(old.ModMacro), New: fmt.Sprint(current.ModMacro)},
+		{Name: "Macro", Old: old.Macro, New: current.Macro},
+	}
+
+	for _, field := range fields {
+		if field.Old != field.New {
+			drift.Fields = append(drift.Fields, field)
+		}
+	}
+
+	removed := subtractDirectives(old.Directives, current.Directives)
+	added := subtractDirectives(current.Directives, old.Directives)
+
+	// a removed directive paired with an added one with the same name within the same section is a modified directive
+	for _, rDirective := range removed {
+		index := -1
+
+		for i, aDirective := range added {
+			if aDirective.Section == rDirective.Section && strings.EqualFold(aDirective.Name, rDirective.Name) {
+				index = i
+				break
+			}
+		}
+
+		if index == -1 {
+			drift.RemovedDirectives = append(drift.RemovedDirectives, rDirective)
+			continue
+		}
+
+		drift.ModifiedDirectives = append(drift.ModifiedDirectives, DirectiveDrift{Old: rDirective, New: added[index]})
+		added = append(added[:index], added[index+1:]...)
+	}
+
+	drift.AddedDirectives = added
+
+	if len(drift.Fields) == 0 && len(drift.AddedDirectives) == 0 && len(drift.RemovedDirectives) == 0 && len(drift.ModifiedDirectives) == 0 {
+		return nil
+	}
+
+	return drift
+}
+
+// subtractDirectives returns directives of the first list that are absent in the second one. Duplicates are counted.
+func subtractDirectives(directives, subtrahend []DirectiveSnapshot) []DirectiveSnapshot {
+	counts := make(map[string]int)
+
+	for _, directive := range subtrahend {
+		counts[directive.String()]++
+	}
+
+	var result []DirectiveSnapshot
+
+	for _, directive := range directives {
+		key := directive.String()
+
+		if counts[key] > 0 {
+			counts[key]--
+			continue
+		}
+
+		result = append(result, directive)
+	}
+
+	return result
+}
@@ -0,0 +1,68 @@
+package a2conf
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompareSnapshots(t *testing.T) {
+	old := getTestSnapshot(t)
+	old.Vhosts[0].Directives = []DirectiveSnapshot{
+		{Name: "ServerName", Args: []string{"example5.com"}},
+		{Name: "DocumentRoot", Args: []string{"/var/www/html"}},
+		{Section: "Directory /var/www/html", Name: "AllowOverride", Args: []string{"None"}},
+	}
+
+	current := getTestSnapshot(t)
+	current.Vhosts[0].Directives = []DirectiveSnapshot{
+		{Name: "ServerName", Args: []string{"example5.com"}},
+		{Name: "DocumentRoot", Args: []string{"/var/www/example5"}},
+		{Name: "Redirect", Args: []string{"/old", "/new"}},
+	}
+	current.Vhosts[0].DocRoot = "/var/www/example5"
+	removedVhost := current.Vhosts[1]
+	current.Vhosts = append(current.Vhosts[:1], current.Vhosts[2:]...)
+
+	report := CompareSnapshots(old, current)
+	assert.False(t, report.IsEmpty())
+	assert.Empty(t, report.Added)
+	assert.Equal(t, 1, len(report.Removed))
+	assert.Equal(t, removedVhost.FilePath, report.Removed[0].FilePath)
+	assert.Equal(t, 1, len(report.Modified))
+
+	drift := report.Modified[0]
+	assert.Equal(t, "example5.com", drift.ServerName)
+	assert.Equal(t, []FieldDrift{{Name: "DocRoot", Old: "/var/www/html", New: "/var/www/example5"}}, drift.Fields)
+	assert.Equal(t, []DirectiveSnapshot{{Name: "Redirect", Args: []string{"/old", "/new"}}}, drift.AddedDirectives)
+	assert.Equal(t, []DirectiveSnapshot{{Section: "Directory /var/www/html", Name: "AllowOverride", Args: []string{"None"}}}, drift.RemovedDirectives)
+	assert.Equal(t, 1, len(drift.ModifiedDirectives))
+	assert.Equal(t, "DocumentRoot /var/www/example5", drift.ModifiedDirectives[0].New.String())
+
+	report = CompareSnapshots(current, old)
+	assert.Equal(t, 1, len(report.Added))
+
+	assert.True(t, CompareSnapshots(old, old).IsEmpty())
+}
+
+func TestParseSnapshot(t *testing.T) {
+	snapshot := getTestSnapshot(t)
+	data, err := json.Marshal(snapshot)
+	assert.Nilf(t, err, "could not marshal snapshot: %v", err)
+
+	parsedSnapshot, err := ParseSnapshot(data)
+	assert.Nilf(t, err, "could not parse snapshot: %v", err)
+	assert.True(t, CompareSnapshots(snapshot, parsedSnapshot).IsEmpty())
+}
+
+func getTestSnapshot(t *testing.T) *Snapshot {
+	data, err := ioutil.ReadFile("./test_data/apache/vhosts.json")
+	assert.Nilf(t, err, "could not read vhosts file: %v", err)
+	snapshot := &Snapshot{}
+	err = json.Unmarshal(data, &snapshot.Vhosts)
+	assert.Nilf(t, err, "could not parse vhosts file: %v", err)
+
+	return snapshot
+}
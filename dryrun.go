@@ -0,0 +1,92 @@
+package a2conf
+
+import (
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// DryRunReport contains changes that would be applied if dry-run mode was disabled
+type DryRunReport struct {
+	Diffs []FileDiff
+	// Actions are changes that are not made via config files, e.g. site or module enabling
+	Actions []string
+}
+
+// FileDiff represents pending changes of a config file as a unified diff
+type FileDiff struct {
+	FilePath string
+	New      bool
+	Diff     string
+}
+
+// getFilesDiffs returns unified diffs between the files on the disk and their new content
+func getFilesDiffs(contents map[string][]byte) ([]FileDiff, error) {
+	var filePaths []string
+
+	for filePath := range contents {
+		filePaths = append(filePaths, filePath)
+	}
+
+	sort.Strings(filePaths)
+	var diffs []FileDiff
+
+	for _, filePath := range filePaths {
+		var isNew bool
+		fromFile := filePath
+		originContent, err := ioutil.ReadFile(filePath)
+
+		if os.IsNotExist(err) {
+			isNew = true
+			fromFile = os.DevNull
+		} else if err != nil {
+			return nil, err
+		}
+
+		var originLines []string
+
+		if !isNew {
+			originLines = splitLines(string(originContent))
+		}
+
+		diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+			A:        originLines,
+			B:        splitLines(string(contents[filePath])),
+			FromFile: fromFile,
+			ToFile:   filePath,
+			Context:  3,
+		})
+
+		if err != nil {
+			return nil, err
+		}
+
+		if diff == "" {
+			continue
+		}
+
+		diffs = append(diffs, FileDiff{FilePath: filePath, New: isNew, Diff: diff})
+	}
+
+	return diffs, nil
+}
+
+// splitLines splits content into lines keeping line endings. Unlike difflib.SplitLines it does not add an empty line at the end.
+func splitLines(content string) []string {
+	if content == "" {
+		return nil
+	}
+
+	lines := strings.SplitAfter(content, "\n")
+
+	if lines[len(lines)-1] == "" {
+		return lines[:len(lines)-1]
+	}
+
+	lines[len(lines)-1] += "\n"
+
+	return lines
+}
@@ -0,0 +1,71 @@
+package a2conf
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/r2dtools/a2conf/apache"
+	"github.com/stretchr/testify/assert"
+	"github.com/unknwon/com"
+)
+
+func TestGetFilesDiffs(t *testing.T) {
+	existingFile := "/tmp/a2conf-dryrun.conf"
+	newFile := "/tmp/a2conf-new.conf"
+	createFile(t, existingFile)
+	os.Remove(newFile)
+	contents := map[string][]byte{
+		existingFile: []byte("content\nListen 8080\n"),
+		newFile:      []byte("Listen 443\n"),
+	}
+
+	diffs, err := getFilesDiffs(contents)
+	assert.Nilf(t, err, "could not get diffs: %v", err)
+	assert.Equal(t, 2, len(diffs))
+
+	assert.Equal(t, existingFile, diffs[0].FilePath)
+	assert.Equal(t, false, diffs[0].New)
+	assert.Contains(t, diffs[0].Diff, "--- "+existingFile)
+	assert.Contains(t, diffs[0].Diff, " content\n+Listen 8080\n")
+
+	assert.Equal(t, newFile, diffs[1].FilePath)
+	assert.Equal(t, true, diffs[1].New)
+	assert.Contains(t, diffs[1].Diff, "--- /dev/null")
+	assert.Contains(t, diffs[1].Diff, "+Listen 443\n")
+}
+
+func TestDryRunTransaction(t *testing.T) {
+	runWithBackends(t, func(t *testing.T, backend string) {
+		configurator, dir := getTestConfigurator(t, backend, map[string]string{
+			"apache2.conf": "Listen 80\n",
+			// the fake apachectl records its calls
+			"apachectl": "#!/bin/sh\necho \"$@\" >> \"$(dirname $0)/calls\"\n",
+		})
+		defer os.RemoveAll(dir)
+		defer configurator.Close()
+
+		ctlPath := filepath.Join(dir, "apachectl")
+		err := os.Chmod(ctlPath, 0755)
+		assert.Nilf(t, err, "could not make apachectl executable: %v", err)
+		configurator.(*apacheConfigurator).ctl = &apache.Ctl{BinPath: ctlPath}
+		configurator.SetDryRun(true)
+
+		var called bool
+		err = configurator.Transaction(func(configurator ApacheConfigurator) error {
+			called = true
+			return nil
+		})
+		assert.Nilf(t, err, "transaction failed: %v", err)
+		assert.True(t, called)
+		assert.False(t, com.IsExist(filepath.Join(dir, "calls")), "apachectl must not be called in dry-run mode")
+
+		err = configurator.RestartWebServer()
+		assert.Nilf(t, err, "could not restart web server: %v", err)
+		assert.False(t, com.IsExist(filepath.Join(dir, "calls")), "apachectl must not be called in dry-run mode")
+
+		report, err := configurator.GetDryRunReport()
+		assert.Nilf(t, err, "could not get dry-run report: %v", err)
+		assert.Equal(t, []string{"configuration test and web server restart", "web server restart"}, report.Actions)
+	})
+}
@@ -0,0 +1,133 @@
+package a2conf
+
+import (
+	"fmt"
+	"strings"
+)
+
+// conditionalSections are evaluated while the config is dumped, only directives of the active sections are kept
+var conditionalSections = []string{"ifdefine", "ifmodule", "ifversion", "iffile", "ifdirective", "ifsection"}
+
+// ConfigDump is the config with inlined included files, evaluated conditional sections and resolved variables
+type ConfigDump struct {
+	Lines []DumpLine
+}
+
+// DumpLine is a line of the config dump. File and Line are the source of the directive, they are empty for closing tags of the sections.
+type DumpLine struct {
+	File  string
+	Line  int
+	Depth int
+	Text  string
+}
+
+// String returns the config dump. Each line is annotated with its source file and line.
+func (d *ConfigDump) String() string {
+	var builder strings.Builder
+
+	for _, line := range d.Lines {
+		builder.WriteString(strings.Repeat("\t", line.Depth))
+		builder.WriteString(line.Text)
+
+		if line.File != "" {
+			builder.WriteString(fmt.Sprintf(" # %s:%d", line.File, line.Line))
+		}
+
+		builder.WriteString("\n")
+	}
+
+	return builder.String()
+}
+
+// dumpWalker inlines the walked config into the dump
+type dumpWalker struct {
+	baseVisitor
+	parser *Parser
+	dump   *ConfigDump
+	depth  int
+	// contents is a cache of the config files content used to detect line numbers
+	contents map[string][]byte
+}
+
+// Dump returns the resolved config starting with ConfigRoot. It is an equivalent of "-D DUMP_CONFIG".
+// Include and IncludeOptional directives are replaced with the content of the included files, conditional sections are evaluated,
+// variables are resolved with the defines active at the directive. Directives of <If> sections are kept as they are evaluated per request.
+func (p *Parser) Dump() (*ConfigDump, error) {
+	w := &dumpWalker{
+		parser:   p,
+		dump:     &ConfigDump{},
+		contents: make(map[string][]byte),
+	}
+
+	if err := p.walkConfig(w); err != nil {
+		return nil, fmt.Errorf("could not dump config: %v", err)
+	}
+
+	return w.dump, nil
+}
+
+func (w *dumpWalker) getArgs(node *Node) ([]string, error) {
+	return w.parser.getInterpretedArgs(node)
+}
+
+// enterSection checks if the section is active. Conditional sections are evaluated and not dumped, only their directives are.
+func (w *dumpWalker) enterSection(node *Node, args []string) (bool, error) {
+	defines, err := w.parser.getDefinesAt(node.Path)
+
+	if err != nil {
+		return false, err
+	}
+
+	isActive, err := w.parser.isSectionActive(node.Name, args, defines, w.parser.Modules)
+
+	if err != nil || !isActive {
+		return false, err
+	}
+
+	if !isConditionalSection(node.Name) {
+		w.addLine(node, "<"+formatDirective(node.Name, args)+">")
+		w.depth++
+	}
+
+	return true, nil
+}
+
+func (w *dumpWalker) leaveSection(node *Node) {
+	if isConditionalSection(node.Name) {
+		return
+	}
+
+	w.depth--
+	w.dump.Lines = append(w.dump.Lines, DumpLine{Depth: w.depth, Text: "</" + node.Name + ">"})
+}
+
+// visitDirective dumps the directive. Include directives are replaced with the content of the included files.
+func (w *dumpWalker) visitDirective(node *Node, args []string) error {
+	if !isIncludeDirective(node.Name) || len(args) == 0 {
+		w.addLine(node, formatDirective(node.Name, args))
+	}
+
+	return nil
+}
+
+func (w *dumpWalker) addLine(node *Node, text string) {
+	line := DumpLine{Depth: w.depth, Text: text}
+
+	// nodes created in the tree have no position
+	if span, err := w.parser.Tree.GetSpan(node); err == nil {
+		line.File = span.Filename
+		line.Line = getLineNumber(w.contents, span.Filename, span.Start)
+	}
+
+	w.dump.Lines = append(w.dump.Lines, line)
+}
+
+func isConditionalSection(name string) bool {
+	for _, section := range conditionalSections {
+		if strings.EqualFold(name, section) {
+			return true
+		}
+	}
+
+	return false
+}
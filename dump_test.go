@@ -0,0 +1,54 @@
+package a2conf
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testDumpConfig = `Define DOCROOT /var/www
+Listen 80
+<IfModule ssl_module>
+	Listen 443
+</IfModule>
+IncludeOptional conf.d
+<VirtualHost *:80>
+	DocumentRoot ${DOCROOT}/html
+	<If "%{HTTP_HOST} == 'example.com'">
+		Header set X-Host example
+	</If>
+</VirtualHost>
+`
+
+func TestDump(t *testing.T) {
+	runWithBackends(t, func(t *testing.T, backend string) {
+		parser, dir := getTestParser(t, backend, map[string]string{
+			"apache2.conf":      testDumpConfig,
+			"conf.d/b.conf":     "ServerTokens Prod\n",
+			"conf.d/a.conf":     "# comment\n<IfDefine DOCROOT>\n\tServerName example.com\n</IfDefine>\n",
+			"conf.d/empty.conf": "",
+			"conf.d/sub/c.conf": "ServerAdmin admin@example.com\n",
+		})
+		defer os.RemoveAll(dir)
+		defer parser.Close()
+
+		dump, err := parser.Dump()
+		assert.Nilf(t, err, "could not dump config: %v", err)
+
+		root := filepath.Join(dir, "apache2.conf")
+		expected := "Define DOCROOT /var/www # " + root + ":1\n" +
+			"Listen 80 # " + root + ":2\n" +
+			"ServerName example.com # " + filepath.Join(dir, "conf.d", "a.conf") + ":3\n" +
+			"ServerTokens Prod # " + filepath.Join(dir, "conf.d", "b.conf") + ":1\n" +
+			"ServerAdmin admin@example.com # " + filepath.Join(dir, "conf.d", "sub", "c.conf") + ":1\n" +
+			"<VirtualHost *:80> # " + root + ":7\n" +
+			"\tDocumentRoot /var/www/html # " + root + ":8\n" +
+			"\t<If \"%{HTTP_HOST} == 'example.com'\"> # " + root + ":9\n" +
+			"\t\tHeader set X-Host example # " + root + ":10\n" +
+			"\t</If>\n" +
+			"</VirtualHost>\n"
+		assert.Equal(t, expected, dump.String())
+	})
+}
@@ -20,7 +20,11 @@ type VirtualHost struct {
 	Aliases   []string
 	Ssl,
 	Enabled,
+	// Active is false if the virtual host is inside of inactive conditional section, e.g. <IfModule mod_ssl.c> without mod_ssl, so apache does not read it.
+	Active,
 	ModMacro bool
+	// Macro is a name of the mod_macro macro the virtual host is expanded from. AugPath points to the Use directive of the macro then.
+	Macro    string `json:",omitempty"`
 	Ancestor *VirtualHost
 }
 
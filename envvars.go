@@ -0,0 +1,159 @@
+package a2conf
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/unknwon/com"
+)
+
+// environmentFile is a shell file of the distribution setting environment variables and command line options of apache
+type environmentFile struct {
+	// path is relative to the system root if it is absolute, otherwise it is relative to the server root
+	path string
+	// optionsVar is a variable with command line options of apache. Its -D flags are defines.
+	optionsVar string
+	// isEnv specifies whether variables of the file are passed to the environment of apache
+	isEnv bool
+}
+
+var environmentFiles = []environmentFile{
+	// Debian, Ubuntu
+	{path: "envvars", isEnv: true},
+	// RHEL, CentOS, Fedora
+	{path: "/etc/sysconfig/httpd", optionsVar: "OPTIONS", isEnv: true},
+	// Gentoo
+	{path: "/etc/conf.d/apache2", optionsVar: "APACHE2_OPTS"},
+}
+
+var envVarRegexp = regexp.MustCompile(`^(?:export\s+)?([A-Za-z_][A-Za-z0-9_]*)=(.*)$`)
+
+// UpdateEnvironment updates environment variables and command line defines of apache from the environment files of the distribution.
+// Files outside of the server root are read only if the system root is known.
+func (p *Parser) UpdateEnvironment() error {
+	envVariables := make(map[string]string)
+	cmdDefines := make(map[string]string)
+
+	for _, envFile := range environmentFiles {
+		filePath := envFile.path
+
+		if filepath.IsAbs(filePath) {
+			if p.systemRoot == "" {
+				continue
+			}
+
+			filePath = filepath.Join(p.systemRoot, filePath)
+		} else {
+			filePath = filepath.Join(p.ServerRoot, filePath)
+		}
+
+		if !com.IsFile(filePath) {
+			continue
+		}
+
+		variables, err := parseEnvVars(filePath)
+
+		if err != nil {
+			return err
+		}
+
+		if envFile.optionsVar != "" {
+			for name, value := range parseDefineFlags(variables[envFile.optionsVar]) {
+				cmdDefines[name] = value
+			}
+		}
+
+		if envFile.isEnv {
+			for name, value := range variables {
+				envVariables[name] = value
+			}
+		}
+	}
+
+	p.envVariables = envVariables
+	p.cmdDefines = cmdDefines
+
+	return nil
+}
+
+// parseDefineFlags returns defines of apache command line options, e.g. "-D SSL -DINFO"
+func parseDefineFlags(options string) map[string]string {
+	defines := make(map[string]string)
+	fields := strings.Fields(options)
+
+	for i := 0; i < len(fields); i++ {
+		var define string
+
+		switch {
+		case fields[i] == "-D" && i+1 < len(fields):
+			i++
+			define = fields[i]
+		case strings.HasPrefix(fields[i], "-D") && fields[i] != "-D":
+			define = fields[i][2:]
+		default:
+			continue
+		}
+
+		parts := strings.SplitN(define, "=", 2)
+
+		if len(parts) == 2 {
+			defines[parts[0]] = parts[1]
+		} else {
+			defines[parts[0]] = ""
+		}
+	}
+
+	return defines
+}
+
+// parseEnvVars parses a shell file with environment variables, e.g. /etc/apache2/envvars.
+// Only assignments are taken into account: NAME=value and export NAME=value. Variables set before are expanded in values.
+func parseEnvVars(filePath string) (map[string]string, error) {
+	file, err := os.Open(filePath)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not open envvars file '%s': %v", filePath, err)
+	}
+
+	defer file.Close()
+
+	variables := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		match := envVarRegexp.FindStringSubmatch(strings.TrimSpace(scanner.Text()))
+
+		if match == nil {
+			continue
+		}
+
+		variables[match[1]] = parseEnvVarValue(match[2], variables)
+	}
+
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read envvars file '%s': %v", filePath, err)
+	}
+
+	return variables, nil
+}
+
+func parseEnvVarValue(value string, variables map[string]string) string {
+	value = strings.TrimSpace(value)
+
+	if len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'' {
+		return value[1 : len(value)-1]
+	}
+
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		value = value[1 : len(value)-1]
+	}
+
+	// unknown variables and parameter expansions like ${VAR##prefix} are empty
+	return os.Expand(value, func(name string) string {
+		return variables[name]
+	})
+}
@@ -0,0 +1,88 @@
+package a2conf
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testEnvVars = `# envvars - default environment variables for apache2ctl
+unset HOME
+
+if [ "${APACHE_CONFDIR##/etc/apache2-}" != "${APACHE_CONFDIR}" ] ; then
+	SUFFIX="-${APACHE_CONFDIR##/etc/apache2-}"
+else
+	SUFFIX=
+fi
+
+export APACHE_RUN_USER=www-data
+export APACHE_LOG_DIR=/var/log/apache2$SUFFIX
+export APACHE_LOCK_DIR="/var/lock/apache2${SUFFIX}"
+export APACHE_ARGUMENTS='-k $start'
+export LANG
+`
+
+func TestParseEnvVars(t *testing.T) {
+	file, err := ioutil.TempFile("/tmp", "a2conf-envvars")
+	assert.Nilf(t, err, "could not create envvars file: %v", err)
+	defer os.Remove(file.Name())
+
+	_, err = file.WriteString(testEnvVars)
+	assert.Nilf(t, err, "could not write envvars file: %v", err)
+	file.Close()
+
+	variables, err := parseEnvVars(file.Name())
+	assert.Nilf(t, err, "could not parse envvars file: %v", err)
+
+	expected := map[string]string{
+		"SUFFIX":           "",
+		"APACHE_RUN_USER":  "www-data",
+		"APACHE_LOG_DIR":   "/var/log/apache2",
+		"APACHE_LOCK_DIR":  "/var/lock/apache2",
+		"APACHE_ARGUMENTS": "-k $start",
+	}
+	assert.Equal(t, expected, variables)
+
+	_, err = parseEnvVars("/tmp/a2conf-missing-envvars")
+	assert.NotNil(t, err)
+}
+
+func TestParseDefineFlags(t *testing.T) {
+	defines := parseDefineFlags("-D DEFAULT_VHOST -DINFO -k start -D NAME=value -D")
+	assert.Equal(t, map[string]string{"DEFAULT_VHOST": "", "INFO": "", "NAME": "value"}, defines)
+	assert.Empty(t, parseDefineFlags(""))
+}
+
+func TestUpdateEnvironment(t *testing.T) {
+	dir := writeTestConfig(t, map[string]string{
+		"etc/apache2/envvars": testEnvVars,
+		"etc/sysconfig/httpd": "# Configuration file for the httpd service.\nOPTIONS=\"-DMY_DEFINE\"\nLANG=C\n",
+		"etc/conf.d/apache2":  "APACHE2_OPTS=\"-D DEFAULT_VHOST -D SSL\"\nSERVERROOT=/usr/lib64/apache2\n",
+	})
+	defer os.RemoveAll(dir)
+
+	// files outside of the server root are not read without the system root, e.g. in offline mode
+	parser := &Parser{ServerRoot: filepath.Join(dir, "etc/apache2")}
+	err := parser.UpdateEnvironment()
+	assert.Nilf(t, err, "could not update environment: %v", err)
+	assert.Empty(t, parser.cmdDefines)
+	assert.Equal(t, "/var/log/apache2", parser.envVariables["APACHE_LOG_DIR"])
+	assert.NotContains(t, parser.envVariables, "LANG")
+
+	parser.systemRoot = dir
+	err = parser.UpdateEnvironment()
+	assert.Nilf(t, err, "could not update environment: %v", err)
+	assert.Equal(t, map[string]string{"MY_DEFINE": "", "DEFAULT_VHOST": "", "SSL": ""}, parser.cmdDefines)
+	assert.Equal(t, "/var/log/apache2", parser.envVariables["APACHE_LOG_DIR"])
+	assert.Equal(t, "C", parser.envVariables["LANG"])
+	assert.NotContains(t, parser.envVariables, "SERVERROOT", "variables of Gentoo init script are not passed to apache")
+
+	// defines take precedence over environment variables
+	parser.variables = map[string]string{"LANG": "en_US"}
+	value, err := parser.InterpretArg("${APACHE_LOG_DIR}/error.log ${LANG}")
+	assert.Nilf(t, err, "could not interpret argument: %v", err)
+	assert.Equal(t, "/var/log/apache2/error.log en_US", value)
+}
@@ -5,7 +5,9 @@ go 1.15
 require (
 	github.com/Masterminds/semver v1.5.0
 	github.com/huandu/xstrings v1.3.2
+	github.com/pmezard/go-difflib v1.0.0
 	github.com/stretchr/testify v1.6.1
 	github.com/unknwon/com v1.0.1
+	gopkg.in/yaml.v3 v3.0.1
 	honnef.co/go/augeas v0.0.0-20161110001225-ca62e35ed6b8
 )
@@ -0,0 +1,69 @@
+package a2conf
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	opts "github.com/r2dtools/a2conf/options"
+	"github.com/stretchr/testify/assert"
+)
+
+// testBackends are the parser backends the config features are tested with. Augeas is skipped with "noaugeas" tag.
+var testBackends = []string{BackendAugeas, BackendNative}
+
+// runWithBackends runs the test for each available parser backend
+func runWithBackends(t *testing.T, test func(t *testing.T, backend string)) {
+	for _, backend := range testBackends {
+		t.Run(backend, func(t *testing.T) {
+			b, err := newBackend(backend, "")
+
+			if err != nil {
+				t.Skipf("backend is not available: %v", err)
+			}
+
+			b.Close()
+			test(t, backend)
+		})
+	}
+}
+
+// writeTestConfig writes the config files into a new temporary directory, it is the server root of the config
+func writeTestConfig(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("/tmp", "a2conf-config")
+	assert.Nilf(t, err, "could not create temp directory: %v", err)
+
+	for name, content := range files {
+		filePath := filepath.Join(dir, name)
+		err = os.MkdirAll(filepath.Dir(filePath), 0755)
+		assert.Nilf(t, err, "could not create config directory: %v", err)
+		err = ioutil.WriteFile(filePath, []byte(content), 0644)
+		assert.Nilf(t, err, "could not write config file: %v", err)
+	}
+
+	return dir
+}
+
+// getTestParser returns a parser of the config files written into a temporary directory
+func getTestParser(t *testing.T, backend string, files map[string]string) (*Parser, string) {
+	dir := writeTestConfig(t, files)
+	parser, err := GetParserWithOptions(nil, "2.4.41", map[string]string{opts.ServerRoot: dir, opts.ParserBackend: backend})
+	assert.Nilf(t, err, "could not create parser: %v", err)
+
+	return parser, dir
+}
+
+// getTestConfigurator returns an offline configurator of the config files written into a temporary directory
+func getTestConfigurator(t *testing.T, backend string, files map[string]string) (ApacheConfigurator, string) {
+	dir := writeTestConfig(t, files)
+	configurator, err := GetApacheConfigurator(map[string]string{
+		opts.Offline:       "true",
+		opts.Version:       "2.4.41",
+		opts.ServerRoot:    dir,
+		opts.ParserBackend: backend,
+	})
+	assert.Nilf(t, err, "could not create apache configurator: %v", err)
+
+	return configurator, dir
+}
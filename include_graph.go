@@ -0,0 +1,230 @@
+package a2conf
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// IncludeGraph is a graph of the config files connected by Include and IncludeOptional directives of the active sections
+type IncludeGraph struct {
+	Root string
+	// Files are the config files read by apache in the order of their reading
+	Files    []string
+	Includes []*Include
+}
+
+// Include is an edge of the include graph: the file includes the files matching the pattern
+type Include struct {
+	File string
+	Line int
+	// Pattern is the argument of the directive, it could contain wildcards
+	Pattern  string
+	Optional bool
+	// Included is the included file. It is empty if Missing is set and the pattern has wildcards.
+	Included string
+	// Missing is set if there are no files matching the pattern. It is an error for Include, but not for IncludeOptional.
+	Missing bool
+	// Cyclic is set if the included file is already being read, i.e. it includes itself directly or via the other files
+	Cyclic bool
+	// ParseError is set if the included file could not be parsed, its includes are unknown then
+	ParseError *ParseError
+}
+
+// includeGraphWalker records the files and Include directives of the walked config
+type includeGraphWalker struct {
+	baseVisitor
+	parser   *Parser
+	graph    *IncludeGraph
+	visited  map[string]bool
+	contents map[string][]byte
+}
+
+// GetIncludeGraph returns the graph of the config files starting with ConfigRoot
+func (p *Parser) GetIncludeGraph() (*IncludeGraph, error) {
+	w := &includeGraphWalker{
+		parser:   p,
+		graph:    &IncludeGraph{Root: p.ConfigRoot},
+		visited:  make(map[string]bool),
+		contents: make(map[string][]byte),
+	}
+
+	if err := p.walkConfig(w); err != nil {
+		return nil, fmt.Errorf("could not build include graph: %v", err)
+	}
+
+	return w.graph, nil
+}
+
+// GetMissingIncludes returns Include directives without the matching files. Missing files of IncludeOptional are allowed.
+func (g *IncludeGraph) GetMissingIncludes() []*Include {
+	var includes []*Include
+
+	for _, include := range g.Includes {
+		if include.Missing && !include.Optional {
+			includes = append(includes, include)
+		}
+	}
+
+	return includes
+}
+
+// GetCyclicIncludes returns Include directives including the files being read
+func (g *IncludeGraph) GetCyclicIncludes() []*Include {
+	var includes []*Include
+
+	for _, include := range g.Includes {
+		if include.Cyclic {
+			includes = append(includes, include)
+		}
+	}
+
+	return includes
+}
+
+// GetUnparsedIncludes returns Include directives including the files which could not be parsed
+func (g *IncludeGraph) GetUnparsedIncludes() []*Include {
+	var includes []*Include
+
+	for _, include := range g.Includes {
+		if include.ParseError != nil {
+			includes = append(includes, include)
+		}
+	}
+
+	return includes
+}
+
+// JSON returns the graph in JSON format
+func (g *IncludeGraph) JSON() ([]byte, error) {
+	return json.MarshalIndent(g, "", "  ")
+}
+
+// DOT returns the graph in Graphviz DOT format. Optional includes are dashed, missing, cyclic and unparsed ones are red.
+func (g *IncludeGraph) DOT() string {
+	var builder strings.Builder
+	builder.WriteString("digraph includes {\n")
+	builder.WriteString("\tnode [shape=box];\n")
+
+	for _, file := range g.Files {
+		builder.WriteString(fmt.Sprintf("\t%s;\n", quoteDOT(file)))
+	}
+
+	for _, include := range g.Includes {
+		included := include.Included
+
+		if included == "" {
+			included = include.Pattern
+		}
+
+		if include.Missing {
+			builder.WriteString(fmt.Sprintf("\t%s [style=dashed, color=red];\n", quoteDOT(included)))
+		}
+
+		if include.ParseError != nil {
+			builder.WriteString(fmt.Sprintf("\t%s [color=red];\n", quoteDOT(included)))
+		}
+
+		attrs := []string{"label=" + quoteDOT(fmt.Sprintf("%s:%d", include.Pattern, include.Line))}
+
+		if include.Optional {
+			attrs = append(attrs, "style=dashed")
+		}
+
+		if include.Missing || include.Cyclic || include.ParseError != nil {
+			attrs = append(attrs, "color=red")
+		}
+
+		builder.WriteString(fmt.Sprintf("\t%s -> %s [%s];\n", quoteDOT(include.File), quoteDOT(included), strings.Join(attrs, ", ")))
+	}
+
+	builder.WriteString("}\n")
+
+	return builder.String()
+}
+
+func (w *includeGraphWalker) getArgs(node *Node) ([]string, error) {
+	return w.parser.getInterpretedArgs(node)
+}
+
+func (w *includeGraphWalker) enterSection(node *Node, args []string) (bool, error) {
+	defines, err := w.parser.getDefinesAt(node.Path)
+
+	if err != nil {
+		return false, err
+	}
+
+	return w.parser.isSectionActive(node.Name, args, defines, w.parser.Modules)
+}
+
+func (w *includeGraphWalker) visitDirective(node *Node, args []string) error {
+	return nil
+}
+
+func (w *includeGraphWalker) visitFile(filePath string) {
+	if !w.visited[filePath] {
+		w.visited[filePath] = true
+		w.graph.Files = append(w.graph.Files, filePath)
+	}
+}
+
+// visitInclude records Include directive without the matching files
+func (w *includeGraphWalker) visitInclude(node *Node, arg, pattern string, filePaths []string) error {
+	if len(filePaths) > 0 {
+		return nil
+	}
+
+	include := w.createInclude(node, arg)
+	include.Missing = true
+
+	if !strings.ContainsAny(arg, "*?[") {
+		include.Included = pattern
+	}
+
+	w.graph.Includes = append(w.graph.Includes, include)
+
+	return nil
+}
+
+func (w *includeGraphWalker) visitIncludedFile(node *Node, arg, filePath string, cyclic bool) error {
+	include := w.createInclude(node, arg)
+	include.Included = filePath
+	include.Cyclic = cyclic
+	include.ParseError = w.getParseError(filePath)
+	w.graph.Includes = append(w.graph.Includes, include)
+
+	// files with parse errors are not loaded into the tree and not walked, but apache reads them
+	if include.ParseError != nil {
+		w.visitFile(filePath)
+	}
+
+	return nil
+}
+
+func (w *includeGraphWalker) getParseError(filePath string) *ParseError {
+	for _, parseError := range w.parser.GetParseErrors() {
+		if parseError.File == filePath {
+			return parseError
+		}
+	}
+
+	return nil
+}
+
+func (w *includeGraphWalker) createInclude(node *Node, arg string) *Include {
+	include := &Include{
+		File:     w.parser.getFilePath(node.Path),
+		Pattern:  arg,
+		Optional: strings.EqualFold(node.Name, "includeoptional"),
+	}
+
+	if span, err := w.parser.Tree.GetSpan(node); err == nil {
+		include.Line = getLineNumber(w.contents, span.Filename, span.Start)
+	}
+
+	return include
+}
+
+func quoteDOT(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
@@ -0,0 +1,149 @@
+package a2conf
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testIncludeGraphConfig = `Include conf.d/*.conf
+Include missing.conf
+IncludeOptional optional/*.conf
+<IfModule rewrite_module>
+	Include inactive.conf
+</IfModule>
+`
+
+func TestIncludeGraph(t *testing.T) {
+	runWithBackends(t, func(t *testing.T, backend string) {
+		parser, dir := getTestParser(t, backend, map[string]string{
+			"apache2.conf":  testIncludeGraphConfig,
+			"conf.d/a.conf": "Listen 80\nInclude b.conf\n",
+			"conf.d/c.conf": "Include conf.d/*.conf\nServerTokens Prod\n",
+			"b.conf":        "Include conf.d/a.conf\n",
+			"inactive.conf": "Listen 8080\n",
+		})
+		defer os.RemoveAll(dir)
+		defer parser.Close()
+
+		matches, err := parser.FindDirective("Listen", "", "", true)
+		assert.Nilf(t, err, "could not find directive: %v", err)
+		// a.conf is searched again via b.conf before the loop is detected
+		assert.Equal(t, 2, len(matches))
+
+		graph, err := parser.GetIncludeGraph()
+		assert.Nilf(t, err, "could not get include graph: %v", err)
+
+		root := filepath.Join(dir, "apache2.conf")
+		a := filepath.Join(dir, "conf.d", "a.conf")
+		b := filepath.Join(dir, "b.conf")
+		c := filepath.Join(dir, "conf.d", "c.conf")
+		assert.Equal(t, root, graph.Root)
+		assert.Equal(t, []string{root, a, b, c}, graph.Files)
+
+		expected := []Include{
+			{File: root, Line: 1, Pattern: "conf.d/*.conf", Included: a},
+			{File: a, Line: 2, Pattern: "b.conf", Included: b},
+			{File: b, Line: 1, Pattern: "conf.d/a.conf", Included: a, Cyclic: true},
+			{File: root, Line: 1, Pattern: "conf.d/*.conf", Included: c},
+			{File: c, Line: 1, Pattern: "conf.d/*.conf", Included: a},
+			{File: a, Line: 2, Pattern: "b.conf", Included: b},
+			{File: b, Line: 1, Pattern: "conf.d/a.conf", Included: a, Cyclic: true},
+			{File: c, Line: 1, Pattern: "conf.d/*.conf", Included: c, Cyclic: true},
+			{File: root, Line: 2, Pattern: "missing.conf", Included: filepath.Join(dir, "missing.conf"), Missing: true},
+			{File: root, Line: 3, Pattern: "optional/*.conf", Optional: true, Missing: true},
+		}
+		var includes []Include
+
+		for _, include := range graph.Includes {
+			includes = append(includes, *include)
+		}
+
+		assert.Equal(t, expected, includes)
+		assert.Equal(t, 1, len(graph.GetMissingIncludes()))
+		assert.Equal(t, "missing.conf", graph.GetMissingIncludes()[0].Pattern)
+		assert.Equal(t, 3, len(graph.GetCyclicIncludes()))
+
+		data, err := graph.JSON()
+		assert.Nilf(t, err, "could not marshal graph: %v", err)
+		var decoded IncludeGraph
+		assert.Nil(t, json.Unmarshal(data, &decoded))
+		assert.Equal(t, graph.Files, decoded.Files)
+
+		dot := graph.DOT()
+		assert.Contains(t, dot, "digraph includes {")
+		assert.Contains(t, dot, `"`+b+`" -> "`+a+`" [label="conf.d/a.conf:1", color=red];`)
+		assert.Contains(t, dot, `"`+root+`" -> "optional/*.conf" [label="optional/*.conf:3", style=dashed, color=red];`)
+	})
+}
+
+func TestIncludeGraphDirectory(t *testing.T) {
+	runWithBackends(t, func(t *testing.T, backend string) {
+		parser, dir := getTestParser(t, backend, map[string]string{
+			"apache2.conf":           "Include conf.d\n",
+			"conf.d/a.conf":          "Listen 80\n",
+			"conf.d/sub/b.conf":      "Listen 81\n",
+			"conf.d/sub/deep/c.conf": "Listen 82\n",
+			"conf.d/z.conf":          "Listen 83\n",
+		})
+		defer os.RemoveAll(dir)
+		defer parser.Close()
+
+		var listen []string
+		matches, err := parser.FindDirective("Listen", "", "", true)
+		assert.Nilf(t, err, "could not find directive: %v", err)
+
+		for _, match := range matches {
+			arg, err := parser.GetArg(match)
+			assert.Nilf(t, err, "could not get argument: %v", err)
+			listen = append(listen, arg)
+		}
+
+		assert.ElementsMatch(t, []string{"80", "81", "82", "83"}, listen)
+
+		graph, err := parser.GetIncludeGraph()
+		assert.Nilf(t, err, "could not get include graph: %v", err)
+
+		expected := []string{
+			filepath.Join(dir, "apache2.conf"),
+			filepath.Join(dir, "conf.d", "a.conf"),
+			filepath.Join(dir, "conf.d", "sub", "b.conf"),
+			filepath.Join(dir, "conf.d", "sub", "deep", "c.conf"),
+			filepath.Join(dir, "conf.d", "z.conf"),
+		}
+		assert.Equal(t, expected, graph.Files)
+		assert.Equal(t, 4, len(graph.Includes))
+	})
+}
+
+func TestIncludeGraphParseError(t *testing.T) {
+	runWithBackends(t, func(t *testing.T, backend string) {
+		parser, dir := getTestParser(t, backend, map[string]string{
+			"apache2.conf": "Include invalid.conf\nInclude valid.conf\n",
+			"invalid.conf": "<VirtualHost *:80>\n\tServerName example.com\n</Directory>\n",
+			"valid.conf":   "Listen 80\n",
+		})
+		defer os.RemoveAll(dir)
+		defer parser.Close()
+
+		graph, err := parser.GetIncludeGraph()
+		assert.Nilf(t, err, "could not get include graph: %v", err)
+
+		root := filepath.Join(dir, "apache2.conf")
+		invalid := filepath.Join(dir, "invalid.conf")
+		valid := filepath.Join(dir, "valid.conf")
+		assert.Equal(t, []string{root, invalid, valid}, graph.Files)
+		assert.Equal(t, 2, len(graph.Includes))
+
+		unparsed := graph.GetUnparsedIncludes()
+		assert.Equal(t, 1, len(unparsed))
+		assert.Equal(t, invalid, unparsed[0].Included)
+		assert.Equal(t, invalid, unparsed[0].ParseError.File)
+		assert.Equal(t, 3, unparsed[0].ParseError.Line)
+		assert.Nil(t, graph.Includes[1].ParseError)
+		assert.Contains(t, graph.DOT(), `"`+root+`" -> "`+invalid+`" [label="invalid.conf:1", color=red];`)
+	})
+}
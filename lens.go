@@ -0,0 +1,48 @@
+package a2conf
+
+//go:generate go run augeas_lens/gen.go
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Sources of the httpd lens used by the augeas backend
+const (
+	// LensBundled is the lens shipped with the package in augeas_lens/httpd.aug
+	LensBundled = "bundled"
+	// LensSystem is the lens installed with libaugeas
+	LensSystem = "system"
+)
+
+const httpdLensFile = "httpd.aug"
+
+// getLensLoadPath returns Augeas load path with the httpd lens. lens is LensBundled, LensSystem or a directory with httpd.aug.
+// The bundled lens is written to a temporary directory, which is returned as the second value and must be removed by the caller.
+func getLensLoadPath(lens string) (string, string, error) {
+	switch lens {
+	case LensSystem:
+		return "", "", nil
+	case "", LensBundled:
+		dir, err := ioutil.TempDir("", "a2conf-lens")
+
+		if err != nil {
+			return "", "", fmt.Errorf("could not create lens directory: %v", err)
+		}
+
+		if err = ioutil.WriteFile(filepath.Join(dir, httpdLensFile), []byte(httpdLens), 0644); err != nil {
+			os.RemoveAll(dir)
+			return "", "", fmt.Errorf("could not write bundled lens: %v", err)
+		}
+
+		return dir, dir, nil
+	}
+
+	if _, err := os.Stat(filepath.Join(lens, httpdLensFile)); err != nil {
+		return "", "", fmt.Errorf("could not find httpd lens in '%s': %v", lens, err)
+	}
+
+	return lens, "", nil
+}
@@ -0,0 +1,59 @@
+package a2conf
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/unknwon/com"
+)
+
+func TestBundledLens(t *testing.T) {
+	content, err := ioutil.ReadFile("augeas_lens/httpd.aug")
+	assert.Nilf(t, err, "could not read lens: %v", err)
+	assert.Equal(t, string(content), httpdLens, "lens_httpd.go is outdated, run go generate")
+}
+
+func TestGetLensLoadPath(t *testing.T) {
+	loadPath, lensDir, err := getLensLoadPath(LensBundled)
+	assert.Nilf(t, err, "could not get lens load path: %v", err)
+	assert.Equal(t, lensDir, loadPath)
+	content, err := ioutil.ReadFile(filepath.Join(lensDir, "httpd.aug"))
+	assert.Nilf(t, err, "could not read bundled lens: %v", err)
+	assert.Equal(t, httpdLens, string(content))
+	os.RemoveAll(lensDir)
+
+	loadPath, lensDir, err = getLensLoadPath(LensSystem)
+	assert.Nil(t, err)
+	assert.Empty(t, loadPath)
+	assert.Empty(t, lensDir)
+
+	loadPath, lensDir, err = getLensLoadPath("augeas_lens")
+	assert.Nilf(t, err, "could not get lens load path: %v", err)
+	assert.Equal(t, "augeas_lens", loadPath)
+	assert.Empty(t, lensDir)
+
+	_, _, err = getLensLoadPath("/tmp/a2conf-missing-lens")
+	assert.NotNil(t, err)
+}
+
+func TestCloseRemovesBundledLens(t *testing.T) {
+	runWithBackends(t, func(t *testing.T, backend string) {
+		pattern := filepath.Join(os.TempDir(), "a2conf-lens*")
+		before, _ := filepath.Glob(pattern)
+		configurator, dir := getTestConfigurator(t, backend, map[string]string{"apache2.conf": "Listen 80\n"})
+		defer os.RemoveAll(dir)
+
+		after, _ := filepath.Glob(pattern)
+		configurator.Close()
+
+		// the directories of the lens written for the configurator are removed
+		for _, lensDir := range after {
+			if !com.IsSliceContainsStr(before, lensDir) {
+				assert.Falsef(t, com.IsExist(lensDir), "lens directory '%s' is not removed", lensDir)
+			}
+		}
+	})
+}
@@ -0,0 +1,432 @@
+package a2conf
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/r2dtools/a2conf/entity"
+	"github.com/r2dtools/a2conf/utils"
+)
+
+// macroDefinition is a mod_macro definition: <Macro name $param1 $param2>
+type macroDefinition struct {
+	name   string
+	params []string
+	node   *Node
+}
+
+// macroUse is a Use directive of the macro
+type macroUse struct {
+	node *Node
+	args []string
+}
+
+// getMacroVhosts returns virtual hosts defined via mod_macro. Macros are expanded at the place of Use directives,
+// so the virtual hosts point to the Use directive. Use directives which could not be expanded are skipped.
+func (ac *apacheConfigurator) getMacroVhosts() ([]*entity.VirtualHost, error) {
+	macros, err := ac.getMacros()
+
+	if err != nil || len(macros) == 0 {
+		return nil, err
+	}
+
+	uses, err := ac.findMacroUses()
+
+	if err != nil {
+		return nil, err
+	}
+
+	var useKeys []string
+	useVhosts := make(map[string][]*entity.VirtualHost)
+	useFilePaths := make(map[string]string)
+
+	for _, use := range uses {
+		filePath := ac.parser.getFilePath(use.node.Path)
+		realPath, err := filepath.EvalSymlinks(filePath)
+
+		if err != nil {
+			ac.logger.Error(fmt.Sprintf("failed to eval symlinks for macro use '%s': %v", filePath, err))
+			continue
+		}
+
+		// the same file could be loaded via the symlink, e.g. sites-enabled/vh.conf -> sites-available/vh.conf.
+		// "Real" paths are preferred.
+		useKey := realPath + utils.GetInternalAugPath(use.node.Path)
+
+		if usedFilePath, ok := useFilePaths[useKey]; ok && (usedFilePath == realPath || filePath != realPath) {
+			continue
+		}
+
+		vhosts, err := ac.expandMacro(macros, use.node, use.args, nil)
+
+		if err != nil {
+			ac.logger.Error(fmt.Sprintf("could not expand macro at '%s': %v", filePath, err))
+			continue
+		}
+
+		if _, ok := useFilePaths[useKey]; !ok {
+			useKeys = append(useKeys, useKey)
+		}
+
+		useFilePaths[useKey] = filePath
+		useVhosts[useKey] = vhosts
+	}
+
+	var vhosts []*entity.VirtualHost
+
+	for _, useKey := range useKeys {
+		vhosts = append(vhosts, useVhosts[useKey]...)
+	}
+
+	return vhosts, nil
+}
+
+// getMacros returns active macro definitions of the loaded files by lower case names
+func (ac *apacheConfigurator) getMacros() (map[string]*macroDefinition, error) {
+	macros := make(map[string]*macroDefinition)
+
+	for path := range ac.parser.Paths {
+		fileNode, err := ac.parser.Tree.GetFileNode(path)
+
+		if err != nil || fileNode == nil {
+			continue
+		}
+
+		sections, err := ac.parser.Tree.FindSections(fileNode, "Macro")
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, section := range sections {
+			args, err := ac.parser.Tree.GetArgs(section)
+
+			if err != nil {
+				return nil, err
+			}
+
+			if len(args) == 0 {
+				continue
+			}
+
+			isActive, err := ac.parser.isNodeActive(section.Path)
+
+			if err != nil || !isActive {
+				continue
+			}
+
+			macro := &macroDefinition{name: args[0].Value, node: section}
+
+			for _, arg := range args[1:] {
+				macro.params = append(macro.params, arg.Value)
+			}
+
+			macros[strings.ToLower(macro.name)] = macro
+		}
+	}
+
+	return macros, nil
+}
+
+// findMacroUses returns active Use directives of the loaded files. Use directives of the macro definitions are expanded with the macro.
+func (ac *apacheConfigurator) findMacroUses() ([]*macroUse, error) {
+	var uses []*macroUse
+	usePaths := make(map[string]bool)
+
+	for path := range ac.parser.Paths {
+		fileNode, err := ac.parser.Tree.GetFileNode(path)
+
+		if err != nil || fileNode == nil {
+			continue
+		}
+
+		nodes, err := ac.findUseDirectives(fileNode)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, node := range nodes {
+			if usePaths[node.Path] {
+				continue
+			}
+
+			usePaths[node.Path] = true
+			isMacro, err := ac.isWithinSection(node, "Macro")
+
+			if err != nil {
+				return nil, err
+			}
+
+			if isMacro {
+				continue
+			}
+
+			args, err := ac.getMacroArgs(node, nil)
+
+			if err != nil {
+				return nil, err
+			}
+
+			uses = append(uses, &macroUse{node: node, args: args})
+		}
+	}
+
+	return uses, nil
+}
+
+// findUseDirectives returns active Use directives nested in the node
+func (ac *apacheConfigurator) findUseDirectives(node *Node) ([]*Node, error) {
+	matches, err := ac.parser.FindDirective("Use", "", node.Path, true)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var nodes []*Node
+	var lastPath string
+
+	for _, match := range matches {
+		path := match[:strings.LastIndex(match, "/arg")]
+
+		if path == lastPath {
+			continue
+		}
+
+		lastPath = path
+		useNode, err := ac.parser.Tree.GetNode(path)
+
+		if err != nil {
+			return nil, err
+		}
+
+		nodes = append(nodes, useNode)
+	}
+
+	return nodes, nil
+}
+
+// expandMacro returns virtual hosts of the macro used with the args. Nested Use directives of the macro are expanded too.
+func (ac *apacheConfigurator) expandMacro(macros map[string]*macroDefinition, use *Node, args []string, expanded []string) ([]*entity.VirtualHost, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("macro name is not specified")
+	}
+
+	macro, ok := macros[strings.ToLower(args[0])]
+
+	if !ok {
+		return nil, fmt.Errorf("macro '%s' is not defined", args[0])
+	}
+
+	for _, name := range expanded {
+		if strings.EqualFold(name, macro.name) {
+			return nil, fmt.Errorf("recursive use of macro '%s'", macro.name)
+		}
+	}
+
+	if len(args)-1 != len(macro.params) {
+		return nil, fmt.Errorf("macro '%s' expects %d arguments, but %d are given", macro.name, len(macro.params), len(args)-1)
+	}
+
+	replacer := getMacroReplacer(macro.params, args[1:])
+	sections, err := ac.parser.Tree.FindSections(macro.node, "VirtualHost")
+
+	if err != nil {
+		return nil, err
+	}
+
+	var vhosts []*entity.VirtualHost
+
+	for _, section := range sections {
+		isActive, err := ac.parser.isNodeActive(section.Path)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if !isActive {
+			continue
+		}
+
+		// virtual hosts of the nested macros point to the Use directive of the outer macro
+		macroName := macro.name
+
+		if len(expanded) > 0 {
+			macroName = expanded[0]
+		}
+
+		vhost, err := ac.createMacroVhost(section, use, macroName, replacer)
+
+		if err != nil {
+			return nil, err
+		}
+
+		vhosts = append(vhosts, vhost)
+	}
+
+	nestedUses, err := ac.findUseDirectives(macro.node)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, nestedUse := range nestedUses {
+		nestedArgs, err := ac.getMacroArgs(nestedUse, replacer)
+
+		if err != nil {
+			return nil, err
+		}
+
+		nestedVhosts, err := ac.expandMacro(macros, use, nestedArgs, append(expanded, macro.name))
+
+		if err != nil {
+			return nil, err
+		}
+
+		vhosts = append(vhosts, nestedVhosts...)
+	}
+
+	return vhosts, nil
+}
+
+func (ac *apacheConfigurator) createMacroVhost(section, use *Node, macroName string, replacer *strings.Replacer) (*entity.VirtualHost, error) {
+	filePath := ac.parser.getFilePath(use.Path)
+
+	if filePath == "" {
+		return nil, fmt.Errorf("could not detect file of the macro use '%s'", use.Path)
+	}
+
+	args, err := ac.getMacroArgs(section, replacer)
+
+	if err != nil {
+		return nil, err
+	}
+
+	vhost := &entity.VirtualHost{
+		FilePath:  filePath,
+		AugPath:   use.Path,
+		Addresses: make(map[string]entity.Address),
+		Enabled:   ac.parser.IsFilenameExistInOriginalPaths(filePath),
+		Active:    true,
+		Macro:     macroName,
+	}
+
+	for _, arg := range args {
+		addr := entity.CreateVhostAddressFromString(arg)
+		vhost.Addresses[addr.GetHash()] = addr
+
+		if addr.Port == "443" {
+			vhost.Ssl = true
+		}
+	}
+
+	directives, err := ac.getMacroDirectivesArgs(section, replacer, "ServerName", "ServerAlias", "DocumentRoot", "SSLEngine")
+
+	if err != nil {
+		return nil, err
+	}
+
+	if serverNames := directives["servername"]; len(serverNames) > 0 {
+		vhost.ServerName = serverNames[len(serverNames)-1]
+	}
+
+	vhost.Aliases = directives["serveralias"]
+
+	if docRoots := directives["documentroot"]; len(docRoots) > 0 {
+		vhost.DocRoot = docRoots[len(docRoots)-1]
+
+		//  If the directory-path is not absolute then it is assumed to be relative to the ServerRoot.
+		if !strings.HasPrefix(vhost.DocRoot, string(filepath.Separator)) {
+			vhost.DocRoot = filepath.Join(ac.parser.ServerRoot, vhost.DocRoot)
+		}
+	}
+
+	for _, value := range directives["sslengine"] {
+		if strings.EqualFold(value, "on") {
+			vhost.Ssl = true
+		}
+	}
+
+	return vhost, nil
+}
+
+// getMacroDirectivesArgs returns arguments of the active directives nested in the node by lower case names of the directives
+func (ac *apacheConfigurator) getMacroDirectivesArgs(node *Node, replacer *strings.Replacer, names ...string) (map[string][]string, error) {
+	directivesArgs := make(map[string][]string)
+
+	for _, name := range names {
+		matches, err := ac.parser.FindDirective(name, "", node.Path, true)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, match := range matches {
+			argNode, err := ac.parser.Tree.GetNode(match)
+
+			if err != nil {
+				return nil, err
+			}
+
+			key := strings.ToLower(name)
+			directivesArgs[key] = append(directivesArgs[key], ac.expandMacroArg(argNode.Value, argNode.Path, replacer))
+		}
+	}
+
+	return directivesArgs, nil
+}
+
+func (ac *apacheConfigurator) getMacroArgs(node *Node, replacer *strings.Replacer) ([]string, error) {
+	argNodes, err := ac.parser.Tree.GetArgs(node)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var args []string
+
+	for _, argNode := range argNodes {
+		args = append(args, ac.expandMacroArg(argNode.Value, argNode.Path, replacer))
+	}
+
+	return args, nil
+}
+
+// expandMacroArg substitutes the macro parameters and then the variables of the argument
+func (ac *apacheConfigurator) expandMacroArg(value, path string, replacer *strings.Replacer) string {
+	if replacer != nil {
+		value = replacer.Replace(value)
+	}
+
+	interpreted, err := ac.parser.InterpretArgAt(value, path)
+
+	// apache keeps unknown variables as is
+	if err != nil {
+		return value
+	}
+
+	return interpreted
+}
+
+// getMacroReplacer returns replacer of the macro parameters. Longer parameters are replaced first, e.g. $domain before $dom.
+func getMacroReplacer(params, values []string) *strings.Replacer {
+	indexes := make([]int, len(params))
+
+	for i := range indexes {
+		indexes[i] = i
+	}
+
+	sort.SliceStable(indexes, func(i, j int) bool {
+		return len(params[indexes[i]]) > len(params[indexes[j]])
+	})
+
+	var oldnew []string
+
+	for _, i := range indexes {
+		oldnew = append(oldnew, params[i], values[i])
+	}
+
+	return strings.NewReplacer(oldnew...)
+}
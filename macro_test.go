@@ -0,0 +1,111 @@
+package a2conf
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/r2dtools/a2conf/entity"
+	"github.com/stretchr/testify/assert"
+)
+
+const testMacroConfig = `LoadModule macro_module modules/mod_macro.so
+<Macro VHost $domain $port $dom>
+	<VirtualHost *:$port>
+		ServerName $domain
+		ServerAlias www.$domain $dom.local
+		DocumentRoot /var/www/$dom
+		<IfModule mod_ssl.c>
+			SSLEngine on
+		</IfModule>
+	</VirtualHost>
+</Macro>
+<Macro SslVHost $domain>
+	Use VHost $domain 443 secure
+</Macro>
+Use VHost example.com 80 example
+Use SslVHost secure.com
+Use Missing example.org
+<IfModule mod_rewrite.c>
+	Use VHost inactive.com 80 inactive
+</IfModule>
+`
+
+func TestMacroVhosts(t *testing.T) {
+	runWithBackends(t, func(t *testing.T, backend string) {
+		configurator, dir := getTestConfigurator(t, backend, map[string]string{"apache2.conf": testMacroConfig})
+		defer os.RemoveAll(dir)
+		defer configurator.Close()
+
+		configPath := filepath.Join(dir, "apache2.conf")
+
+		vhosts, err := configurator.GetVhosts()
+		assert.Nilf(t, err, "could not get vhosts: %v", err)
+
+		macroVhosts := make(map[string]*entity.VirtualHost)
+		var templates int
+
+		for _, vhost := range vhosts {
+			if vhost.ModMacro {
+				templates++
+				continue
+			}
+
+			macroVhosts[vhost.ServerName] = vhost
+		}
+
+		assert.Equal(t, 1, templates)
+		assert.Equal(t, 2, len(macroVhosts))
+
+		vhost := macroVhosts["example.com"]
+		assert.NotNil(t, vhost)
+		assert.Equal(t, "VHost", vhost.Macro)
+		assert.Equal(t, configPath, vhost.FilePath)
+		assert.Equal(t, []string{"www.example.com", "example.local"}, vhost.Aliases)
+		assert.Equal(t, "/var/www/example", vhost.DocRoot)
+		assert.Equal(t, "*:80", vhost.GetAddressesString(false))
+		assert.False(t, vhost.Ssl)
+
+		useNode, err := configurator.GetParser().Tree.GetNode(vhost.AugPath)
+		assert.Nilf(t, err, "could not get use directive: %v", err)
+		assert.Equal(t, "Use", useNode.Name)
+
+		vhost = macroVhosts["secure.com"]
+		assert.NotNil(t, vhost)
+		assert.Equal(t, "SslVHost", vhost.Macro)
+		assert.Equal(t, "/var/www/secure", vhost.DocRoot)
+		assert.True(t, vhost.Ssl)
+
+		suitableVhosts, err := configurator.FindSuitableVhosts("example.com")
+		assert.Nilf(t, err, "could not find suitable vhosts: %v", err)
+		assert.Equal(t, []*entity.VirtualHost{macroVhosts["example.com"]}, suitableVhosts)
+
+		_, err = configurator.GetSuitableVhosts("example.com", true)
+		assert.NotNil(t, err, "macro virtual hosts must not be modified")
+	})
+}
+
+func TestGetMacroReplacer(t *testing.T) {
+	replacer := getMacroReplacer([]string{"$dom", "$domain", "@port"}, []string{"example", "example.com", "80"})
+	assert.Equal(t, "www.example.com:80 example.local", replacer.Replace("www.$domain:@port $dom.local"))
+}
+
+func TestGetSuitableVhostsSkipsMacroVhosts(t *testing.T) {
+	runWithBackends(t, func(t *testing.T, backend string) {
+		config := testMacroConfig + "<VirtualHost *:80>\n\tServerName example.com\n\tDocumentRoot /var/www/html\n</VirtualHost>\n"
+		configurator, dir := getTestConfigurator(t, backend, map[string]string{"apache2.conf": config})
+		defer os.RemoveAll(dir)
+		defer configurator.Close()
+
+		suitableVhosts, err := configurator.FindSuitableVhosts("example.com")
+		assert.Nilf(t, err, "could not find suitable vhosts: %v", err)
+		assert.Equal(t, 2, len(suitableVhosts))
+
+		sslVhosts, err := configurator.GetSuitableVhosts("example.com", true)
+		assert.Nilf(t, err, "could not get suitable vhosts: %v", err)
+		assert.Equal(t, 1, len(sslVhosts))
+		assert.Equal(t, "", sslVhosts[0].Macro)
+		assert.True(t, sslVhosts[0].Ssl)
+		assert.Equal(t, "example.com", sslVhosts[0].ServerName)
+	})
+}
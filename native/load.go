@@ -0,0 +1,390 @@
+package native
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/r2dtools/a2conf/utils"
+)
+
+// Save modes, see /augeas/save
+const (
+	saveOverwrite = "overwrite"
+	saveBackup    = "backup"
+	saveNewFile   = "newfile"
+	saveNoop      = "noop"
+)
+
+// excludedFilePatterns are files that are never loaded, e.g. backups
+var excludedFilePatterns = []string{"*.augnew", "*.augsave", "*.dpkg-*", "*.rpmnew", "*.rpmsave", "*.rpmorig", "*~", "#*#", ".#*", "*.bak", "*.old", "*.orig", "*.swp"}
+
+// Load loads files specified in /augeas/load. Trees of the files that are changed on the disk or in the tree are reloaded,
+// so unsaved changes are lost. Trees of the files that are not specified anymore are removed.
+func (t *Tree) Load() error {
+	files := t.getFilesToLoad()
+	filesMap := make(map[string]bool)
+
+	for _, file := range files {
+		filesMap[file] = true
+	}
+
+	for file, metadata := range t.getFilesMetadata() {
+		if !filesMap[file] {
+			metadata.detach()
+
+			if fileNode := t.getFileNode(file); fileNode != nil {
+				fileNode.detach()
+			}
+		}
+	}
+
+	for _, file := range files {
+		t.loadFile(file, false)
+	}
+
+	return nil
+}
+
+// LoadFile loads a single file. The file must be specified in /augeas/load.
+func (t *Tree) LoadFile(filePath string) error {
+	if !t.isFileIncluded(filePath) {
+		return fmt.Errorf("file '%s' is not included in any transform", filePath)
+	}
+
+	return t.loadFile(filePath, true)
+}
+
+// Save saves changed files according to the save mode in /augeas/save.
+// Paths of the saved files are stored in /augeas/events/saved.
+func (t *Tree) Save() error {
+	mode := saveOverwrite
+
+	if saveNode := t.root.getChild("augeas").getChild("save"); saveNode != nil && saveNode.value != "" {
+		mode = saveNode.value
+	}
+
+	events := t.root.getChild("augeas").ensureChild("events")
+
+	for _, event := range events.getChildren("saved") {
+		event.detach()
+	}
+
+	var saveErr error
+	fileNodes := t.getFileNodes()
+	var files []string
+
+	for file := range fileNodes {
+		files = append(files, file)
+	}
+
+	sort.Strings(files)
+
+	for _, file := range files {
+		fileNode := fileNodes[file]
+
+		if !fileNode.file.dirty {
+			continue
+		}
+
+		if err := t.saveFile(file, fileNode, mode); err != nil {
+			t.setFileError(file, "put_failed", err.Error())
+
+			if saveErr == nil {
+				saveErr = err
+			}
+
+			continue
+		}
+
+		events.appendChild("saved").setValue("/files" + escapePath(file))
+	}
+
+	return saveErr
+}
+
+// saveFile writes the file like augeas does it: the content is written to a temporary file which replaces the original one.
+// Symlinks are followed, mode, owner and extended attributes of the original file are kept.
+func (t *Tree) saveFile(file string, fileNode *node, mode string) error {
+	content := []byte(render(fileNode))
+	realPath := file
+	var attrs *utils.FileAttrs
+
+	if _, err := os.Lstat(file); err == nil {
+		if realPath, err = filepath.EvalSymlinks(file); err != nil {
+			return err
+		}
+
+		if attrs, err = utils.GetFileAttrs(realPath); err != nil {
+			return err
+		}
+	}
+
+	switch mode {
+	case saveNoop:
+		return nil
+	case saveNewFile:
+		return utils.WriteFileAtomic(file+".augnew", content, attrs)
+	case saveBackup:
+		if original, err := ioutil.ReadFile(realPath); err == nil {
+			if err = utils.WriteFileAtomic(file+".augsave", original, attrs); err != nil {
+				return err
+			}
+		}
+	case saveOverwrite:
+	default:
+		return fmt.Errorf("invalid save mode '%s'", mode)
+	}
+
+	if err := utils.WriteFileAtomic(realPath, content, attrs); err != nil {
+		return err
+	}
+
+	fileNode.file.dirty = false
+	t.setFileMetadata(file)
+
+	return nil
+}
+
+// loadFile parses the file and replaces its tree. If force is false, the tree is replaced only if the file is changed.
+func (t *Tree) loadFile(file string, force bool) error {
+	info, err := os.Stat(file)
+
+	if err != nil {
+		return err
+	}
+
+	fileNode := t.getFileNode(file)
+	metadata := t.getFileMetadataNode(file)
+	mtime := strconv.FormatInt(info.ModTime().UnixNano(), 10)
+
+	if !force && fileNode != nil && !fileNode.file.dirty && metadata != nil && metadata.getChild("mtime") != nil && metadata.getChild("mtime").value == mtime {
+		return nil
+	}
+
+	if fileNode != nil {
+		fileNode.detach()
+	}
+
+	content, err := ioutil.ReadFile(file)
+
+	if err != nil {
+		t.setFileError(file, "read_failed", err.Error())
+		return err
+	}
+
+	root, err := parseConfig(file, string(content))
+
+	if err != nil {
+		message := err.Error()
+		parseErr, ok := err.(*ParseError)
+
+		// the position is stored separately
+		if ok {
+			message = parseErr.Message
+		}
+
+		metadata := t.setFileError(file, "parse_failed", message)
+
+		if ok {
+			metadata.getChild("error").appendChild("line").setValue(strconv.Itoa(parseErr.Line))
+			metadata.getChild("error").appendChild("char").setValue(strconv.Itoa(parseErr.Char))
+			metadata.getChild("error").appendChild("pos").setValue(strconv.Itoa(parseErr.Pos))
+		}
+
+		return err
+	}
+
+	parent := t.root.getChild("files")
+	parts := splitFilePath(file)
+
+	for _, part := range parts[:len(parts)-1] {
+		parent = parent.ensureChild(part)
+	}
+
+	root.label = parts[len(parts)-1]
+	parent.addParsedChild(root)
+	t.setFileMetadata(file)
+
+	return nil
+}
+
+func (t *Tree) setFileMetadata(file string) *node {
+	metadata := t.ensureFileMetadataNode(file)
+	metadata.children = nil
+	metadata.appendChild("path").setValue("/files" + escapePath(file))
+	metadata.appendChild("lens").setValue("@Httpd")
+
+	if info, err := os.Stat(file); err == nil {
+		metadata.appendChild("mtime").setValue(strconv.FormatInt(info.ModTime().UnixNano(), 10))
+	}
+
+	return metadata
+}
+
+func (t *Tree) setFileError(file, errorType, message string) *node {
+	metadata := t.ensureFileMetadataNode(file)
+
+	for _, errorNode := range metadata.getChildren("error") {
+		errorNode.detach()
+	}
+
+	if metadata.getChild("path") == nil {
+		metadata.appendChild("path").setValue("/files" + escapePath(file))
+	}
+
+	errorNode := metadata.appendChild("error")
+	errorNode.setValue(errorType)
+	errorNode.appendChild("message").setValue(message)
+
+	return metadata
+}
+
+// getFilesToLoad returns files matching incl patterns and not matching excl patterns of the transforms in /augeas/load
+func (t *Tree) getFilesToLoad() []string {
+	filesMap := make(map[string]bool)
+
+	for _, transform := range t.root.getChild("augeas").ensureChild("load").children {
+		excludes := append([]string{}, excludedFilePatterns...)
+
+		for _, excl := range transform.getChildren("excl") {
+			excludes = append(excludes, excl.value)
+		}
+
+		for _, incl := range transform.getChildren("incl") {
+			matches, _ := filepath.Glob(incl.value)
+
+			for _, match := range matches {
+				if info, err := os.Stat(match); err != nil || info.IsDir() || isFileExcluded(match, excludes) {
+					continue
+				}
+
+				filesMap[match] = true
+			}
+		}
+	}
+
+	var files []string
+
+	for file := range filesMap {
+		files = append(files, file)
+	}
+
+	sort.Strings(files)
+
+	return files
+}
+
+func (t *Tree) isFileIncluded(filePath string) bool {
+	for _, transform := range t.root.getChild("augeas").ensureChild("load").children {
+		for _, incl := range transform.getChildren("incl") {
+			if matched, _ := filepath.Match(incl.value, filePath); matched {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func isFileExcluded(filePath string, excludes []string) bool {
+	for _, exclude := range excludes {
+		if matched, _ := filepath.Match(exclude, filepath.Base(filePath)); matched {
+			return true
+		}
+
+		if matched, _ := filepath.Match(exclude, filePath); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// getFileNodes returns root nodes of the files in the /files subtree
+func (t *Tree) getFileNodes() map[string]*node {
+	fileNodes := make(map[string]*node)
+	var walk func(n *node, path string)
+	walk = func(n *node, path string) {
+		for _, child := range n.children {
+			childPath := path + "/" + child.label
+
+			if child.file != nil {
+				fileNodes[childPath] = child
+				continue
+			}
+
+			walk(child, childPath)
+		}
+	}
+	walk(t.root.getChild("files"), "")
+
+	return fileNodes
+}
+
+// getFilesMetadata returns metadata nodes of the loaded files in the /augeas/files subtree
+func (t *Tree) getFilesMetadata() map[string]*node {
+	metadata := make(map[string]*node)
+	var walk func(n *node, path string)
+	walk = func(n *node, path string) {
+		if n.getChild("path") != nil {
+			metadata[path] = n
+			return
+		}
+
+		for _, child := range n.children {
+			walk(child, path+"/"+child.label)
+		}
+	}
+	walk(t.root.getChild("augeas").ensureChild("files"), "")
+
+	return metadata
+}
+
+func (t *Tree) getFileNode(file string) *node {
+	return getDescendant(t.root.getChild("files"), splitFilePath(file))
+}
+
+func (t *Tree) getFileMetadataNode(file string) *node {
+	return getDescendant(t.root.getChild("augeas").ensureChild("files"), splitFilePath(file))
+}
+
+func (t *Tree) ensureFileMetadataNode(file string) *node {
+	n := t.root.getChild("augeas").ensureChild("files")
+
+	for _, part := range splitFilePath(file) {
+		n = n.ensureChild(part)
+	}
+
+	return n
+}
+
+func getDescendant(n *node, labels []string) *node {
+	for _, label := range labels {
+		if n == nil {
+			return nil
+		}
+
+		n = n.getChild(label)
+	}
+
+	return n
+}
+
+func splitFilePath(file string) []string {
+	return strings.Split(strings.Trim(filepath.Clean(file), "/"), "/")
+}
+
+func escapePath(file string) string {
+	parts := splitFilePath(file)
+
+	for i, part := range parts {
+		parts[i] = escapeLabel(part)
+	}
+
+	return "/" + strings.Join(parts, "/")
+}
@@ -0,0 +1,246 @@
+package native
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+const (
+	argLabel       = "arg"
+	directiveLabel = "directive"
+	commentLabel   = "#comment"
+)
+
+// ParseError is an error of the config file parsing
+type ParseError struct {
+	Message string
+	Line    int
+	Char    int
+	Pos     int
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s at line %d, char %d", e.Message, e.Line, e.Char)
+}
+
+type configParser struct {
+	filename string
+	content  string
+	pos      int
+	line     int
+}
+
+// parseConfig parses content of the config file into a tree of nodes. The returned node is a file root node.
+func parseConfig(filename, content string) (*node, error) {
+	p := &configParser{filename: filename, content: content, line: 1}
+	root := &node{
+		file:   &fileInfo{newline: getNewline(content)},
+		format: &format{},
+		span:   &Span{Filename: filename, SpanStart: 0, SpanEnd: uint(len(content))},
+	}
+	stack := []*node{root}
+	leadingStart := 0
+
+	for p.pos < len(p.content) {
+		lineStart, lineNumber := p.pos, p.line
+		end := p.readLogicalLine()
+		raw := p.content[lineStart:end]
+		// continuation sequences are whitespaces within a line
+		logical := strings.TrimSpace(strings.NewReplacer("\\\r\n", " ", "\\\n", " ").Replace(raw))
+
+		// lines with an empty comment are blank lines as well
+		if logical == "" || logical == "#" {
+			continue
+		}
+
+		nodeStart := lineStart + len(raw) - len(strings.TrimLeftFunc(raw, unicode.IsSpace))
+		nodeFormat := &format{leading: p.content[leadingStart:nodeStart], text: p.content[nodeStart:end]}
+		nodeSpan := &Span{Filename: p.filename, SpanStart: uint(nodeStart), SpanEnd: uint(end)}
+		leadingStart = end
+		parent := stack[len(stack)-1]
+		errorAt := func(message string) error {
+			return &ParseError{Message: message, Line: lineNumber, Char: nodeStart - lineStart + 1, Pos: nodeStart}
+		}
+
+		switch {
+		case strings.HasPrefix(logical, "#"):
+			comment := &node{label: commentLabel, value: strings.TrimSpace(logical[1:]), hasValue: true, format: nodeFormat, span: nodeSpan}
+			parent.addParsedChild(comment)
+		case strings.HasPrefix(logical, "</"):
+			name := strings.TrimSpace(strings.TrimSuffix(logical[2:], ">"))
+
+			if len(stack) == 1 {
+				return nil, errorAt(fmt.Sprintf("unexpected closing tag '</%s>'", name))
+			}
+
+			if !strings.EqualFold(parent.label, name) {
+				return nil, errorAt(fmt.Sprintf("closing tag '</%s>' does not match section '%s'", name, parent.label))
+			}
+
+			parent.format.tail = nodeFormat.leading + nodeFormat.text
+			parent.span.SpanEnd = uint(end)
+			stack = stack[:len(stack)-1]
+		case strings.HasPrefix(logical, "<"):
+			closeIdx := strings.LastIndex(logical, ">")
+
+			if closeIdx == -1 {
+				return nil, errorAt("section tag is not closed with '>'")
+			}
+
+			tokens, err := tokenize(logical[1:closeIdx])
+
+			if err != nil || len(tokens) == 0 {
+				return nil, errorAt("invalid section tag")
+			}
+
+			section := &node{label: tokens[0], format: nodeFormat, span: nodeSpan}
+			section.addArgs(tokens[1:])
+			parent.addParsedChild(section)
+			stack = append(stack, section)
+		default:
+			tokens, err := tokenize(logical)
+
+			if err != nil {
+				return nil, errorAt(err.Error())
+			}
+
+			directive := &node{label: directiveLabel, value: tokens[0], hasValue: true, format: nodeFormat, span: nodeSpan}
+			directive.addArgs(tokens[1:])
+			parent.addParsedChild(directive)
+		}
+	}
+
+	if len(stack) > 1 {
+		section := stack[len(stack)-1]
+		return nil, &ParseError{Message: fmt.Sprintf("section '%s' is not closed", section.label), Line: p.line, Char: 1, Pos: len(p.content)}
+	}
+
+	root.format.tail = p.content[leadingStart:]
+
+	return root, nil
+}
+
+// readLogicalLine reads a line including continuation lines and returns the position after its end
+func (p *configParser) readLogicalLine() int {
+	for p.pos < len(p.content) {
+		newLineIdx := strings.IndexByte(p.content[p.pos:], '\n')
+
+		if newLineIdx == -1 {
+			p.pos = len(p.content)
+			break
+		}
+
+		lineEnd := p.pos + newLineIdx
+		p.pos = lineEnd + 1
+		p.line++
+		line := strings.TrimSuffix(p.content[:lineEnd], "\r")
+
+		if !strings.HasSuffix(line, "\\") {
+			break
+		}
+	}
+
+	return p.pos
+}
+
+// tokenize splits a line into the words. Quoted words keep their quotes as Augeas does.
+func tokenize(line string) ([]string, error) {
+	var tokens []string
+	pos := 0
+
+	for {
+		for pos < len(line) && unicode.IsSpace(rune(line[pos])) {
+			pos++
+		}
+
+		if pos >= len(line) {
+			return tokens, nil
+		}
+
+		start := pos
+
+		for pos < len(line) && !unicode.IsSpace(rune(line[pos])) {
+			char := line[pos]
+
+			switch char {
+			case '\\':
+				pos += 2
+				continue
+			case '"', '\'':
+				// quotes within a bare word are the part of it, e.g. it's
+				if pos != start {
+					break
+				}
+
+				closeIdx := findClosingQuote(line, pos)
+
+				// apache accepts the last argument with the unclosed double quote, e.g. a message
+				if closeIdx == -1 {
+					if char == '\'' {
+						return nil, fmt.Errorf("unclosed quote in '%s'", line)
+					}
+
+					pos = len(line)
+					continue
+				}
+
+				pos = closeIdx + 1
+				continue
+			case '{':
+				// word list, e.g. in SSLRequire
+				if pos == start {
+					if closeIdx := strings.IndexByte(line[pos:], '}'); closeIdx != -1 {
+						pos += closeIdx + 1
+						continue
+					}
+				}
+			}
+
+			pos++
+		}
+
+		if pos > len(line) {
+			pos = len(line)
+		}
+
+		tokens = append(tokens, strings.TrimRightFunc(line[start:pos], unicode.IsSpace))
+	}
+}
+
+func findClosingQuote(line string, start int) int {
+	quote := line[start]
+
+	for i := start + 1; i < len(line); i++ {
+		if line[i] == '\\' {
+			i++
+			continue
+		}
+
+		if line[i] == quote {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func (n *node) addParsedChild(child *node) {
+	child.parent = n
+	n.children = append(n.children, child)
+}
+
+func (n *node) addArgs(args []string) {
+	for _, arg := range args {
+		n.addParsedChild(&node{label: argLabel, value: arg, hasValue: true})
+	}
+}
+
+// getNewline returns a line ending of the content: CRLF if the first line ends with it, LF otherwise
+func getNewline(content string) string {
+	if index := strings.Index(content, "\n"); index > 0 && content[index-1] == '\r' {
+		return "\r\n"
+	}
+
+	return "\n"
+}
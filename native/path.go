@@ -0,0 +1,832 @@
+package native
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// labelSpecialChars are characters that must be escaped in labels within path expressions
+const labelSpecialChars = "][|/=()!,\\"
+
+const (
+	axisChild            = "child"
+	axisSelf             = "self"
+	axisParent           = "parent"
+	axisDescendant       = "descendant"
+	axisDescendantOrSelf = "descendant-or-self"
+)
+
+var axes = []string{axisDescendantOrSelf, axisDescendant, axisChild, axisSelf, axisParent}
+
+// pathExpr is a subset of Augeas path expressions:
+// steps with child, self, parent, descendant and descendant-or-self axes, "//" abbreviation, "*", "." and "..",
+// predicates with positions, last(), position(), label(), count(), not(), regexp() and glob() functions,
+// =, !=, =~, !~, +, -, and, or operators.
+type pathExpr struct {
+	absolute bool
+	steps    []*step
+}
+
+type step struct {
+	axis       string
+	name       string
+	predicates []expr
+}
+
+type expr interface {
+	eval(ctx *evalContext) value
+}
+
+type evalContext struct {
+	root     *node
+	node     *node
+	position int
+	size     int
+}
+
+type valueKind int
+
+const (
+	kindNodes valueKind = iota
+	kindString
+	kindNumber
+	kindBool
+	kindRegexp
+)
+
+type value struct {
+	kind    valueKind
+	nodes   []*node
+	str     string
+	num     int
+	boolean bool
+	regexps []*regexp.Regexp
+}
+
+type pathParser struct {
+	input string
+	pos   int
+}
+
+func parsePath(path string) (*pathExpr, error) {
+	p := &pathParser{input: path}
+	expr, err := p.parsePathExpr(false)
+
+	if err != nil {
+		return nil, err
+	}
+
+	p.skipSpaces()
+
+	if p.pos < len(p.input) {
+		return nil, p.errorf("unexpected character '%c'", p.input[p.pos])
+	}
+
+	return expr, nil
+}
+
+func (p *pathParser) parsePathExpr(inPredicate bool) (*pathExpr, error) {
+	expr := &pathExpr{}
+
+	if p.hasPrefix("//") {
+		p.pos += 2
+		expr.absolute = true
+		expr.steps = append(expr.steps, &step{axis: axisDescendantOrSelf, name: "*"})
+	} else if p.peek() == '/' {
+		p.pos++
+		expr.absolute = true
+
+		// "/" is the root itself
+		if p.isPathEnd(inPredicate) {
+			return expr, nil
+		}
+	}
+
+	for {
+		s, err := p.parseStep(inPredicate)
+
+		if err != nil {
+			return nil, err
+		}
+
+		expr.steps = append(expr.steps, s)
+
+		if !inPredicate {
+			p.skipSpaces()
+		}
+
+		if p.hasPrefix("//") {
+			p.pos += 2
+			expr.steps = append(expr.steps, &step{axis: axisDescendantOrSelf, name: "*"})
+		} else if p.peek() == '/' {
+			p.pos++
+		} else {
+			return expr, nil
+		}
+	}
+}
+
+func (p *pathParser) parseStep(inPredicate bool) (*step, error) {
+	s := &step{axis: axisChild}
+
+	if p.hasPrefix("..") {
+		p.pos += 2
+		s.axis = axisParent
+		s.name = "*"
+	} else if p.peek() == '.' {
+		p.pos++
+		s.axis = axisSelf
+		s.name = "*"
+	} else {
+		for _, axis := range axes {
+			if p.hasPrefix(axis + "::") {
+				s.axis = axis
+				p.pos += len(axis) + 2
+				break
+			}
+		}
+
+		if p.peek() == '*' {
+			p.pos++
+			s.name = "*"
+		} else {
+			name, err := p.parseName(inPredicate)
+
+			if err != nil {
+				return nil, err
+			}
+
+			s.name = name
+		}
+	}
+
+	for {
+		if !inPredicate {
+			p.skipSpaces()
+		}
+
+		if p.peek() != '[' {
+			break
+		}
+
+		p.pos++
+		predicate, err := p.parseOr()
+
+		if err != nil {
+			return nil, err
+		}
+
+		p.skipSpaces()
+
+		if p.peek() != ']' {
+			return nil, p.errorf("expected ']'")
+		}
+
+		p.pos++
+		s.predicates = append(s.predicates, predicate)
+	}
+
+	return s, nil
+}
+
+func (p *pathParser) parseName(inPredicate bool) (string, error) {
+	var builder strings.Builder
+
+	for p.pos < len(p.input) {
+		char := p.input[p.pos]
+
+		if char == '\\' && p.pos+1 < len(p.input) {
+			builder.WriteByte(p.input[p.pos+1])
+			p.pos += 2
+			continue
+		}
+
+		if strings.IndexByte(labelSpecialChars, char) != -1 || (inPredicate && unicode.IsSpace(rune(char))) {
+			break
+		}
+
+		builder.WriteByte(char)
+		p.pos++
+	}
+
+	// whitespaces between the name and the predicate or the end of the path are not part of the name
+	name := strings.TrimRightFunc(builder.String(), unicode.IsSpace)
+
+	if name == "" {
+		return "", p.errorf("expected name")
+	}
+
+	return name, nil
+}
+
+func (p *pathParser) parseOr() (expr, error) {
+	left, err := p.parseAnd()
+
+	if err != nil {
+		return nil, err
+	}
+
+	for p.acceptKeyword("or") {
+		right, err := p.parseAnd()
+
+		if err != nil {
+			return nil, err
+		}
+
+		left = &binaryExpr{op: "or", left: left, right: right}
+	}
+
+	return left, nil
+}
+
+func (p *pathParser) parseAnd() (expr, error) {
+	left, err := p.parseComparison()
+
+	if err != nil {
+		return nil, err
+	}
+
+	for p.acceptKeyword("and") {
+		right, err := p.parseComparison()
+
+		if err != nil {
+			return nil, err
+		}
+
+		left = &binaryExpr{op: "and", left: left, right: right}
+	}
+
+	return left, nil
+}
+
+func (p *pathParser) parseComparison() (expr, error) {
+	left, err := p.parseAdditive()
+
+	if err != nil {
+		return nil, err
+	}
+
+	p.skipSpaces()
+
+	for _, op := range []string{"=~", "!~", "!=", "="} {
+		if p.hasPrefix(op) {
+			p.pos += len(op)
+			right, err := p.parseAdditive()
+
+			if err != nil {
+				return nil, err
+			}
+
+			return &binaryExpr{op: op, left: left, right: right}, nil
+		}
+	}
+
+	return left, nil
+}
+
+func (p *pathParser) parseAdditive() (expr, error) {
+	left, err := p.parsePrimary()
+
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		p.skipSpaces()
+		op := p.peek()
+
+		if op != '+' && op != '-' {
+			return left, nil
+		}
+
+		p.pos++
+		right, err := p.parsePrimary()
+
+		if err != nil {
+			return nil, err
+		}
+
+		left = &binaryExpr{op: string(op), left: left, right: right}
+	}
+}
+
+func (p *pathParser) parsePrimary() (expr, error) {
+	p.skipSpaces()
+	char := p.peek()
+
+	switch {
+	case char == '\'' || char == '"':
+		return p.parseString()
+	case char >= '0' && char <= '9':
+		start := p.pos
+
+		for p.peek() >= '0' && p.peek() <= '9' {
+			p.pos++
+		}
+
+		num, _ := strconv.Atoi(p.input[start:p.pos])
+
+		return &literalExpr{value{kind: kindNumber, num: num}}, nil
+	case char == '(':
+		p.pos++
+		e, err := p.parseOr()
+
+		if err != nil {
+			return nil, err
+		}
+
+		p.skipSpaces()
+
+		if p.peek() != ')' {
+			return nil, p.errorf("expected ')'")
+		}
+
+		p.pos++
+
+		return e, nil
+	}
+
+	if name, ok := p.peekFunctionName(); ok {
+		return p.parseFunction(name)
+	}
+
+	path, err := p.parsePathExpr(true)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &nodesExpr{path: path}, nil
+}
+
+func (p *pathParser) parseString() (expr, error) {
+	quote := p.input[p.pos]
+	p.pos++
+	var builder strings.Builder
+
+	for p.pos < len(p.input) && p.input[p.pos] != quote {
+		if p.input[p.pos] == '\\' && p.pos+1 < len(p.input) && p.input[p.pos+1] == quote {
+			p.pos++
+		}
+
+		builder.WriteByte(p.input[p.pos])
+		p.pos++
+	}
+
+	if p.pos >= len(p.input) {
+		return nil, p.errorf("unterminated string")
+	}
+
+	p.pos++
+
+	return &literalExpr{value{kind: kindString, str: builder.String()}}, nil
+}
+
+func (p *pathParser) peekFunctionName() (string, bool) {
+	for _, name := range []string{"last", "position", "label", "count", "not", "regexp", "glob"} {
+		if !p.hasPrefix(name) {
+			continue
+		}
+
+		rest := strings.TrimLeftFunc(p.input[p.pos+len(name):], unicode.IsSpace)
+
+		if strings.HasPrefix(rest, "(") {
+			return name, true
+		}
+	}
+
+	return "", false
+}
+
+func (p *pathParser) parseFunction(name string) (expr, error) {
+	p.pos += len(name)
+	p.skipSpaces()
+	p.pos++
+	var args []expr
+	p.skipSpaces()
+
+	for p.peek() != ')' {
+		arg, err := p.parseOr()
+
+		if err != nil {
+			return nil, err
+		}
+
+		args = append(args, arg)
+		p.skipSpaces()
+
+		if p.peek() == ',' {
+			p.pos++
+		} else if p.peek() != ')' {
+			return nil, p.errorf("expected ')' after arguments of function '%s'", name)
+		}
+	}
+
+	p.pos++
+	f := &functionExpr{name: name, args: args}
+
+	if err := f.check(); err != nil {
+		return nil, p.errorf("%v", err)
+	}
+
+	return f, nil
+}
+
+func (p *pathParser) acceptKeyword(keyword string) bool {
+	p.skipSpaces()
+
+	if !p.hasPrefix(keyword) {
+		return false
+	}
+
+	next := p.pos + len(keyword)
+
+	if next < len(p.input) && !unicode.IsSpace(rune(p.input[next])) && p.input[next] != '(' {
+		return false
+	}
+
+	p.pos = next
+
+	return true
+}
+
+func (p *pathParser) isPathEnd(inPredicate bool) bool {
+	if p.pos >= len(p.input) {
+		return true
+	}
+
+	char := p.input[p.pos]
+
+	return inPredicate && (char == ']' || char == ')' || char == ',' || char == '=' || char == '!' || unicode.IsSpace(rune(char)))
+}
+
+func (p *pathParser) skipSpaces() {
+	for p.pos < len(p.input) && unicode.IsSpace(rune(p.input[p.pos])) {
+		p.pos++
+	}
+}
+
+func (p *pathParser) peek() byte {
+	if p.pos >= len(p.input) {
+		return 0
+	}
+
+	return p.input[p.pos]
+}
+
+func (p *pathParser) hasPrefix(prefix string) bool {
+	return strings.HasPrefix(p.input[p.pos:], prefix)
+}
+
+func (p *pathParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("invalid path expression '%s' at position %d: %s", p.input, p.pos, fmt.Sprintf(format, args...))
+}
+
+func (e *pathExpr) eval(root *node, context []*node) []*node {
+	current := context
+
+	if e.absolute {
+		current = []*node{root}
+	}
+
+	for _, s := range e.steps {
+		current = s.eval(root, current)
+	}
+
+	return current
+}
+
+func (s *step) eval(root *node, context []*node) []*node {
+	var result []*node
+	seen := make(map[*node]bool)
+
+	for _, n := range context {
+		candidates := s.evalPredicates(root, s.axisNodes(n))
+
+		for _, candidate := range candidates {
+			if !seen[candidate] {
+				seen[candidate] = true
+				result = append(result, candidate)
+			}
+		}
+	}
+
+	return result
+}
+
+func (s *step) axisNodes(n *node) []*node {
+	var nodes []*node
+
+	switch s.axis {
+	case axisSelf:
+		nodes = []*node{n}
+	case axisParent:
+		if n.parent != nil {
+			nodes = []*node{n.parent}
+		}
+	case axisChild:
+		nodes = n.children
+	case axisDescendant, axisDescendantOrSelf:
+		var walk func(n *node)
+		walk = func(n *node) {
+			for _, child := range n.children {
+				nodes = append(nodes, child)
+				walk(child)
+			}
+		}
+
+		if s.axis == axisDescendantOrSelf {
+			nodes = append(nodes, n)
+		}
+
+		walk(n)
+	}
+
+	if s.name == "*" {
+		return nodes
+	}
+
+	var matched []*node
+
+	for _, candidate := range nodes {
+		if candidate.label == s.name {
+			matched = append(matched, candidate)
+		}
+	}
+
+	return matched
+}
+
+func (s *step) evalPredicates(root *node, nodes []*node) []*node {
+	for _, predicate := range s.predicates {
+		var filtered []*node
+
+		for i, n := range nodes {
+			ctx := &evalContext{root: root, node: n, position: i + 1, size: len(nodes)}
+
+			if predicate.eval(ctx).isTrue(ctx) {
+				filtered = append(filtered, n)
+			}
+		}
+
+		nodes = filtered
+	}
+
+	return nodes
+}
+
+// isCreatable checks if a missing node for the step can be created: the step must be a name with an optional position
+func (s *step) isCreatable() bool {
+	if s.axis != axisChild || s.name == "*" || len(s.predicates) > 1 {
+		return false
+	}
+
+	if len(s.predicates) == 0 {
+		return true
+	}
+
+	switch predicate := s.predicates[0].(type) {
+	case *literalExpr:
+		return predicate.value.kind == kindNumber
+	case *functionExpr:
+		return predicate.name == "last"
+	case *binaryExpr:
+		function, ok := predicate.left.(*functionExpr)
+
+		return ok && function.name == "last" && predicate.op == "+"
+	}
+
+	return false
+}
+
+type literalExpr struct {
+	value value
+}
+
+func (e *literalExpr) eval(ctx *evalContext) value {
+	return e.value
+}
+
+type nodesExpr struct {
+	path *pathExpr
+}
+
+func (e *nodesExpr) eval(ctx *evalContext) value {
+	return value{kind: kindNodes, nodes: e.path.eval(ctx.root, []*node{ctx.node})}
+}
+
+type functionExpr struct {
+	name string
+	args []expr
+}
+
+func (e *functionExpr) check() error {
+	expected := map[string][]int{
+		"last":     {0},
+		"position": {0},
+		"label":    {0},
+		"count":    {1},
+		"not":      {1},
+		"regexp":   {1, 2},
+		"glob":     {1},
+	}
+
+	for _, count := range expected[e.name] {
+		if len(e.args) == count {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("invalid number of arguments of function '%s'", e.name)
+}
+
+func (e *functionExpr) eval(ctx *evalContext) value {
+	switch e.name {
+	case "last":
+		return value{kind: kindNumber, num: ctx.size}
+	case "position":
+		return value{kind: kindNumber, num: ctx.position}
+	case "label":
+		return value{kind: kindString, str: ctx.node.label}
+	case "count":
+		return value{kind: kindNumber, num: len(e.args[0].eval(ctx).nodes)}
+	case "not":
+		return value{kind: kindBool, boolean: !e.args[0].eval(ctx).isTrue(nil)}
+	case "regexp", "glob":
+		var flags string
+
+		if len(e.args) > 1 {
+			flags = e.args[1].eval(ctx).toString()
+		}
+
+		var regexps []*regexp.Regexp
+
+		for _, pattern := range e.args[0].eval(ctx).toStrings() {
+			if e.name == "glob" {
+				pattern = globToRegexp(pattern)
+			}
+
+			if strings.Contains(flags, "i") {
+				pattern = "(?i)" + pattern
+			}
+
+			re, err := regexp.Compile("^(?:" + pattern + ")$")
+
+			if err == nil {
+				regexps = append(regexps, re)
+			}
+		}
+
+		return value{kind: kindRegexp, regexps: regexps}
+	}
+
+	return value{kind: kindBool}
+}
+
+type binaryExpr struct {
+	op    string
+	left  expr
+	right expr
+}
+
+func (e *binaryExpr) eval(ctx *evalContext) value {
+	left := e.left.eval(ctx)
+
+	switch e.op {
+	case "and":
+		return value{kind: kindBool, boolean: left.isTrue(nil) && e.right.eval(ctx).isTrue(nil)}
+	case "or":
+		return value{kind: kindBool, boolean: left.isTrue(nil) || e.right.eval(ctx).isTrue(nil)}
+	}
+
+	right := e.right.eval(ctx)
+
+	switch e.op {
+	case "+":
+		return value{kind: kindNumber, num: left.toNumber() + right.toNumber()}
+	case "-":
+		return value{kind: kindNumber, num: left.toNumber() - right.toNumber()}
+	case "=~", "!~":
+		var matched bool
+
+		for _, str := range left.toStrings() {
+			for _, re := range right.regexps {
+				if re.MatchString(str) {
+					matched = true
+				}
+			}
+		}
+
+		return value{kind: kindBool, boolean: matched == (e.op == "=~")}
+	}
+
+	// "=" and "!=" are true if there is a pair of values satisfying the comparison
+	if left.kind == kindNumber || right.kind == kindNumber {
+		equal := left.toNumber() == right.toNumber()
+
+		return value{kind: kindBool, boolean: equal == (e.op == "=")}
+	}
+
+	for _, leftStr := range left.toStrings() {
+		for _, rightStr := range right.toStrings() {
+			if (leftStr == rightStr) == (e.op == "=") {
+				return value{kind: kindBool, boolean: true}
+			}
+		}
+	}
+
+	return value{kind: kindBool}
+}
+
+// isTrue converts value to boolean. In predicates a number is compared with the position of the node.
+func (v value) isTrue(ctx *evalContext) bool {
+	switch v.kind {
+	case kindNodes:
+		return len(v.nodes) > 0
+	case kindString:
+		return v.str != ""
+	case kindNumber:
+		if ctx != nil {
+			return v.num == ctx.position
+		}
+
+		return v.num != 0
+	case kindBool:
+		return v.boolean
+	}
+
+	return len(v.regexps) > 0
+}
+
+// toStrings returns values of the nodes. Nodes without value are skipped.
+func (v value) toStrings() []string {
+	switch v.kind {
+	case kindNodes:
+		var strs []string
+
+		for _, n := range v.nodes {
+			if n.hasValue {
+				strs = append(strs, n.value)
+			}
+		}
+
+		return strs
+	case kindString:
+		return []string{v.str}
+	case kindNumber:
+		return []string{strconv.Itoa(v.num)}
+	}
+
+	return nil
+}
+
+func (v value) toString() string {
+	strs := v.toStrings()
+
+	if len(strs) == 0 {
+		return ""
+	}
+
+	return strs[0]
+}
+
+func (v value) toNumber() int {
+	if v.kind == kindNumber {
+		return v.num
+	}
+
+	num, _ := strconv.Atoi(v.toString())
+
+	return num
+}
+
+// globToRegexp converts glob pattern to the regular expression. Wildcards do not match "/".
+func globToRegexp(glob string) string {
+	var builder strings.Builder
+	inClass := false
+
+	for _, char := range glob {
+		switch {
+		case inClass:
+			if char == ']' {
+				inClass = false
+			}
+
+			builder.WriteRune(char)
+		case char == '*':
+			builder.WriteString("[^/]*")
+		case char == '?':
+			builder.WriteString("[^/]")
+		case char == '[':
+			inClass = true
+			builder.WriteRune(char)
+		default:
+			builder.WriteString(regexp.QuoteMeta(string(char)))
+		}
+	}
+
+	return builder.String()
+}
@@ -0,0 +1,106 @@
+package native
+
+import (
+	"strings"
+)
+
+// defaultIndent is an indentation of the nested nodes created via API, if there are no siblings to copy it from
+const defaultIndent = "    "
+
+// render returns content of the file. Unchanged nodes are rendered with their original text.
+func render(file *node) string {
+	var builder strings.Builder
+	newline := "\n"
+
+	if file.file != nil && file.file.newline != "" {
+		newline = file.file.newline
+	}
+
+	renderChildren(&builder, file, "", newline)
+
+	if file.format != nil {
+		builder.WriteString(file.format.tail)
+	}
+
+	return builder.String()
+}
+
+func renderChildren(builder *strings.Builder, parent *node, indent, newline string) {
+	// nodes created via API use the indentation of their siblings
+	for _, child := range parent.children {
+		if child.label != argLabel && child.format != nil {
+			indent = getIndent(child.format.leading)
+			break
+		}
+	}
+
+	for _, child := range parent.children {
+		if child.label == argLabel {
+			continue
+		}
+
+		renderNode(builder, child, indent, newline)
+	}
+}
+
+func renderNode(builder *strings.Builder, n *node, indent, newline string) {
+	// a node must start on a new line, even if the previous one is the last line of the file without line ending
+	if builder.Len() > 0 && !strings.HasSuffix(builder.String(), "\n") {
+		builder.WriteString(newline)
+	}
+
+	if n.format != nil {
+		builder.WriteString(n.format.leading)
+		indent = getIndent(n.format.leading)
+	} else {
+		builder.WriteString(indent)
+	}
+
+	isSection := n.label != directiveLabel && n.label != commentLabel
+
+	if n.format != nil && !n.modified {
+		builder.WriteString(n.format.text)
+	} else {
+		switch n.label {
+		case commentLabel:
+			builder.WriteString("# " + n.value)
+		case directiveLabel:
+			builder.WriteString(strings.Join(append([]string{n.value}, n.getArgs()...), " "))
+		default:
+			builder.WriteString("<" + strings.Join(append([]string{n.label}, n.getArgs()...), " ") + ">")
+		}
+
+		builder.WriteString(newline)
+	}
+
+	if !isSection {
+		return
+	}
+
+	renderChildren(builder, n, indent+defaultIndent, newline)
+
+	if n.format != nil && n.format.tail != "" {
+		if !strings.HasSuffix(builder.String(), "\n") {
+			builder.WriteString(newline)
+		}
+
+		builder.WriteString(n.format.tail)
+	} else {
+		builder.WriteString(indent + "</" + n.label + ">" + newline)
+	}
+}
+
+func (n *node) getArgs() []string {
+	var args []string
+
+	for _, child := range n.getChildren(argLabel) {
+		args = append(args, child.value)
+	}
+
+	return args
+}
+
+// getIndent returns indentation of the last line of the leading text
+func getIndent(leading string) string {
+	return leading[strings.LastIndex(leading, "\n")+1:]
+}
@@ -0,0 +1,479 @@
+// Package native implements Apache config tree with Augeas compatible path API in pure Go.
+// Files are parsed into the same tree structure as Augeas Httpd lens produces:
+// directives are "directive" nodes with the directive name as a value, sections are nodes labeled with the section name,
+// arguments are "arg" children and comments are "#comment" nodes.
+// Comments and whitespaces are preserved, so unchanged parts of the files are saved as is.
+package native
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Span is a position of the node in the file
+type Span struct {
+	Filename  string
+	SpanStart uint
+	SpanEnd   uint
+}
+
+// Tree is a config tree. Metadata is stored in the /augeas subtree in the same way Augeas does:
+// /augeas/load/<lens>/incl and excl specify files to load, /augeas/save specifies save mode,
+// /augeas/files/<path> contains info of the loaded files including parse errors.
+type Tree struct {
+	root *node
+}
+
+type node struct {
+	label    string
+	value    string
+	hasValue bool
+	parent   *node
+	children []*node
+	// format is nil for nodes created via API, such nodes are rendered from the tree
+	format *format
+	// modified means that the node text must be rendered from the tree, e.g. its arguments are changed
+	modified bool
+	span     *Span
+	// file is set for root nodes of the loaded files
+	file *fileInfo
+}
+
+type format struct {
+	// leading contains blank lines and indentation before the node
+	leading string
+	// text is the original text of the node. For sections it is the opening tag line.
+	text string
+	// tail contains text after the last child. For sections it includes the closing tag.
+	tail string
+}
+
+type fileInfo struct {
+	dirty bool
+	// newline is a line ending of the file, the lines rendered from the tree are terminated with it
+	newline string
+}
+
+// New creates an empty tree
+func New() *Tree {
+	t := &Tree{root: &node{}}
+	augeasNode := t.root.appendChild("augeas")
+	augeasNode.appendChild("root").setValue("/")
+	augeasNode.appendChild("save").setValue(saveOverwrite)
+	augeasNode.appendChild("load")
+	augeasNode.appendChild("files")
+	t.root.appendChild("files")
+
+	return t
+}
+
+// Close frees resources of the tree
+func (t *Tree) Close() {
+	t.root = &node{}
+}
+
+// Match returns paths of all nodes matching the path expression
+func (t *Tree) Match(path string) ([]string, error) {
+	nodes, err := t.match(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make([]string, 0, len(nodes))
+
+	for _, n := range nodes {
+		paths = append(paths, n.path())
+	}
+
+	return paths, nil
+}
+
+// Get returns value of the node. Error is returned if there are no or too many matching nodes.
+func (t *Tree) Get(path string) (string, error) {
+	n, err := t.matchOne(path)
+
+	if err != nil {
+		return "", err
+	}
+
+	return n.value, nil
+}
+
+// Label returns label of the node
+func (t *Tree) Label(path string) (string, error) {
+	n, err := t.matchOne(path)
+
+	if err != nil {
+		return "", err
+	}
+
+	return n.label, nil
+}
+
+// Set sets value of the node. The node is created if it does not exist.
+func (t *Tree) Set(path, value string) error {
+	n, err := t.expand(path)
+
+	if err != nil {
+		return err
+	}
+
+	n.setValue(value)
+
+	return nil
+}
+
+// Insert inserts a new sibling node with the label before or after the node
+func (t *Tree) Insert(path, label string, before bool) error {
+	n, err := t.matchOne(path)
+
+	if err != nil {
+		return err
+	}
+
+	if n.parent == nil {
+		return errors.New("could not insert a sibling of the root node")
+	}
+
+	index := n.index()
+
+	if !before {
+		index++
+	}
+
+	n.parent.insertChild(index, &node{label: label})
+
+	return nil
+}
+
+// Remove removes all matching nodes with their descendants and returns the number of the removed nodes
+func (t *Tree) Remove(path string) int {
+	nodes, err := t.match(path)
+
+	if err != nil {
+		return 0
+	}
+
+	var count int
+
+	for _, n := range nodes {
+		// the node could be already removed with its ancestor
+		if !n.isAttached(t.root) || n.parent == nil {
+			continue
+		}
+
+		count += n.count()
+		n.detach()
+	}
+
+	return count
+}
+
+// Move moves the node to dst. If dst exists, it is replaced, otherwise it is created.
+func (t *Tree) Move(src, dst string) error {
+	srcNode, err := t.matchOne(src)
+
+	if err != nil {
+		return err
+	}
+
+	if srcNode.parent == nil {
+		return errors.New("could not move the root node")
+	}
+
+	dstNode, err := t.expand(dst)
+
+	if err != nil {
+		return err
+	}
+
+	if dstNode == srcNode {
+		return nil
+	}
+
+	for n := dstNode; n != nil; n = n.parent {
+		if n == srcNode {
+			return fmt.Errorf("could not move '%s' to its descendant '%s'", src, dst)
+		}
+	}
+
+	srcNode.detach()
+	parent := dstNode.parent
+	index := dstNode.index()
+	dstNode.detach()
+	srcNode.label = dstNode.label
+	parent.insertChild(index, srcNode)
+	// the original text has indentation of the old position, so the moved nodes are rendered from scratch
+	srcNode.resetFormat()
+	// the moved node could be a file root itself
+	srcNode.touch()
+
+	return nil
+}
+
+// Span returns position of the node in the file
+func (t *Tree) Span(path string) (Span, error) {
+	n, err := t.matchOne(path)
+
+	if err != nil {
+		return Span{}, err
+	}
+
+	if n.span == nil {
+		return Span{}, fmt.Errorf("no span info for '%s'", path)
+	}
+
+	return *n.span, nil
+}
+
+func (t *Tree) match(path string) ([]*node, error) {
+	expr, err := parsePath(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return expr.eval(t.root, []*node{t.root}), nil
+}
+
+func (t *Tree) matchOne(path string) (*node, error) {
+	nodes, err := t.match(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("no matching node for '%s'", path)
+	}
+
+	if len(nodes) > 1 {
+		return nil, fmt.Errorf("too many matches for '%s'", path)
+	}
+
+	return nodes[0], nil
+}
+
+// expand returns the single node matching the path creating missing nodes
+func (t *Tree) expand(path string) (*node, error) {
+	expr, err := parsePath(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !expr.absolute {
+		return nil, fmt.Errorf("path '%s' is not absolute", path)
+	}
+
+	current := []*node{t.root}
+
+	for i, step := range expr.steps {
+		matches := step.eval(t.root, current)
+
+		if len(matches) > 0 {
+			current = matches
+			continue
+		}
+
+		// intermediate steps could match several nodes, e.g. with "//", but the parent of the new node must be unique
+		if len(current) > 1 {
+			return nil, fmt.Errorf("too many matches for '%s'", path)
+		}
+
+		parent := current[0]
+
+		for _, step := range expr.steps[i:] {
+			if !step.isCreatable() {
+				return nil, fmt.Errorf("could not create node for '%s'", path)
+			}
+
+			parent = parent.appendChild(step.name)
+		}
+
+		return parent, nil
+	}
+
+	if len(current) > 1 {
+		return nil, fmt.Errorf("too many matches for '%s'", path)
+	}
+
+	return current[0], nil
+}
+
+func (n *node) appendChild(label string) *node {
+	child := &node{label: label}
+	n.insertChild(len(n.children), child)
+
+	return child
+}
+
+func (n *node) insertChild(index int, child *node) {
+	child.parent = n
+	n.children = append(n.children, nil)
+	copy(n.children[index+1:], n.children[index:])
+	n.children[index] = child
+	n.changed(child.label)
+}
+
+func (n *node) detach() {
+	parent := n.parent
+	index := n.index()
+	parent.children = append(parent.children[:index], parent.children[index+1:]...)
+	n.parent = nil
+	parent.changed(n.label)
+}
+
+func (n *node) setValue(value string) {
+	n.value = value
+	n.hasValue = true
+
+	if n.label == argLabel && n.parent != nil {
+		n.parent.changed(argLabel)
+	} else {
+		n.modified = true
+		n.touch()
+	}
+}
+
+// changed is called when a child with the label is added or removed
+func (n *node) changed(childLabel string) {
+	// arguments are rendered within the parent text
+	if childLabel == argLabel {
+		n.modified = true
+	}
+
+	n.touch()
+}
+
+// touch marks the file of the node as changed
+func (n *node) touch() {
+	for current := n; current != nil; current = current.parent {
+		if current.file != nil {
+			current.file.dirty = true
+			return
+		}
+	}
+}
+
+// resetFormat drops the original text of the node and its descendants
+func (n *node) resetFormat() {
+	if n.file != nil {
+		return
+	}
+
+	n.format = nil
+
+	for _, child := range n.children {
+		child.resetFormat()
+	}
+}
+
+func (n *node) index() int {
+	for i, child := range n.parent.children {
+		if child == n {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func (n *node) isAttached(root *node) bool {
+	current := n
+
+	for current.parent != nil {
+		current = current.parent
+	}
+
+	return current == root
+}
+
+func (n *node) count() int {
+	count := 1
+
+	for _, child := range n.children {
+		count += child.count()
+	}
+
+	return count
+}
+
+func (n *node) path() string {
+	if n.parent == nil {
+		return "/"
+	}
+
+	var parts []string
+
+	for current := n; current.parent != nil; current = current.parent {
+		part := escapeLabel(current.label)
+		var position, total int
+
+		for _, sibling := range current.parent.children {
+			if sibling.label == current.label {
+				total++
+
+				if sibling == current {
+					position = total
+				}
+			}
+		}
+
+		if total > 1 {
+			part = fmt.Sprintf("%s[%d]", part, position)
+		}
+
+		parts = append([]string{part}, parts...)
+	}
+
+	return "/" + strings.Join(parts, "/")
+}
+
+func (n *node) getChild(label string) *node {
+	for _, child := range n.children {
+		if child.label == label {
+			return child
+		}
+	}
+
+	return nil
+}
+
+func (n *node) getChildren(label string) []*node {
+	var children []*node
+
+	for _, child := range n.children {
+		if child.label == label {
+			children = append(children, child)
+		}
+	}
+
+	return children
+}
+
+// ensureChild returns the first child with the label creating it if it does not exist
+func (n *node) ensureChild(label string) *node {
+	if child := n.getChild(label); child != nil {
+		return child
+	}
+
+	return n.appendChild(label)
+}
+
+// escapeLabel escapes characters that have special meaning in path expressions
+func escapeLabel(label string) string {
+	var builder strings.Builder
+
+	for _, char := range label {
+		if strings.ContainsRune(labelSpecialChars, char) {
+			builder.WriteRune('\\')
+		}
+
+		builder.WriteRune(char)
+	}
+
+	return builder.String()
+}
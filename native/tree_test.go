@@ -0,0 +1,270 @@
+package native
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testConfig = `# test config
+Listen 80
+
+<VirtualHost *:80>
+	ServerName example.com
+	ServerAlias www.example.com \
+		www2.example.com
+	# document root
+	DocumentRoot "/var/www/html"
+
+	<Directory /var/www/html>
+		Require all granted
+	</Directory>
+</VirtualHost>
+`
+
+func TestParseAndRender(t *testing.T) {
+	root, err := parseConfig("/tmp/test.conf", testConfig)
+	assert.Nilf(t, err, "could not parse config: %v", err)
+	assert.Equal(t, testConfig, render(root))
+
+	vhost := root.getChild("VirtualHost")
+	assert.NotNil(t, vhost)
+	assert.Equal(t, []string{"*:80"}, vhost.getArgs())
+
+	directives := vhost.getChildren(directiveLabel)
+	assert.Equal(t, 3, len(directives))
+	assert.Equal(t, "ServerAlias", directives[1].value)
+	assert.Equal(t, []string{"www.example.com", "www2.example.com"}, directives[1].getArgs())
+	assert.Equal(t, []string{"\"/var/www/html\""}, directives[2].getArgs())
+	assert.Equal(t, "document root", vhost.getChild(commentLabel).value)
+
+	tokens, err := tokenize(`Header set X-Test it's "say \"hi\"" 'a b'`)
+	assert.Nilf(t, err, "could not tokenize line: %v", err)
+	assert.Equal(t, []string{"Header", "set", "X-Test", "it's", `"say \"hi\""`, "'a b'"}, tokens)
+}
+
+func TestParseInvalidConfig(t *testing.T) {
+	_, err := parseConfig("/tmp/test.conf", "<VirtualHost *:80>\n\tServerName example.com\n")
+	assert.NotNil(t, err)
+
+	_, err = parseConfig("/tmp/test.conf", "Listen 80\n</VirtualHost>\n")
+	parseErr, ok := err.(*ParseError)
+	assert.True(t, ok)
+	assert.Equal(t, 2, parseErr.Line)
+}
+
+func TestTreeModification(t *testing.T) {
+	tree, file := getTestTree(t)
+	defer tree.Close()
+	vhostPath := "/files" + file + "/VirtualHost"
+
+	matches, err := tree.Match("/files//" + filepath.Base(file) + "/VirtualHost/*[self::directive=~regexp('servername', 'i')]")
+	assert.Nilf(t, err, "could not match nodes: %v", err)
+	assert.Equal(t, []string{vhostPath + "/directive[1]"}, matches)
+
+	err = tree.Set(vhostPath+"/directive[last() + 1]", "Redirect")
+	assert.Nilf(t, err, "could not add directive: %v", err)
+	err = tree.Set(vhostPath+"/directive[last()]/arg[1]", "/old")
+	assert.Nilf(t, err, "could not add directive argument: %v", err)
+	err = tree.Set(vhostPath+"/directive[last()]/arg[2]", "/new")
+	assert.Nilf(t, err, "could not add directive argument: %v", err)
+
+	err = tree.Insert(vhostPath+"/directive[1]", directiveLabel, true)
+	assert.Nilf(t, err, "could not insert directive: %v", err)
+	err = tree.Set(vhostPath+"/directive[1]", "ServerAdmin")
+	assert.Nilf(t, err, "could not set directive: %v", err)
+	err = tree.Set(vhostPath+"/directive[1]/arg", "admin@example.com")
+	assert.Nilf(t, err, "could not set directive argument: %v", err)
+
+	assert.Equal(t, 3, tree.Remove(vhostPath+"/Directory/directive"))
+	assert.Equal(t, 0, tree.Remove(vhostPath+"/directive[self::directive='Unknown']"))
+
+	value, err := tree.Get(vhostPath + "/directive[2]")
+	assert.Nilf(t, err, "could not get directive: %v", err)
+	assert.Equal(t, "ServerName", value)
+
+	_, err = tree.Get(vhostPath + "/directive")
+	assert.NotNil(t, err, "too many matches must fail")
+
+	err = tree.Save()
+	assert.Nilf(t, err, "could not save tree: %v", err)
+
+	saved, err := tree.Match("/augeas/events/saved")
+	assert.Nilf(t, err, "could not match saved files: %v", err)
+	assert.Equal(t, 1, len(saved))
+
+	content, err := ioutil.ReadFile(file)
+	assert.Nilf(t, err, "could not read file: %v", err)
+	expected := `# test config
+Listen 80
+
+<VirtualHost *:80>
+	ServerAdmin admin@example.com
+	ServerName example.com
+	ServerAlias www.example.com \
+		www2.example.com
+	# document root
+	DocumentRoot "/var/www/html"
+
+	<Directory /var/www/html>
+	</Directory>
+	Redirect /old /new
+</VirtualHost>
+`
+	assert.Equal(t, expected, string(content))
+}
+
+func TestTreeKeepsCRLF(t *testing.T) {
+	tree, file := getTestTreeWithConfig(t, strings.ReplaceAll(testConfig, "\n", "\r\n"))
+	defer tree.Close()
+	vhostPath := "/files" + file + "/VirtualHost"
+
+	err := tree.Set(vhostPath+"/directive[1]/arg", "b.com")
+	assert.Nilf(t, err, "could not set directive argument: %v", err)
+	err = tree.Set(vhostPath+"/directive[last() + 1]", "Redirect")
+	assert.Nilf(t, err, "could not add directive: %v", err)
+	err = tree.Set(vhostPath+"/directive[last()]/arg", "/new")
+	assert.Nilf(t, err, "could not add directive argument: %v", err)
+	err = tree.Set(vhostPath+"/Location/arg", "/status")
+	assert.Nilf(t, err, "could not add section: %v", err)
+
+	err = tree.Save()
+	assert.Nilf(t, err, "could not save tree: %v", err)
+
+	content, err := ioutil.ReadFile(file)
+	assert.Nilf(t, err, "could not read file: %v", err)
+	expected := `# test config
+Listen 80
+
+<VirtualHost *:80>
+	ServerName b.com
+	ServerAlias www.example.com \
+		www2.example.com
+	# document root
+	DocumentRoot "/var/www/html"
+
+	<Directory /var/www/html>
+		Require all granted
+	</Directory>
+	Redirect /new
+	<Location /status>
+	</Location>
+</VirtualHost>
+`
+	assert.Equal(t, strings.ReplaceAll(expected, "\n", "\r\n"), string(content))
+}
+
+func TestTreeLoad(t *testing.T) {
+	tree, file := getTestTree(t)
+	defer tree.Close()
+	vhostPath := "/files" + file + "/VirtualHost"
+
+	span, err := tree.Span(vhostPath)
+	assert.Nilf(t, err, "could not get span: %v", err)
+	assert.Equal(t, file, span.Filename)
+	assert.Equal(t, uint(strings.Index(testConfig, "<VirtualHost")), span.SpanStart)
+	assert.Equal(t, uint(len(testConfig)), span.SpanEnd)
+
+	// unsaved changes are dropped on load
+	err = tree.Set(vhostPath+"/directive[1]/arg", "example2.com")
+	assert.Nilf(t, err, "could not set directive argument: %v", err)
+	err = tree.Load()
+	assert.Nilf(t, err, "could not load tree: %v", err)
+	value, err := tree.Get(vhostPath + "/directive[1]/arg")
+	assert.Nilf(t, err, "could not get directive argument: %v", err)
+	assert.Equal(t, "example.com", value)
+
+	// parse errors are stored in the metadata
+	err = ioutil.WriteFile(file, []byte("<VirtualHost *:80>\n"), 0644)
+	assert.Nilf(t, err, "could not write file: %v", err)
+	err = tree.LoadFile(file)
+	assert.NotNil(t, err)
+	errorType, err := tree.Get("/augeas/files" + file + "/error")
+	assert.Nilf(t, err, "could not get file error: %v", err)
+	assert.Equal(t, "parse_failed", errorType)
+	matches, _ := tree.Match("/files" + file)
+	assert.Empty(t, matches)
+}
+
+func TestSaveModes(t *testing.T) {
+	tree, file := getTestTree(t)
+	defer tree.Close()
+	vhostPath := "/files" + file + "/VirtualHost"
+
+	err := tree.Set("/augeas/save", "newfile")
+	assert.Nilf(t, err, "could not set save mode: %v", err)
+	err = tree.Set(vhostPath+"/directive[1]/arg", "example2.com")
+	assert.Nilf(t, err, "could not set directive argument: %v", err)
+	err = tree.Save()
+	assert.Nilf(t, err, "could not save tree: %v", err)
+
+	content, err := ioutil.ReadFile(file)
+	assert.Nilf(t, err, "could not read file: %v", err)
+	assert.Equal(t, testConfig, string(content))
+
+	content, err = ioutil.ReadFile(file + ".augnew")
+	assert.Nilf(t, err, "could not read file: %v", err)
+	assert.Contains(t, string(content), "\tServerName example2.com\n")
+}
+
+func TestSaveKeepsSymlinkAndMode(t *testing.T) {
+	tree, file := getTestTree(t)
+	defer tree.Close()
+	err := os.Chmod(file, 0600)
+	assert.Nilf(t, err, "could not change file mode: %v", err)
+
+	// the file is loaded via the symlink, e.g. sites-enabled/test.conf -> ../test.conf
+	linkDir := filepath.Join(filepath.Dir(file), "enabled")
+	err = os.Mkdir(linkDir, 0755)
+	assert.Nilf(t, err, "could not create directory: %v", err)
+	link := filepath.Join(linkDir, "test.conf")
+	err = os.Symlink(file, link)
+	assert.Nilf(t, err, "could not create symlink: %v", err)
+	err = tree.Set("/augeas/load/Httpd/incl[last()+1]", link)
+	assert.Nilf(t, err, "could not set incl: %v", err)
+	err = tree.LoadFile(link)
+	assert.Nilf(t, err, "could not load file: %v", err)
+
+	err = tree.Set("/files"+link+"/VirtualHost/directive[1]/arg", "example2.com")
+	assert.Nilf(t, err, "could not set directive argument: %v", err)
+	err = tree.Save()
+	assert.Nilf(t, err, "could not save tree: %v", err)
+
+	info, err := os.Lstat(link)
+	assert.Nilf(t, err, "could not stat symlink: %v", err)
+	assert.NotEqual(t, os.FileMode(0), info.Mode()&os.ModeSymlink, "symlink is replaced with a file")
+	info, err = os.Stat(file)
+	assert.Nilf(t, err, "could not stat file: %v", err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	content, err := ioutil.ReadFile(file)
+	assert.Nilf(t, err, "could not read file: %v", err)
+	assert.Contains(t, string(content), "\tServerName example2.com\n")
+}
+
+func getTestTree(t *testing.T) (*Tree, string) {
+	return getTestTreeWithConfig(t, testConfig)
+}
+
+func getTestTreeWithConfig(t *testing.T, config string) (*Tree, string) {
+	dir, err := ioutil.TempDir("/tmp", "a2conf-native")
+	assert.Nilf(t, err, "could not create temp directory: %v", err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	file := filepath.Join(dir, "test.conf")
+	err = ioutil.WriteFile(file, []byte(config), 0644)
+	assert.Nilf(t, err, "could not write file: %v", err)
+
+	tree := New()
+	err = tree.Set("/augeas/load/Httpd/lens", "Httpd.lns")
+	assert.Nilf(t, err, "could not set lens: %v", err)
+	err = tree.Set("/augeas/load/Httpd/incl", filepath.Join(dir, "*.conf"))
+	assert.Nilf(t, err, "could not set incl: %v", err)
+	err = tree.Load()
+	assert.Nilf(t, err, "could not load tree: %v", err)
+
+	return tree, file
+}
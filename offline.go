@@ -0,0 +1,89 @@
+package a2conf
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrOfflineMode is returned by operations requiring apachectl or other apache utilities in offline mode
+var ErrOfflineMode = errors.New("the operation is not available in offline mode")
+
+// staticModules are compiled into apache binaries of the major distributions, so they are not loaded via LoadModule
+var staticModules = []string{"core", "so", "watchdog", "http", "log_config", "logio", "version", "unixd"}
+
+// isOffline checks if the parser works without apachectl
+func (p *Parser) isOffline() bool {
+	return p.ApacheCtl == nil
+}
+
+// getStaticModules returns names of the modules which are not loaded via LoadModule.
+// The modules compiled into the binary and its MPM are used if apachectl is available, staticModules otherwise.
+func (p *Parser) getStaticModules() []string {
+	if p.compileSettings == nil {
+		return staticModules
+	}
+
+	modules := staticModules
+
+	if len(p.compileSettings.Modules) > 0 {
+		modules = p.compileSettings.Modules
+	}
+
+	modules = append([]string{}, modules...)
+
+	// the MPM module is compiled in or loaded by the distribution config, IfModule sections check it by the mpm_ name
+	if p.compileSettings.MPM != "" {
+		modules = append(modules, "mpm_"+strings.ToLower(p.compileSettings.MPM))
+	}
+
+	return modules
+}
+
+// parseModulesOffline returns names of the static modules and the modules of LoadModule directives of the config
+func (p *Parser) parseModulesOffline() ([]string, error) {
+	modules := append([]string{}, p.getStaticModules()...)
+	loadModules, err := p.getDirectivesArgs("LoadModule")
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, args := range loadModules {
+		modules = append(modules, strings.TrimSuffix(args[0], "_module"))
+	}
+
+	return modules, nil
+}
+
+// getDirectivesArgs returns arguments of the active directives of the config in the order of their loading
+func (p *Parser) getDirectivesArgs(name string) ([][]string, error) {
+	matches, err := p.FindDirective(name, "", "", true)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not find '%s' directives: %v", name, err)
+	}
+
+	var directivesArgs [][]string
+	var lastDirective string
+
+	for _, match := range matches {
+		directive := match[:strings.LastIndex(match, "/arg")]
+		value, err := p.GetArg(match)
+
+		// apache keeps unknown variables as is
+		if err != nil {
+			rawValue, _ := p.Backend.Get(match)
+			value = UnquoteArg(rawValue)
+		}
+
+		if directive != lastDirective {
+			directivesArgs = append(directivesArgs, nil)
+			lastDirective = directive
+		}
+
+		directivesArgs[len(directivesArgs)-1] = append(directivesArgs[len(directivesArgs)-1], value)
+	}
+
+	return directivesArgs, nil
+}
@@ -17,6 +17,25 @@ const (
 	ApacheEnsite = "apache_ensite"
 	// ApacheDissite is a command for a2dissite command or a pth to a2dissite bin
 	ApacheDissite = "apache_dissite"
+	// ApacheEnmod is a command for a2enmod command or a path to a2enmod bin
+	ApacheEnmod = "apache_enmod"
+	// ApacheDismod is a command for a2dismod command or a path to a2dismod bin
+	ApacheDismod = "apache_dismod"
+	// BackupDir is a directory where backups of the changed config files are stored. Original paths are mirrored inside it.
+	BackupDir = "backup_dir"
+	// ParserBackend is a config parser backend: "augeas" or "native". By default augeas is used unless the package is built with "noaugeas" tag.
+	ParserBackend = "parser_backend"
+	// Lens is a source of the httpd lens for the augeas parser backend: "bundled" lens shipped with the package, "system" lens installed with libaugeas
+	// or a path to a directory with httpd.aug. By default the bundled lens is used.
+	Lens = "lens"
+	// Offline enables parsing of the config without apachectl: modules, defines and includes are taken from the config files.
+	// Operations requiring apache utilities, e.g. the configuration test or the restart, are not available.
+	Offline = "offline"
+	// Version is apache version. It is required in offline mode, otherwise it is detected via apachectl.
+	Version = "version"
+	// SystemRoot is a root directory of the inspected system. Environment files of the distribution outside of the server root,
+	// e.g. /etc/sysconfig/httpd, are read relative to it. By default they are read from the host in online mode only.
+	SystemRoot = "system_root"
 )
 
 // GetOption returns option value
@@ -50,6 +69,14 @@ func GetDefaults() map[string]string {
 	defaults[SslVhostlExt] = "-ssl.conf"
 	defaults[ApacheEnsite] = "a2ensite"
 	defaults[ApacheDissite] = "a2dissite"
+	defaults[ApacheEnmod] = "a2enmod"
+	defaults[ApacheDismod] = "a2dismod"
+	defaults[BackupDir] = "/var/lib/a2conf/backup"
+	defaults[ParserBackend] = ""
+	defaults[Lens] = "bundled"
+	defaults[Offline] = "false"
+	defaults[Version] = ""
+	defaults[SystemRoot] = ""
 
 	return defaults
 }
@@ -0,0 +1,72 @@
+package a2conf
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseError is an error of the config file parsing
+type ParseError struct {
+	File string
+	// Line and Char are a position of the error in the file. They are 0 if the position is unknown, e.g. the file could not be read.
+	Line int
+	Char int
+	// Lens is a position within the lens where parsing failed. It is empty for the native backend.
+	Lens    string
+	Message string
+}
+
+func (e *ParseError) Error() string {
+	if e.Line == 0 {
+		return fmt.Sprintf("%s: %s", e.File, e.Message)
+	}
+
+	return fmt.Sprintf("%s:%d:%d: %s", e.File, e.Line, e.Char, e.Message)
+}
+
+// GetParseErrors returns errors of the last loading. Files with errors are not loaded into the tree.
+func (p *Parser) GetParseErrors() []*ParseError {
+	return p.parseErrors
+}
+
+func (p *Parser) getParseErrors() ([]*ParseError, error) {
+	errorPaths, err := p.Backend.Match("/augeas/files//error")
+
+	if err != nil {
+		return nil, fmt.Errorf("could not get parse errors: %v", err)
+	}
+
+	var parseErrors []*ParseError
+
+	for _, errorPath := range errorPaths {
+		errorType, _ := p.Backend.Get(errorPath)
+
+		// errors of the saving are reported by GetAugeasError
+		if errorType == "put_failed" {
+			continue
+		}
+
+		parseError := &ParseError{
+			File: unescape(strings.TrimSuffix(strings.TrimPrefix(errorPath, "/augeas/files"), "/error")),
+			Lens: p.getErrorDetail(errorPath, "lens"),
+		}
+		parseError.Line, _ = strconv.Atoi(p.getErrorDetail(errorPath, "line"))
+		parseError.Char, _ = strconv.Atoi(p.getErrorDetail(errorPath, "char"))
+		parseError.Message = p.getErrorDetail(errorPath, "message")
+
+		if parseError.Message == "" {
+			parseError.Message = errorType
+		}
+
+		parseErrors = append(parseErrors, parseError)
+	}
+
+	return parseErrors, nil
+}
+
+func (p *Parser) getErrorDetail(errorPath, name string) string {
+	value, _ := p.Backend.Get(errorPath + "/" + name)
+
+	return value
+}
@@ -0,0 +1,53 @@
+package a2conf
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetParseErrors(t *testing.T) {
+	runWithBackends(t, func(t *testing.T, backendName string) {
+		dir := writeTestConfig(t, map[string]string{
+			"valid.conf":   testTreeConfig,
+			"invalid.conf": "<VirtualHost *:80>\n\tServerName example.com\n</Directory>\n",
+		})
+		defer os.RemoveAll(dir)
+
+		invalidPath := filepath.Join(dir, "invalid.conf")
+		backend, err := newBackend(backendName, "")
+		assert.Nilf(t, err, "could not create backend: %v", err)
+		defer backend.Close()
+
+		parser := &Parser{Backend: backend, Tree: newConfigTree(backend)}
+		backend.Set("/augeas/load/Httpd/lens", "Httpd.lns")
+		backend.Set("/augeas/load/Httpd/incl", filepath.Join(dir, "*.conf"))
+		err = parser.Load()
+		assert.Nilf(t, err, "could not load config: %v", err)
+
+		parseErrors := parser.GetParseErrors()
+		assert.Equal(t, 1, len(parseErrors))
+		assert.Equal(t, invalidPath, parseErrors[0].File)
+		assert.NotEmpty(t, parseErrors[0].Message)
+
+		// the position and the message of the error are backend specific
+		if backendName == BackendNative {
+			assert.Equal(t, 3, parseErrors[0].Line)
+			assert.Equal(t, 1, parseErrors[0].Char)
+			assert.Contains(t, parseErrors[0].Message, "</Directory>")
+			assert.Equal(t, invalidPath+":3:1: "+parseErrors[0].Message, parseErrors[0].Error())
+		}
+
+		fileNode, _ := parser.Tree.GetFileNode(invalidPath)
+		assert.Nil(t, fileNode, "file with errors must not be loaded")
+
+		err = ioutil.WriteFile(invalidPath, []byte("Listen 80\n"), 0644)
+		assert.Nilf(t, err, "could not write config file: %v", err)
+		err = parser.Load()
+		assert.Nilf(t, err, "could not load config: %v", err)
+		assert.Empty(t, parser.GetParseErrors())
+	})
+}
@@ -2,6 +2,7 @@ package a2conf
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
@@ -10,13 +11,15 @@ import (
 
 	"github.com/huandu/xstrings"
 	"github.com/r2dtools/a2conf/apache"
+	opts "github.com/r2dtools/a2conf/options"
 	"github.com/r2dtools/a2conf/utils"
 	"github.com/unknwon/com"
-	"honnef.co/go/augeas"
 )
 
 const (
 	argVarRegex = `\$\{[^ \}]*}`
+	// dirtyMarkerLabel is a label of the temporary node used to mark augeas file tree as changed
+	dirtyMarkerLabel = "a2conf_dirty_marker"
 )
 
 var fnMatchChars = []string{"*", "?", "\\", "[", "]"}
@@ -24,7 +27,14 @@ var serverRootPaths = []string{"/etc/httpd", "/etc/apache2"}
 
 // Parser ia a wrapper under the augeas to work with httpd config
 type Parser struct {
-	Augeas          augeas.Augeas
+	// Augeas is libaugeas handle. It is set for the augeas backend only.
+	//
+	// Deprecated: use Backend or Tree, they work with both backends.
+	Augeas augeasHandle
+	// Backend is a config tree backend: libaugeas or the native parser
+	Backend Backend
+	// Tree is a backend neutral view of the config files
+	Tree            ConfigTree
 	ApacheCtl       *apache.Ctl
 	ServerRoot      string
 	VHostRoot       string
@@ -34,18 +44,45 @@ type Parser struct {
 	beforeDomReload func(unsavedFiles []string)
 	Paths           map[string][]string
 	existingPaths   map[string][]string
+	committedPaths  map[string][]string
+	newFiles        map[string]bool
 	variables       map[string]string
 	Modules         map[string]bool
+	parseErrors     []*ParseError
+	// envVariables are environment variables of apache. Variables in the config are resolved with them if there is no such define.
+	envVariables map[string]string
+	// cmdDefines are defines of apache command line options set by the distribution
+	cmdDefines map[string]string
+	// systemRoot is a root directory of the environment files outside of the server root. They are not read if it is empty.
+	systemRoot string
+	// compileSettings are build settings of apache binary, they are not available in offline mode
+	compileSettings *apache.CompileSettings
+	// definePositions are defines active at the directives and sections of the config, since Define and UnDefine are evaluated in order
+	definePositions map[string]map[string]string
+	// initialDefines are defines active before the config is read, e.g. the defines of the command line
+	initialDefines map[string]string
+	// isDefinesDirty is set when the tree is reloaded, the define positions are updated on the next lookup
+	isDefinesDirty bool
 }
 
-type directiveFilter struct {
-	Name  string
-	Value []string
+// GetParser creates parser instance. If apachectl is nil, the parser works offline: defines, modules and includes are taken from the config.
+func GetParser(apachectl *apache.Ctl, version, serverRoot, vhostRoot string) (*Parser, error) {
+	return GetParserWithOptions(apachectl, version, map[string]string{opts.ServerRoot: serverRoot, opts.VhostRoot: vhostRoot})
 }
 
-// GetParser creates parser instance
-func GetParser(apachectl *apache.Ctl, version, serverRoot, vhostRoot string) (*Parser, error) {
-	serverRoot, err := getServerRootPath(serverRoot)
+// GetParserWithOptions creates parser instance with the options of ApacheConfigurator: server root, virtual host root, parser backend and lens.
+// If apachectl is nil, the parser works offline: defines, modules and includes are taken from the config.
+func GetParserWithOptions(apachectl *apache.Ctl, version string, options map[string]string) (*Parser, error) {
+	serverRoot := opts.GetOption(opts.ServerRoot, options)
+	vhostRoot := opts.GetOption(opts.VhostRoot, options)
+	systemRoot := opts.GetOption(opts.SystemRoot, options)
+
+	// the host files describe the running apache, but not the copied configs inspected offline
+	if systemRoot == "" && apachectl != nil {
+		systemRoot = "/"
+	}
+	compileSettings := getCompileSettings(apachectl)
+	serverRoot, err := getServerRootPath(serverRoot, compileSettings)
 
 	if err != nil {
 		return nil, err
@@ -62,18 +99,22 @@ func GetParser(apachectl *apache.Ctl, version, serverRoot, vhostRoot string) (*P
 		return nil, err
 	}
 
-	aug, err := augeas.New("/", "", augeas.NoLoad|augeas.NoModlAutoload|augeas.EnableSpan)
+	aug, err := newBackend(opts.GetOption(opts.ParserBackend, options), opts.GetOption(opts.Lens, options))
 
 	if err != nil {
 		return nil, err
 	}
 
 	parser := &Parser{
-		Augeas:     aug,
-		ApacheCtl:  apachectl,
-		ServerRoot: serverRoot,
-		VHostRoot:  vhostRoot,
-		version:    version,
+		Augeas:          getAugeasHandle(aug),
+		Backend:         aug,
+		Tree:            newConfigTree(aug),
+		ApacheCtl:       apachectl,
+		ServerRoot:      serverRoot,
+		VHostRoot:       vhostRoot,
+		version:         version,
+		compileSettings: compileSettings,
+		systemRoot:      systemRoot,
 	}
 
 	if err = parser.setLocations(); err != nil {
@@ -87,6 +128,7 @@ func GetParser(apachectl *apache.Ctl, version, serverRoot, vhostRoot string) (*P
 	}
 
 	if err = parser.UpdateRuntimeVariables(); err != nil {
+		parser.Close()
 		return nil, err
 	}
 
@@ -96,16 +138,9 @@ func GetParser(apachectl *apache.Ctl, version, serverRoot, vhostRoot string) (*P
 		return nil, err
 	}
 
-	if parser.existingPaths == nil {
-		parser.existingPaths = make(map[string][]string)
-	}
-
 	// list of the active include paths, before modifications
-	for k, v := range parser.Paths {
-		dst := make([]string, len(v))
-		copy(dst, v)
-		parser.existingPaths[k] = dst
-	}
+	parser.existingPaths = copyPaths(parser.Paths)
+	parser.committedPaths = copyPaths(parser.Paths)
 
 	return parser, nil
 }
@@ -113,7 +148,7 @@ func GetParser(apachectl *apache.Ctl, version, serverRoot, vhostRoot string) (*P
 // Close closes the Parser instance and frees any storage associated with it.
 func (p *Parser) Close() {
 	if p != nil {
-		p.Augeas.Close()
+		p.Backend.Close()
 	}
 }
 
@@ -125,10 +160,28 @@ func (p *Parser) SetBeforeDomReloadCallback(callback func(unsavedFiles []string)
 	p.beforeDomReload = callback
 }
 
-// setConfigRoot detects apache root config file
+// GetCompileSettings returns build settings of apache binary. Nil is returned in offline mode or if they could not be detected.
+func (p *Parser) GetCompileSettings() *apache.CompileSettings {
+	return p.compileSettings
+}
+
+// setConfigRoot detects apache root config file. The config file of the build settings is preferred, the default ones are used as a fallback.
 func (p *Parser) setConfigRoot() error {
 	configs := []string{"apache2.conf", "httpd.conf", "conf/httpd.conf"}
 
+	if p.compileSettings != nil && p.compileSettings.ServerConfigFile != "" {
+		configRootPath := p.compileSettings.ServerConfigFile
+
+		if !filepath.IsAbs(configRootPath) {
+			configRootPath = filepath.Join(p.ServerRoot, configRootPath)
+		}
+
+		if com.IsFile(configRootPath) {
+			p.ConfigRoot = configRootPath
+			return nil
+		}
+	}
+
 	for _, config := range configs {
 		configRootPath := path.Join(p.ServerRoot, config)
 		_, err := os.Stat(configRootPath)
@@ -159,6 +212,25 @@ func (p *Parser) setLocations() error {
 	return nil
 }
 
+// Load loads changed config files into the tree and collects errors of their parsing
+func (p *Parser) Load() error {
+	if err := p.Backend.Load(); err != nil {
+		return err
+	}
+
+	parseErrors, err := p.getParseErrors()
+
+	if err != nil {
+		return err
+	}
+
+	p.parseErrors = parseErrors
+	// positions of the nodes are changed after the files are reloaded, they are updated once on the next lookup
+	p.isDefinesDirty = true
+
+	return nil
+}
+
 // ParseFile parses file with Auegause
 func (p *Parser) ParseFile(fPath string) error {
 	useNew, removeOld := p.checkPath(fPath)
@@ -177,7 +249,7 @@ func (p *Parser) ParseFile(fPath string) error {
 		return nil
 	}
 
-	includedPaths, err := p.Augeas.Match(fmt.Sprintf("/augeas/load/Httpd['%s' =~ glob(incl)]", fPath))
+	includedPaths, err := p.Backend.Match(fmt.Sprintf("/augeas/load/Httpd['%s' =~ glob(incl)]", fPath))
 
 	if err != nil {
 		return err
@@ -189,7 +261,10 @@ func (p *Parser) ParseFile(fPath string) error {
 		}
 
 		p.addTransform(fPath)
-		p.Augeas.Load()
+
+		if err = p.Load(); err != nil {
+			return fmt.Errorf("could not load '%s': %v", fPath, err)
+		}
 	}
 
 	return nil
@@ -197,7 +272,7 @@ func (p *Parser) ParseFile(fPath string) error {
 
 // GetAugeasError return Augeas errors
 func (p *Parser) GetAugeasError(errorsToExclude []string) error {
-	newErrors, err := p.Augeas.Match("/augeas//error")
+	newErrors, err := p.Backend.Match("/augeas//error")
 
 	if err != nil {
 		return fmt.Errorf("could not get augeas errors: %v", err)
@@ -222,7 +297,7 @@ func (p *Parser) GetAugeasError(errorsToExclude []string) error {
 	var detailedRootErrors []string
 
 	for _, rError := range rootErrors {
-		details, _ := p.Augeas.Get(rError + "/message")
+		details, _ := p.Backend.Get(rError + "/message")
 
 		if details == "" {
 			detailedRootErrors = append(detailedRootErrors, rError)
@@ -252,30 +327,92 @@ func (p *Parser) Save(reverter *Reverter) error {
 		}
 	}
 
-	if err = p.Augeas.Save(); err != nil {
+	if err = p.Backend.Save(); err != nil {
 		return err
 	}
 
+	// all files are written to the disk now
+	p.newFiles = nil
+
 	for _, unsavedFile := range unsavedFiles {
-		p.Augeas.Remove(fmt.Sprintf("/files/%s", unsavedFile))
+		p.Backend.Remove(fmt.Sprintf("/files/%s", unsavedFile))
 	}
 
-	if err = p.Augeas.Load(); err != nil {
+	if err = p.Load(); err != nil {
 		return err
 	}
 
 	return nil
 }
 
+// Reload drops the whole Augeas tree including unsaved changes and loads it from the disk again
+func (p *Parser) Reload() error {
+	// Augeas does not reload files whose tree exists and whose mtime is not changed,
+	// so the trees are removed to force their parsing.
+	p.Backend.Remove("/files/*")
+	p.newFiles = nil
+
+	if err := p.Load(); err != nil {
+		return fmt.Errorf("could not reload augeas tree: %v", err)
+	}
+
+	return nil
+}
+
+// invalidateFile makes the file tree to be reloaded from the disk on the next load
+func (p *Parser) invalidateFile(filePath string) {
+	p.Backend.Set(fmt.Sprintf("/augeas/files%s/mtime", escape(filePath)), "0")
+}
+
+// UpdateRuntime updates variables and modules and resets active include paths to the committed ones.
+// It should be called when the configuration on the disk is changed outside of the parser, e.g. on rollback.
+func (p *Parser) UpdateRuntime() error {
+	p.existingPaths = copyPaths(p.committedPaths)
+
+	if err := p.UpdateDefines(); err != nil {
+		return err
+	}
+
+	return p.ResetModules()
+}
+
+// commitPaths marks current active include paths as committed
+func (p *Parser) commitPaths() {
+	p.committedPaths = copyPaths(p.existingPaths)
+}
+
 // GetArg returns argument value and interprets result
 func (p *Parser) GetArg(match string) (string, error) {
-	value, err := p.Augeas.Get(match)
+	value, err := p.Backend.Get(match)
+
+	if err != nil {
+		return "", err
+	}
+
+	return p.InterpretArgAt(UnquoteArg(value), match)
+}
+
+// InterpretArg replaces variables in the unquoted argument value with the defines active after the config is read
+func (p *Parser) InterpretArg(value string) (string, error) {
+	if err := p.updateDefinePositions(); err != nil {
+		return "", err
+	}
+
+	return p.interpretArg(value, p.variables)
+}
+
+// InterpretArgAt replaces variables in the unquoted argument value with the defines active at the node path
+func (p *Parser) InterpretArgAt(value, path string) (string, error) {
+	defines, err := p.getDefinesAt(path)
 
 	if err != nil {
 		return "", err
 	}
 
-	value = strings.Trim(value, "'\"")
+	return p.interpretArg(value, defines)
+}
+
+func (p *Parser) interpretArg(value string, defines map[string]string) (string, error) {
 	re := regexp.MustCompile(argVarRegex)
 	variables := re.FindAll([]byte(value), -1)
 
@@ -283,7 +420,11 @@ func (p *Parser) GetArg(match string) (string, error) {
 		variableStr := string(variable)
 		// Since variable is satisfied regex, it has at least length 3: ${}
 		variableKey := variableStr[2 : len(variableStr)-1]
-		replaceVariable, ok := p.variables[variableKey]
+		replaceVariable, ok := defines[variableKey]
+
+		if !ok {
+			replaceVariable, ok = p.envVariables[variableKey]
+		}
 
 		if !ok {
 			return "", fmt.Errorf("could not parse variable: %s", variableStr)
@@ -295,17 +436,30 @@ func (p *Parser) GetArg(match string) (string, error) {
 	return value, nil
 }
 
-// UpdateRuntimeVariables Updates Includes, Defines and Includes from httpd config dump data
+// UpdateRuntimeVariables Updates Defines, Modules and Includes from httpd config dump data or from the config itself in offline mode
 func (p *Parser) UpdateRuntimeVariables() error {
-	if err := p.UpdateDefines(); err != nil {
-		return err
-	}
+	// defines are evaluated while the config is walked, and IfModule sections are evaluated by the way.
+	// apachectl knows modules in advance, offline the modules are taken from LoadModule directives of the active sections.
+	// Modules and defines are updated before includes, since includes could be wrapped with IfModule and IfDefine.
+	if p.isOffline() {
+		if err := p.UpdateDefines(); err != nil {
+			return err
+		}
 
-	if err := p.UpdateIncludes(); err != nil {
-		return err
+		if err := p.UpdateModules(); err != nil {
+			return err
+		}
+	} else {
+		if err := p.UpdateModules(); err != nil {
+			return err
+		}
+
+		if err := p.UpdateDefines(); err != nil {
+			return err
+		}
 	}
 
-	if err := p.UpdateModules(); err != nil {
+	if err := p.UpdateIncludes(); err != nil {
 		return err
 	}
 
@@ -314,13 +468,51 @@ func (p *Parser) UpdateRuntimeVariables() error {
 
 // UpdateDefines Updates the map of known variables in the configuration
 func (p *Parser) UpdateDefines() error {
-	variables, err := p.ApacheCtl.ParseDefines()
+	if err := p.UpdateEnvironment(); err != nil {
+		return fmt.Errorf("could not parse environment: %v", err)
+	}
+
+	initial := make(map[string]string)
+
+	for name, value := range p.cmdDefines {
+		initial[name] = value
+	}
+
+	// the positions are updated on each load of the tree once the defines are loaded
+	p.initialDefines = nil
+	variables, declared, err := p.loadDefines(initial)
 
 	if err != nil {
-		return fmt.Errorf("could not parse defines: %v", err)
+		return fmt.Errorf("could not evaluate defines: %v", err)
+	}
+
+	if !p.isOffline() {
+		ctlDefines, err := p.ApacheCtl.ParseDefines()
+
+		if err != nil {
+			return fmt.Errorf("could not parse defines: %v", err)
+		}
+
+		// apachectl dumps defines active after the config is read,
+		// the defines which are not declared in the config are passed via the command line and active from the start
+		isUpdated := false
+
+		for name, value := range ctlDefines {
+			if _, ok := initial[name]; !ok && !declared[name] {
+				initial[name] = value
+				isUpdated = true
+			}
+		}
+
+		if isUpdated {
+			if variables, _, err = p.loadDefines(initial); err != nil {
+				return fmt.Errorf("could not evaluate defines: %v", err)
+			}
+		}
 	}
 
 	p.variables = variables
+	p.initialDefines = initial
 
 	return nil
 }
@@ -328,6 +520,12 @@ func (p *Parser) UpdateDefines() error {
 // UpdateIncludes gets includes from httpd process, and add them to DOM if needed
 func (p *Parser) UpdateIncludes() error {
 	p.FindDirective("Include", "", "", true)
+
+	// included files are parsed while the config is walked
+	if p.isOffline() {
+		return nil
+	}
+
 	matches, err := p.ApacheCtl.ParseIncludes()
 
 	if err != nil {
@@ -345,7 +543,14 @@ func (p *Parser) UpdateIncludes() error {
 
 // UpdateModules gets loaded modules from httpd process, and add them to DOM
 func (p *Parser) UpdateModules() error {
-	matches, err := p.ApacheCtl.ParseModules()
+	var matches []string
+	var err error
+
+	if p.isOffline() {
+		matches, err = p.parseModulesOffline()
+	} else {
+		matches, err = p.ApacheCtl.ParseModules()
+	}
 
 	if err != nil {
 		return err
@@ -398,8 +603,14 @@ func (p *Parser) FindDirective(directive, arg, start string, exclude bool) ([]st
 		start = GetAugPath(p.ConfigRoot)
 	}
 
+	return p.findDirective(directive, arg, start, exclude, map[string]bool{start: true})
+}
+
+// findDirective finds directive starting with the start path. includes are the paths being searched, they are tracked to prevent include loops.
+func (p *Parser) findDirective(directive, arg, start string, exclude bool, includes map[string]bool) ([]string, error) {
+
 	regStr := fmt.Sprintf("(%s)|(%s)|(%s)", directive, "Include", "IncludeOptional")
-	matches, err := p.Augeas.Match(fmt.Sprintf("%s//*[self::directive=~regexp('%s', 'i')]", start, regStr))
+	matches, err := p.Backend.Match(fmt.Sprintf("%s//*[self::directive=~regexp('%s', 'i')]", start, regStr))
 
 	if err != nil {
 		return nil, err
@@ -423,7 +634,7 @@ func (p *Parser) FindDirective(directive, arg, start string, exclude bool) ([]st
 	}
 
 	for _, match := range matches {
-		dir, err := p.Augeas.Get(match)
+		dir, err := p.Backend.Get(match)
 
 		if err != nil {
 			return nil, err
@@ -444,17 +655,22 @@ func (p *Parser) FindDirective(directive, arg, start string, exclude bool) ([]st
 				return nil, err
 			}
 
-			nMatches, err := p.FindDirective(directive, arg, nStart, exclude)
+			// apache fails on the recursive include, the included files are already searched
+			if !includes[nStart] {
+				includes[nStart] = true
+				nMatches, err := p.findDirective(directive, arg, nStart, exclude, includes)
+				delete(includes, nStart)
 
-			if err != nil {
-				return nil, err
-			}
+				if err != nil {
+					return nil, err
+				}
 
-			orderedMatches = append(orderedMatches, nMatches...)
+				orderedMatches = append(orderedMatches, nMatches...)
+			}
 		}
 
 		if dir == strings.ToLower(directive) {
-			nMatches, err := p.Augeas.Match(match + argSuffix)
+			nMatches, err := p.Backend.Match(match + argSuffix)
 
 			if err != nil {
 				return nil, err
@@ -469,30 +685,18 @@ func (p *Parser) FindDirective(directive, arg, start string, exclude bool) ([]st
 }
 
 // ExcludeDirectives excludes directives that are not loaded into the configuration.
+// Conditional sections containing the directive are evaluated, directives of <If> sections are not excluded.
 func (p *Parser) ExcludeDirectives(matches []string) ([]string, error) {
 	var validMatches []string
-	filters := []directiveFilter{
-		{"ifmodule", p.getModules()},
-		{"ifdefine", p.getVariblesNames()},
-	}
 
 	for _, match := range matches {
-		isPassed := true
-
-		for _, filter := range filters {
-			fPassed, err := p.isDirectivePassedFilter(match, filter)
-
-			if err != nil {
-				return nil, fmt.Errorf("failed to check the directive '%s' passed the filter '%s'", match, filter.Name)
-			}
+		isActive, err := p.isNodeActive(match)
 
-			if !fPassed {
-				isPassed = false
-				break
-			}
+		if err != nil {
+			return nil, fmt.Errorf("could not check the directive '%s' is active: %v", match, err)
 		}
 
-		if isPassed {
+		if isActive {
 			validMatches = append(validMatches, match)
 		}
 	}
@@ -502,12 +706,12 @@ func (p *Parser) ExcludeDirectives(matches []string) ([]string, error) {
 
 // AddDirective adds directive to the end of the file given by augConfPath
 func (p *Parser) AddDirective(augConfPath string, directive string, args []string) error {
-	if err := p.Augeas.Set(augConfPath+"/directive[last() + 1]", directive); err != nil {
+	if err := p.Backend.Set(augConfPath+"/directive[last() + 1]", directive); err != nil {
 		return err
 	}
 
 	for i, arg := range args {
-		if err := p.Augeas.Set(fmt.Sprintf("%s/directive[last()]/arg[%d]", augConfPath, i+1), arg); err != nil {
+		if err := p.Backend.Set(fmt.Sprintf("%s/directive[last()]/arg[%d]", augConfPath, i+1), QuoteArg(arg)); err != nil {
 			return err
 		}
 	}
@@ -523,23 +727,23 @@ func (p *Parser) AddDirectiveToIfModSSL(augConfPath string, directive string, ar
 		return err
 	}
 
-	if err = p.Augeas.Insert(ifModPath+"arg", "directive", false); err != nil {
+	if err = p.Backend.Insert(ifModPath+"arg", "directive", false); err != nil {
 		return fmt.Errorf("could not insert directive within IfModule SSL block: %v", err)
 	}
 
 	nPath := ifModPath + "directive[1]"
 
-	if err = p.Augeas.Set(nPath, directive); err != nil {
+	if err = p.Backend.Set(nPath, directive); err != nil {
 		return fmt.Errorf("could not set directive value within IfModule SSL block: %v", err)
 	}
 
 	if len(args) == 0 {
-		if err = p.Augeas.Set(nPath+"/arg", args[0]); err != nil {
+		if err = p.Backend.Set(nPath+"/arg", QuoteArg(args[0])); err != nil {
 			return fmt.Errorf("could not set directive argument within IfModule SSL block: %v", err)
 		}
 	} else {
 		for i, arg := range args {
-			if err = p.Augeas.Set(fmt.Sprintf("%s/arg[%d]", nPath, i+1), arg); err != nil {
+			if err = p.Backend.Set(fmt.Sprintf("%s/arg[%d]", nPath, i+1), QuoteArg(arg)); err != nil {
 				return fmt.Errorf("could not set directive argument within IfModule SSL block: %v", err)
 			}
 		}
@@ -562,21 +766,26 @@ func (p *Parser) AddInclude(mainConfigPath string, inclPath string) error {
 		}
 	}
 
-	newDir := filepath.Dir(inclPath)
-	newFile := filepath.Base(inclPath)
+	p.addExistingPath(inclPath)
+
+	return nil
+}
+
+// addExistingPath marks the file as included in the apache configuration
+func (p *Parser) addExistingPath(filePath string) {
+	newDir := filepath.Dir(filePath)
+	newFile := filepath.Base(filePath)
 
 	if _, ok := p.existingPaths[newDir]; !ok {
 		p.existingPaths[newDir] = make([]string, 0)
 	}
 
 	p.existingPaths[newDir] = append(p.existingPaths[newDir], newFile)
-
-	return nil
 }
 
 // GetIfModule returns the path to <IfModule mod> and creates one if it does not exist
 func (p *Parser) GetIfModule(augConfPath string, mod string, begining bool) (string, error) {
-	ifMods, err := p.Augeas.Match(fmt.Sprintf("%s/IfModule/*[self::arg='%s']", augConfPath, mod))
+	ifMods, err := p.Backend.Match(fmt.Sprintf("%s/IfModule/*[self::arg='%s']", augConfPath, mod))
 
 	if err != nil {
 		return "", fmt.Errorf("could not get IfModule directive: %v", err)
@@ -599,7 +808,7 @@ func (p *Parser) CreateIfModule(augConfPath string, mod string, begining bool) (
 	if begining {
 		argPath = fmt.Sprintf("%s/IfModule[1]/arg", augConfPath)
 
-		if err = p.Augeas.Insert(fmt.Sprintf("%s/directive[1]", augConfPath), "IfModule", true); err != nil {
+		if err = p.Backend.Insert(fmt.Sprintf("%s/directive[1]", augConfPath), "IfModule", true); err != nil {
 			return "", fmt.Errorf("could not insert IfModule directive: %v", err)
 		}
 
@@ -608,71 +817,91 @@ func (p *Parser) CreateIfModule(augConfPath string, mod string, begining bool) (
 		path := fmt.Sprintf("%s/IfModule[last() + 1]", augConfPath)
 		argPath = fmt.Sprintf("%s/IfModule[last()]/arg", augConfPath)
 
-		if err = p.Augeas.Set(path, ""); err != nil {
+		if err = p.Backend.Set(path, ""); err != nil {
 			return "", fmt.Errorf("could not set IfModule directive: %v", err)
 		}
 
 		retPath = fmt.Sprintf("%s/IfModule[last()]/", augConfPath)
 	}
 
-	if err = p.Augeas.Set(argPath, mod); err != nil {
+	if err = p.Backend.Set(argPath, mod); err != nil {
 		return "", fmt.Errorf("could not set argument %s: %v", mod, err)
 	}
 
 	return retPath, nil
 }
 
-// isDirectivePassedFilter checks if directive can pass a filter
-func (p *Parser) isDirectivePassedFilter(match string, filter directiveFilter) (bool, error) {
-	lMatch := strings.ToLower(match)
-	lastMatchIdx := strings.Index(lMatch, filter.Name)
+// getIncludedFiles returns the absolute path pattern of Include directive argument and the matching files in the order apache reads them.
+// Directories are read recursively.
+func (p *Parser) getIncludedFiles(arg string) (string, []string) {
+	pattern := p.convertPathFromServerRootToAbs(arg)
 
-	for lastMatchIdx != -1 {
-		endOfIfIdx := strings.Index(lMatch[lastMatchIdx:], "/")
+	if com.IsDir(pattern) {
+		pattern = filepath.Join(pattern, "*")
+	}
 
-		if endOfIfIdx == -1 {
-			endOfIfIdx = len(lMatch)
-		} else {
-			endOfIfIdx += lastMatchIdx
-		}
+	// apache reads files matching the wildcard in alphabetical order
+	matches, _ := filepath.Glob(pattern)
+	var filePaths []string
 
-		expression, err := p.Augeas.Get(match[:endOfIfIdx] + "/arg")
+	for _, match := range matches {
+		filePaths = append(filePaths, getDirectoryFiles(match)...)
+	}
 
-		if err != nil {
-			return false, err
-		}
+	return pattern, filePaths
+}
 
-		if strings.HasPrefix(expression, "!") {
-			if com.IsSliceContainsStr(filter.Value, expression[1:]) {
-				return false, nil
-			}
-		} else {
-			if !com.IsSliceContainsStr(filter.Value, expression) {
-				return false, nil
-			}
+// getDirectoryFiles returns the files of the directory and its subdirectories in alphabetical order. The path is returned if it is not a directory.
+func getDirectoryFiles(dirPath string) []string {
+	if !com.IsDir(dirPath) {
+		return []string{dirPath}
+	}
+
+	infos, err := ioutil.ReadDir(dirPath)
+
+	if err != nil {
+		return nil
+	}
+
+	var filePaths []string
+
+	for _, info := range infos {
+		filePaths = append(filePaths, getDirectoryFiles(filepath.Join(dirPath, info.Name()))...)
+	}
+
+	return filePaths
+}
+
+// getIncludePatterns returns the patterns to load the included files with: the pattern of Include directive
+// and the patterns of the subdirectories, since the wildcard does not match the files in them.
+func getIncludePatterns(pattern string, filePaths []string) []string {
+	patterns := []string{pattern}
+	added := map[string]bool{pattern: true}
+
+	for _, filePath := range filePaths {
+		if isMatch, _ := path.Match(pattern, filePath); isMatch {
+			continue
 		}
 
-		lastMatchIdx = strings.Index(lMatch[endOfIfIdx:], filter.Name)
+		dirPattern := filepath.Join(filepath.Dir(filePath), "*")
 
-		if lastMatchIdx != -1 {
-			lastMatchIdx += endOfIfIdx
+		if !added[dirPattern] {
+			added[dirPattern] = true
+			patterns = append(patterns, dirPattern)
 		}
 	}
 
-	return true, nil
+	return patterns
 }
 
 // getIncludePath converts Apache Include directive to Augeas path
 func (p *Parser) getIncludePath(arg string) (string, error) {
-	arg = p.convertPathFromServerRootToAbs(arg)
-	info, err := os.Stat(arg)
-
-	if err == nil && info.IsDir() {
-		p.ParseFile(filepath.Join(arg, "*"))
-	} else {
-		p.ParseFile(arg)
+	for _, pattern := range getIncludePatterns(p.getIncludedFiles(arg)) {
+		p.ParseFile(pattern)
 	}
 
+	arg = p.convertPathFromServerRootToAbs(arg)
+
 	argParts := strings.Split(arg, "/")
 
 	for index, part := range argParts {
@@ -708,27 +937,6 @@ func (p *Parser) convertPathFromServerRootToAbs(path string) string {
 	return path
 }
 
-// GetModules returns loaded modules from httpd process
-func (p *Parser) getModules() []string {
-	modules := make([]string, 0)
-
-	for module := range p.Modules {
-		modules = append(modules, module)
-	}
-
-	return modules
-}
-
-func (p *Parser) getVariblesNames() []string {
-	names := make([]string, len(p.variables))
-
-	for name := range p.variables {
-		names = append(names, name)
-	}
-
-	return names
-}
-
 // Checks if fPath exists in augeas paths
 // We should try to append a new fPath to augeas
 // parser paths, and/or remove the old one with more
@@ -760,10 +968,10 @@ func (p *Parser) removeTransform(fPath string) {
 
 	for _, filename := range existedFilenames {
 		pathToRemove := filepath.Join(dirnameToRemove, filename)
-		includesToRemove, err := p.Augeas.Match(fmt.Sprintf("/augeas/load/Httpd/incl [. ='%s']", pathToRemove))
+		includesToRemove, err := p.Backend.Match(fmt.Sprintf("/augeas/load/Httpd/incl [. ='%s']", pathToRemove))
 
 		if err == nil && len(includesToRemove) > 0 {
-			p.Augeas.Remove(includesToRemove[0])
+			p.Backend.Remove(includesToRemove[0])
 		}
 	}
 
@@ -772,7 +980,7 @@ func (p *Parser) removeTransform(fPath string) {
 
 // Add a transform to Augeas
 func (p *Parser) addTransform(fPath string) error {
-	lastInclude, err := p.Augeas.Match("/augeas/load/Httpd/incl [last()]")
+	lastInclude, err := p.Backend.Match("/augeas/load/Httpd/incl [last()]")
 	dirnameToAdd := filepath.Dir(fPath)
 	fileNameToAdd := filepath.Base(fPath)
 
@@ -781,11 +989,11 @@ func (p *Parser) addTransform(fPath string) error {
 	}
 
 	if len(lastInclude) > 0 {
-		p.Augeas.Insert(lastInclude[0], "incl", false)
-		p.Augeas.Set("/augeas/load/Httpd/incl[last()]", fPath)
+		p.Backend.Insert(lastInclude[0], "incl", false)
+		p.Backend.Set("/augeas/load/Httpd/incl[last()]", fPath)
 	} else {
-		p.Augeas.Set("/augeas/load/Httpd/lens", "Httpd.lns")
-		p.Augeas.Set("/augeas/load/Httpd/incl", fPath)
+		p.Backend.Set("/augeas/load/Httpd/lens", "Httpd.lns")
+		p.Backend.Set("/augeas/load/Httpd/incl", fPath)
 	}
 
 	if p.Paths == nil {
@@ -798,41 +1006,41 @@ func (p *Parser) addTransform(fPath string) error {
 	return nil
 }
 
-//GetUnsavedFiles returns unsaved paths
+// GetUnsavedFiles returns unsaved paths
 func (p *Parser) GetUnsavedFiles() ([]string, error) {
 	// Current save method
-	saveMethod, err := p.Augeas.Get("/augeas/save")
+	saveMethod, err := p.Backend.Get("/augeas/save")
 
 	if err != nil {
 		return nil, err
 	}
 
 	// See https://github.com/hercules-team/augeas/wiki/Change-how-files-are-saved
-	if err = p.Augeas.Set("/augeas/save", "noop"); err != nil {
+	if err = p.Backend.Set("/augeas/save", "noop"); err != nil {
 		return nil, err
 	}
 
-	if err = p.Augeas.Save(); err != nil {
-		p.Augeas.Set("/augeas/save", saveMethod)
+	if err = p.Backend.Save(); err != nil {
+		p.Backend.Set("/augeas/save", saveMethod)
 		return nil, err
 	}
 
 	saveErr := p.GetAugeasError(nil)
-	p.Augeas.Set("/augeas/save", saveMethod)
+	p.Backend.Set("/augeas/save", saveMethod)
 
 	if saveErr != nil {
 		return nil, saveErr
 	}
 
 	var paths []string
-	matchesToSave, err := p.Augeas.Match("/augeas/events/saved")
+	matchesToSave, err := p.Backend.Match("/augeas/events/saved")
 
 	if err != nil {
 		return nil, err
 	}
 
 	for _, matchToSave := range matchesToSave {
-		pathToSave, err := p.Augeas.Get(matchToSave)
+		pathToSave, err := p.Backend.Get(matchToSave)
 
 		if err != nil {
 			return nil, err
@@ -844,6 +1052,129 @@ func (p *Parser) GetUnsavedFiles() ([]string, error) {
 	return paths, nil
 }
 
+// GetUnsavedFilesContent returns new content of the unsaved files rendered via augeas "newfile" save mode.
+// Augeas writes rendered files as *.augnew next to the originals, they are removed right after reading.
+func (p *Parser) GetUnsavedFilesContent() (map[string][]byte, error) {
+	unsavedFiles, err := p.GetUnsavedFiles()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(unsavedFiles) == 0 {
+		return nil, nil
+	}
+
+	saveMethod, err := p.Backend.Get("/augeas/save")
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err = p.Backend.Set("/augeas/save", "newfile"); err != nil {
+		return nil, err
+	}
+
+	saveErr := p.Backend.Save()
+	p.Backend.Set("/augeas/save", saveMethod)
+	contents := make(map[string][]byte)
+
+	for _, unsavedFile := range unsavedFiles {
+		newFilePath := unsavedFile + ".augnew"
+		content, err := ioutil.ReadFile(newFilePath)
+		os.Remove(newFilePath)
+
+		if err != nil && saveErr == nil {
+			saveErr = err
+		}
+
+		contents[unsavedFile] = content
+	}
+
+	if saveErr != nil {
+		return nil, fmt.Errorf("could not render unsaved files: %v", saveErr)
+	}
+
+	// augeas could mark the tree as saved, so it is marked as changed again to keep the changes unsaved
+	for _, unsavedFile := range unsavedFiles {
+		markerPath := fmt.Sprintf("/files%s/%s", escape(unsavedFile), dirtyMarkerLabel)
+		p.Backend.Set(markerPath, "")
+		p.Backend.Remove(markerPath)
+	}
+
+	return contents, nil
+}
+
+// LoadFileContent loads content to the augeas tree as the content of the file filePath without writing it to the disk.
+// The previous tree of the file is replaced. The file is saved on the next Save.
+func (p *Parser) LoadFileContent(filePath string, content []byte) error {
+	tmpFile, err := ioutil.TempFile("", "a2conf-*.conf")
+
+	if err != nil {
+		return err
+	}
+
+	tmpPath := tmpFile.Name()
+	defer os.Remove(tmpPath)
+	_, err = tmpFile.Write(content)
+	tmpFile.Close()
+
+	if err != nil {
+		return err
+	}
+
+	// LoadFile is used instead of Load since the latter resets all unsaved changes
+	if err = p.Backend.Set("/augeas/load/Httpd/incl[last() + 1]", tmpPath); err != nil {
+		return err
+	}
+
+	defer func() {
+		p.Backend.Remove(fmt.Sprintf("/augeas/load/Httpd/incl[. = '%s']", tmpPath))
+		p.Backend.Remove("/augeas/files" + tmpPath)
+		p.Backend.Remove("/files" + tmpPath)
+	}()
+
+	if err = p.Backend.LoadFile(tmpPath); err != nil {
+		return fmt.Errorf("could not parse content of the file '%s': %v", filePath, err)
+	}
+
+	if err = p.Backend.Move("/files"+tmpPath, "/files"+escape(filePath)); err != nil {
+		return err
+	}
+
+	if !p.IsFilenameExistInCurrentPaths(filePath) {
+		p.addTransform(filePath)
+	}
+
+	if !com.IsFile(filePath) {
+		if p.newFiles == nil {
+			p.newFiles = make(map[string]bool)
+		}
+
+		p.newFiles[filePath] = true
+	}
+
+	return nil
+}
+
+// getFilePath returns path of the file which the augeas node belongs to.
+// Files that are not written to the disk yet are also considered.
+func (p *Parser) getFilePath(augPath string) string {
+	fPath, err := p.Backend.Get(fmt.Sprintf("/augeas/files%s/path", utils.GetFilePathFromAugPath(augPath)))
+
+	if err == nil && fPath != "" {
+		return utils.GetFilePathFromAugPath(fPath)
+	}
+
+	for newFile := range p.newFiles {
+		if strings.HasPrefix(augPath, "/files"+newFile+"/") {
+			return newFile
+		}
+	}
+
+	return ""
+}
+
 // IsFilenameExistInCurrentPaths checks if the file path is parsed by current Augeas parser config
 func (p *Parser) IsFilenameExistInCurrentPaths(filename string) bool {
 	return p.isFilenameExistInPaths(filename, p.Paths)
@@ -872,6 +1203,18 @@ func (p *Parser) isFilenameExistInPaths(filename string, paths map[string][]stri
 	return false
 }
 
+func copyPaths(paths map[string][]string) map[string][]string {
+	pathsCopy := make(map[string][]string)
+
+	for k, v := range paths {
+		dst := make([]string, len(v))
+		copy(dst, v)
+		pathsCopy[k] = dst
+	}
+
+	return pathsCopy
+}
+
 // GetRootAugPath returns Augeas path of the root configuration
 func (p *Parser) GetRootAugPath() (string, error) {
 	return GetAugPath(p.ConfigRoot), nil
@@ -882,11 +1225,16 @@ func GetAugPath(fullPath string) string {
 	return fmt.Sprintf("/files/%s", fullPath)
 }
 
-func getServerRootPath(serverRootPath string) (string, error) {
+// getServerRootPath returns the server root. If it is not specified, HTTPD_ROOT of the build settings is used or one of the default paths.
+func getServerRootPath(serverRootPath string, compileSettings *apache.CompileSettings) (string, error) {
 	if serverRootPath != "" {
 		return filepath.Abs(serverRootPath)
 	}
 
+	if compileSettings != nil && com.IsDir(compileSettings.HTTPDRoot) {
+		return filepath.Abs(compileSettings.HTTPDRoot)
+	}
+
 	// check default paths
 	for _, serverRootPath := range serverRootPaths {
 		if com.IsDir(serverRootPath) {
@@ -896,3 +1244,19 @@ func getServerRootPath(serverRootPath string) (string, error) {
 
 	return "", fmt.Errorf("could not find server root path")
 }
+
+// getCompileSettings returns build settings of apache binary or nil if apachectl is not available
+func getCompileSettings(apachectl *apache.Ctl) *apache.CompileSettings {
+	if apachectl == nil {
+		return nil
+	}
+
+	// old or patched binaries could print the settings in other format, the default paths are used then
+	compileSettings, err := apachectl.GetCompileSettings()
+
+	if err != nil {
+		return nil
+	}
+
+	return compileSettings
+}
@@ -0,0 +1,74 @@
+package a2conf
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/r2dtools/a2conf/apache"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompileSettingsPaths(t *testing.T) {
+	dir := writeTestConfig(t, map[string]string{"conf/custom.conf": "Listen 80\n", "httpd.conf": "Listen 80\n"})
+	defer os.RemoveAll(dir)
+
+	settings := &apache.CompileSettings{HTTPDRoot: dir, ServerConfigFile: "conf/custom.conf"}
+	serverRoot, err := getServerRootPath("", settings)
+	assert.Nil(t, err)
+	assert.Equal(t, dir, serverRoot)
+
+	serverRoot, err = getServerRootPath("/srv/apache2", settings)
+	assert.Nil(t, err)
+	assert.Equal(t, "/srv/apache2", serverRoot)
+
+	parser := &Parser{ServerRoot: dir, compileSettings: settings}
+	assert.Nil(t, parser.setConfigRoot())
+	assert.Equal(t, filepath.Join(dir, "conf/custom.conf"), parser.ConfigRoot)
+
+	// the default config files are used if the config file of the build settings is missing
+	settings.ServerConfigFile = "/opt/httpd/conf/httpd.conf"
+	parser = &Parser{ServerRoot: dir, compileSettings: settings}
+	assert.Nil(t, parser.setConfigRoot())
+	assert.Equal(t, filepath.Join(dir, "httpd.conf"), parser.ConfigRoot)
+
+	parser = &Parser{ServerRoot: dir}
+	assert.Nil(t, parser.setConfigRoot())
+	assert.Equal(t, filepath.Join(dir, "httpd.conf"), parser.ConfigRoot)
+}
+
+func TestGetStaticModules(t *testing.T) {
+	assert.Equal(t, staticModules, (&Parser{}).getStaticModules())
+
+	settings := &apache.CompileSettings{MPM: "Event", Modules: []string{"core", "so", "http"}}
+	parser := &Parser{compileSettings: settings}
+	assert.Equal(t, []string{"core", "so", "http", "mpm_event"}, parser.getStaticModules())
+
+	parser.AddModule("mpm_event")
+	assert.True(t, isModuleLoaded(parser.Modules, "mpm_event_module"))
+
+	// the hardcoded modules are used if "-l" output is not available
+	settings.Modules = nil
+	assert.Equal(t, append(append([]string{}, staticModules...), "mpm_event"), parser.getStaticModules())
+
+	runWithBackends(t, func(t *testing.T, backend string) {
+		parser, dir := getTestParser(t, backend, map[string]string{
+			"apache2.conf": "<IfModule mpm_event_module>\n\tListen 80\n</IfModule>\n<IfModule mpm_prefork_module>\n\tListen 81\n</IfModule>\n",
+		})
+		defer os.RemoveAll(dir)
+		defer parser.Close()
+
+		parser.compileSettings = &apache.CompileSettings{MPM: "event"}
+		assert.Nil(t, parser.UpdateRuntimeVariables())
+
+		matches, err := parser.FindDirective("Listen", "", "", true)
+		assert.Nilf(t, err, "could not find directive: %v", err)
+		assert.Equal(t, 1, len(matches))
+
+		if len(matches) == 1 {
+			arg, err := parser.GetArg(matches[0])
+			assert.Nilf(t, err, "could not get argument: %v", err)
+			assert.Equal(t, "80", arg)
+		}
+	})
+}
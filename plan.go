@@ -0,0 +1,637 @@
+package a2conf
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/r2dtools/a2conf/configurator"
+	"github.com/r2dtools/a2conf/entity"
+	opts "github.com/r2dtools/a2conf/options"
+	"github.com/r2dtools/a2conf/utils"
+	"github.com/unknwon/com"
+	"gopkg.in/yaml.v3"
+)
+
+// Plan change types
+const (
+	PlanEnsureListen      = "ensure_listen"
+	PlanCreateVhost       = "create_vhost"
+	PlanEnableSite        = "enable_site"
+	PlanSetDirective      = "set_directive"
+	PlanDeployCertificate = "deploy_certificate"
+)
+
+// changes are applied in this order: ssl virtual hosts are created from the non-ssl ones,
+// so the latter must be completely configured before
+var planChangesOrder = map[string]int{
+	PlanEnsureListen:      0,
+	PlanCreateVhost:       1,
+	PlanEnableSite:        2,
+	PlanSetDirective:      3,
+	PlanDeployCertificate: 4,
+}
+
+// Spec describes desired state of the sites
+type Spec struct {
+	Sites []SiteSpec `json:"sites" yaml:"sites"`
+}
+
+// SiteSpec describes desired state of a site. A site is a set of virtual hosts with the same ServerName.
+// Empty fields are not managed: e.g. if Redirects is empty, existing Redirect directives are kept as is.
+// Ports are ports of non-ssl virtual hosts, 80 by default.
+type SiteSpec struct {
+	ServerName string         `json:"serverName" yaml:"serverName"`
+	Aliases    []string       `json:"aliases,omitempty" yaml:"aliases,omitempty"`
+	DocRoot    string         `json:"docRoot,omitempty" yaml:"docRoot,omitempty"`
+	Ports      []string       `json:"ports,omitempty" yaml:"ports,omitempty"`
+	TLS        *TLSSpec       `json:"tls,omitempty" yaml:"tls,omitempty"`
+	Redirects  []RedirectSpec `json:"redirects,omitempty" yaml:"redirects,omitempty"`
+	Proxies    []ProxySpec    `json:"proxies,omitempty" yaml:"proxies,omitempty"`
+}
+
+// TLSSpec describes certificate of a site
+type TLSSpec struct {
+	CertPath      string `json:"certPath" yaml:"certPath"`
+	CertKeyPath   string `json:"certKeyPath" yaml:"certKeyPath"`
+	ChainPath     string `json:"chainPath,omitempty" yaml:"chainPath,omitempty"`
+	FullChainPath string `json:"fullChainPath,omitempty" yaml:"fullChainPath,omitempty"`
+}
+
+// RedirectSpec describes Redirect directive: Redirect [status] path url
+type RedirectSpec struct {
+	Status string `json:"status,omitempty" yaml:"status,omitempty"`
+	Path   string `json:"path" yaml:"path"`
+	URL    string `json:"url" yaml:"url"`
+}
+
+// ProxySpec describes ProxyPass and ProxyPassReverse directives: ProxyPass path url
+type ProxySpec struct {
+	Path string `json:"path" yaml:"path"`
+	URL  string `json:"url" yaml:"url"`
+}
+
+// Plan is a list of changes required to reach the desired state
+type Plan struct {
+	Changes []PlanChange `json:"changes"`
+}
+
+// PlanChange is a single change of a plan
+type PlanChange struct {
+	Type       string     `json:"type"`
+	ServerName string     `json:"serverName"`
+	Port       string     `json:"port,omitempty"`
+	FilePath   string     `json:"filePath,omitempty"`
+	VhostPath  string     `json:"vhostPath,omitempty"`
+	Directive  string     `json:"directive,omitempty"`
+	Args       [][]string `json:"args,omitempty"`
+	Site       *SiteSpec  `json:"site,omitempty"`
+}
+
+// vhostDirective is a directive managed by a site spec
+type vhostDirective struct {
+	name string
+	args [][]string
+	// unordered directives are compared as a set of arguments, e.g. ServerAlias
+	unordered bool
+}
+
+// ParseSpec parses sites spec in YAML or JSON format
+func ParseSpec(data []byte) (*Spec, error) {
+	var spec Spec
+
+	// JSON is a subset of YAML, so both formats are parsed by YAML parser
+	if err := yaml.Unmarshal(data, &spec); err != nil {
+		return nil, fmt.Errorf("could not parse sites spec: %v", err)
+	}
+
+	for _, site := range spec.Sites {
+		if site.ServerName == "" {
+			return nil, fmt.Errorf("invalid sites spec: site ServerName is required")
+		}
+	}
+
+	return &spec, nil
+}
+
+// IsEmpty checks if the plan has no changes
+func (p *Plan) IsEmpty() bool {
+	return len(p.Changes) == 0
+}
+
+// String returns change description
+func (c PlanChange) String() string {
+	switch c.Type {
+	case PlanEnsureListen:
+		return fmt.Sprintf("listen port %s", c.Port)
+	case PlanCreateVhost:
+		return fmt.Sprintf("create virtual host '%s:%s' in '%s'", c.ServerName, c.Port, c.FilePath)
+	case PlanEnableSite:
+		return fmt.Sprintf("enable site '%s'", c.FilePath)
+	case PlanSetDirective:
+		return fmt.Sprintf("set '%s' directive of virtual host '%s' in '%s'", c.Directive, c.ServerName, c.FilePath)
+	case PlanDeployCertificate:
+		return fmt.Sprintf("deploy certificate to '%s'", c.ServerName)
+	}
+
+	return c.Type
+}
+
+// Plan computes changes required to bring virtual hosts to the state described by spec
+func (ac *apacheConfigurator) Plan(spec *Spec) (*Plan, error) {
+	vhosts, err := ac.GetVhosts()
+
+	if err != nil {
+		return nil, err
+	}
+
+	listens, err := ac.getListens()
+
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &Plan{}
+
+	for i := range spec.Sites {
+		site := &spec.Sites[i]
+
+		for _, port := range site.getPorts() {
+			if !configurator.IsPortListened(listens, port) {
+				plan.Changes = append(plan.Changes, PlanChange{Type: PlanEnsureListen, ServerName: site.ServerName, Port: port})
+				listens = append(listens, port)
+			}
+		}
+
+		changes, err := ac.planSite(site, vhosts)
+
+		if err != nil {
+			return nil, fmt.Errorf("could not plan site '%s': %v", site.ServerName, err)
+		}
+
+		plan.Changes = append(plan.Changes, changes...)
+	}
+
+	sort.SliceStable(plan.Changes, func(i, j int) bool {
+		return planChangesOrder[plan.Changes[i].Type] < planChangesOrder[plan.Changes[j].Type]
+	})
+
+	return plan, nil
+}
+
+// Apply applies plan changes and saves them. Changes can be rolled back via Rollback.
+// To check the configuration and restart the web server Apply can be executed within a Transaction.
+func (ac *apacheConfigurator) Apply(plan *Plan) error {
+	for _, change := range plan.Changes {
+		var err error
+
+		switch change.Type {
+		case PlanEnsureListen:
+			err = ac.EnsurePortIsListening(change.Port, false)
+		case PlanCreateVhost:
+			err = ac.createVhostFromSpec(change.Site, change.Port, change.FilePath)
+		case PlanEnableSite:
+			err = ac.EnableSite(&entity.VirtualHost{FilePath: change.FilePath})
+		case PlanSetDirective:
+			err = ac.setVhostDirective(change.VhostPath, change.Directive, change.Args)
+		case PlanDeployCertificate:
+			err = ac.deployCertificateFromSpec(change.Site)
+		default:
+			err = fmt.Errorf("unknown change type '%s'", change.Type)
+		}
+
+		if err != nil {
+			return fmt.Errorf("could not apply change '%s': %v", change, err)
+		}
+	}
+
+	if err := ac.Save(); err != nil {
+		return err
+	}
+
+	ac.vhosts = nil
+
+	return nil
+}
+
+func (ac *apacheConfigurator) planSite(site *SiteSpec, vhosts []*entity.VirtualHost) ([]PlanChange, error) {
+	var changes []PlanChange
+	var siteVhosts, sslVhosts []*entity.VirtualHost
+
+	for _, vhost := range vhosts {
+		if vhost.ModMacro || vhost.ServerName != site.ServerName {
+			continue
+		}
+
+		siteVhosts = append(siteVhosts, vhost)
+
+		if vhost.Ssl {
+			sslVhosts = append(sslVhosts, vhost)
+		}
+	}
+
+	for _, port := range site.getPorts() {
+		if hasVhostWithPort(siteVhosts, port) {
+			continue
+		}
+
+		filePath, err := ac.getNewVhostFilePath(site.ServerName, port)
+
+		if err != nil {
+			return nil, err
+		}
+
+		changes = append(changes, PlanChange{Type: PlanCreateVhost, ServerName: site.ServerName, Port: port, FilePath: filePath, Site: site})
+	}
+
+	for _, vhost := range siteVhosts {
+		if !vhost.Enabled {
+			changes = append(changes, PlanChange{Type: PlanEnableSite, ServerName: site.ServerName, FilePath: vhost.FilePath})
+		}
+
+		// directives of the macro are shared by all its uses, so they are not changed
+		if vhost.Macro != "" {
+			ac.logger.Warn(fmt.Sprintf("virtual host '%s' is defined by the macro '%s'. Skip its directives.", vhost.FilePath, vhost.Macro))
+			continue
+		}
+
+		vhostChanges, err := ac.planVhostDirectives(site, vhost)
+
+		if err != nil {
+			return nil, err
+		}
+
+		changes = append(changes, vhostChanges...)
+	}
+
+	if site.TLS != nil {
+		deployed, err := ac.isCertificateDeployed(site.TLS, sslVhosts)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if !deployed {
+			changes = append(changes, PlanChange{Type: PlanDeployCertificate, ServerName: site.ServerName, Site: site})
+		}
+	}
+
+	return changes, nil
+}
+
+func (ac *apacheConfigurator) planVhostDirectives(site *SiteSpec, vhost *entity.VirtualHost) ([]PlanChange, error) {
+	var changes []PlanChange
+
+	for _, directive := range site.getDirectives() {
+		var equal bool
+
+		if directive.name == "DocumentRoot" {
+			// vhost DocRoot is already resolved relative to the ServerRoot
+			equal = filepath.Clean(vhost.DocRoot) == filepath.Clean(directive.args[0][0])
+		} else {
+			args, err := ac.getVhostDirectiveArgs(vhost.AugPath, directive.name)
+
+			if err != nil {
+				return nil, err
+			}
+
+			equal = isDirectiveArgsEqual(args, directive.args, directive.unordered)
+		}
+
+		if !equal {
+			changes = append(changes, PlanChange{
+				Type:       PlanSetDirective,
+				ServerName: site.ServerName,
+				FilePath:   vhost.FilePath,
+				VhostPath:  vhost.AugPath,
+				Directive:  directive.name,
+				Args:       directive.args,
+			})
+		}
+	}
+
+	return changes, nil
+}
+
+func (ac *apacheConfigurator) isCertificateDeployed(tls *TLSSpec, sslVhosts []*entity.VirtualHost) (bool, error) {
+	if len(sslVhosts) == 0 {
+		return false, nil
+	}
+
+	certPath, err := ac.getCertificatePathToDeploy(tls.CertPath, tls.ChainPath, tls.FullChainPath)
+
+	if err != nil {
+		return false, err
+	}
+
+	for _, vhost := range sslVhosts {
+		certArgs, err := ac.getVhostDirectiveArgs(vhost.AugPath, "SSLCertificateFile")
+
+		if err != nil {
+			return false, err
+		}
+
+		keyArgs, err := ac.getVhostDirectiveArgs(vhost.AugPath, "SSLCertificateKeyFile")
+
+		if err != nil {
+			return false, err
+		}
+
+		if len(certArgs) == 0 || len(keyArgs) == 0 {
+			return false, nil
+		}
+
+		// the last directive wins
+		if !com.CompareSliceStr(certArgs[len(certArgs)-1], []string{certPath}) || !com.CompareSliceStr(keyArgs[len(keyArgs)-1], []string{tls.CertKeyPath}) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// getCertificatePathToDeploy returns the path that DeployCertificate sets to SSLCertificateFile directive
+func (ac *apacheConfigurator) getCertificatePathToDeploy(certPath, chainPath, fullChainPath string) (string, error) {
+	res, err := utils.CheckMinVersion(ac.version, "2.4.8")
+
+	if err != nil {
+		return "", err
+	}
+
+	if !res || (chainPath != "" && fullChainPath == "") {
+		return certPath, nil
+	}
+
+	return fullChainPath, nil
+}
+
+func (ac *apacheConfigurator) deployCertificateFromSpec(site *SiteSpec) error {
+	// ssl virtual host is created from the content of non-ssl one on the disk, so all changes must be saved before
+	if err := ac.Save(); err != nil {
+		return err
+	}
+
+	ac.vhosts = nil
+
+	return ac.DeployCertificate(site.ServerName, site.TLS.CertPath, site.TLS.CertKeyPath, site.TLS.ChainPath, site.TLS.FullChainPath)
+}
+
+func (ac *apacheConfigurator) createVhostFromSpec(site *SiteSpec, port, filePath string) error {
+	if com.IsExist(filePath) {
+		return fmt.Errorf("file '%s' already exists", filePath)
+	}
+
+	content := []byte(getVhostContentFromSpec(site, port))
+
+	if ac.dryRun {
+		if err := ac.parser.LoadFileContent(filePath, content); err != nil {
+			return err
+		}
+	} else {
+		ac.reverter.AddFileToDeletion(filePath)
+
+		if err := ioutil.WriteFile(filePath, content, 0644); err != nil {
+			return err
+		}
+
+		if err := ac.parser.ParseFile(filePath); err != nil {
+			return fmt.Errorf("could not parse virtual host file '%s': %v", filePath, err)
+		}
+	}
+
+	ac.vhosts = nil
+
+	return ac.EnableSite(&entity.VirtualHost{FilePath: filePath})
+}
+
+// setVhostDirective replaces all directives with the name within the virtual host with the new ones
+func (ac *apacheConfigurator) setVhostDirective(vhostPath, name string, args [][]string) error {
+	section, err := ac.parser.Tree.GetNode(vhostPath)
+
+	if err != nil {
+		return err
+	}
+
+	// only direct children are replaced, directives within nested sections like <Location> are kept
+	directives, err := ac.parser.Tree.FindDirectives(section, name)
+
+	if err != nil {
+		return err
+	}
+
+	for _, directive := range directives {
+		if err = ac.parser.Tree.Remove(directive); err != nil {
+			return err
+		}
+	}
+
+	for _, dArgs := range args {
+		if _, err = ac.parser.Tree.AddDirective(section, name, dArgs); err != nil {
+			return fmt.Errorf("could not add '%s' directive to vhost %s: %v", name, vhostPath, err)
+		}
+	}
+
+	return nil
+}
+
+// getVhostDirectiveArgs returns arguments of the directives that are direct children of the virtual host
+func (ac *apacheConfigurator) getVhostDirectiveArgs(vhostPath, name string) ([][]string, error) {
+	section, err := ac.parser.Tree.GetNode(vhostPath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	directives, err := ac.parser.Tree.FindDirectives(section, name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var args [][]string
+
+	for _, directive := range directives {
+		argNodes, err := ac.parser.Tree.GetArgs(directive)
+
+		if err != nil {
+			return nil, err
+		}
+
+		var dArgs []string
+
+		for _, argNode := range argNodes {
+			arg, err := ac.parser.InterpretArgAt(argNode.Value, argNode.Path)
+
+			if err != nil {
+				return nil, err
+			}
+
+			dArgs = append(dArgs, arg)
+		}
+
+		args = append(args, dArgs)
+	}
+
+	return args, nil
+}
+
+func (ac *apacheConfigurator) getListens() ([]string, error) {
+	var listens []string
+	listenMatches, err := ac.parser.FindDirective("Listen", "", "", true)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, lMatch := range listenMatches {
+		listen, err := ac.parser.GetArg(lMatch)
+
+		if err != nil {
+			return nil, err
+		}
+
+		listens = append(listens, listen)
+	}
+
+	return listens, nil
+}
+
+// getNewVhostFilePath returns config file path for a new virtual host
+func (ac *apacheConfigurator) getNewVhostFilePath(serverName, port string) (string, error) {
+	vhostRoot := opts.GetOption(opts.VhostRoot, ac.options)
+
+	if vhostRoot == "" {
+		for _, dir := range []string{"sites-available", "conf.d"} {
+			if com.IsDir(filepath.Join(ac.parser.ServerRoot, dir)) {
+				vhostRoot = filepath.Join(ac.parser.ServerRoot, dir)
+				break
+			}
+		}
+	}
+
+	if vhostRoot == "" {
+		return "", fmt.Errorf("could not detect directory for virtual host config files")
+	}
+
+	fileName := serverName
+
+	if port != "80" {
+		fileName = fmt.Sprintf("%s-%s", serverName, port)
+	}
+
+	return filepath.Join(vhostRoot, fileName+".conf"), nil
+}
+
+func (s *SiteSpec) getPorts() []string {
+	if len(s.Ports) == 0 {
+		return []string{"80"}
+	}
+
+	return s.Ports
+}
+
+func (s *SiteSpec) getDirectives() []vhostDirective {
+	var directives []vhostDirective
+
+	if len(s.Aliases) > 0 {
+		directives = append(directives, vhostDirective{name: "ServerAlias", args: [][]string{s.Aliases}, unordered: true})
+	}
+
+	if s.DocRoot != "" {
+		directives = append(directives, vhostDirective{name: "DocumentRoot", args: [][]string{{s.DocRoot}}})
+	}
+
+	if len(s.Redirects) > 0 {
+		var args [][]string
+
+		for _, redirect := range s.Redirects {
+			if redirect.Status != "" {
+				args = append(args, []string{redirect.Status, redirect.Path, redirect.URL})
+			} else {
+				args = append(args, []string{redirect.Path, redirect.URL})
+			}
+		}
+
+		directives = append(directives, vhostDirective{name: "Redirect", args: args})
+	}
+
+	if len(s.Proxies) > 0 {
+		var args [][]string
+
+		for _, proxy := range s.Proxies {
+			args = append(args, []string{proxy.Path, proxy.URL})
+		}
+
+		directives = append(directives, vhostDirective{name: "ProxyPass", args: args})
+		directives = append(directives, vhostDirective{name: "ProxyPassReverse", args: args})
+	}
+
+	return directives
+}
+
+// getVhostContentFromSpec returns content of the config file for a new virtual host
+func getVhostContentFromSpec(site *SiteSpec, port string) string {
+	lines := []string{
+		fmt.Sprintf("<VirtualHost *:%s>", port),
+		"\t" + formatDirective("ServerName", []string{site.ServerName}),
+	}
+
+	for _, directive := range site.getDirectives() {
+		for _, args := range directive.args {
+			lines = append(lines, "\t"+formatDirective(directive.name, args))
+		}
+	}
+
+	lines = append(lines, "</VirtualHost>", "")
+
+	return strings.Join(lines, "\n")
+}
+
+func hasVhostWithPort(vhosts []*entity.VirtualHost, port string) bool {
+	for _, vhost := range vhosts {
+		if vhost.Ssl {
+			continue
+		}
+
+		for _, address := range vhost.Addresses {
+			if address.Port == port {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func isDirectiveArgsEqual(actual, expected [][]string, unordered bool) bool {
+	if unordered {
+		var actualArgs, expectedArgs []string
+
+		for _, args := range actual {
+			actualArgs = append(actualArgs, args...)
+		}
+
+		for _, args := range expected {
+			expectedArgs = append(expectedArgs, args...)
+		}
+
+		sort.Strings(actualArgs)
+		sort.Strings(expectedArgs)
+
+		return com.CompareSliceStrU(actualArgs, expectedArgs) && len(actualArgs) == len(expectedArgs)
+	}
+
+	if len(actual) != len(expected) {
+		return false
+	}
+
+	for i := range actual {
+		if !com.CompareSliceStr(actual[i], expected[i]) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,80 @@
+package a2conf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSpec(t *testing.T) {
+	yamlSpec := `
+sites:
+  - serverName: example.com
+    aliases: [www.example.com]
+    docRoot: /var/www/html
+    redirects:
+      - status: "301"
+        path: /old
+        url: http://example.com/new
+    proxies:
+      - path: /api
+        url: http://127.0.0.1:8080/
+`
+	spec, err := ParseSpec([]byte(yamlSpec))
+	assert.Nilf(t, err, "could not parse YAML spec: %v", err)
+	assert.Equal(t, 1, len(spec.Sites))
+	site := spec.Sites[0]
+	assert.Equal(t, "example.com", site.ServerName)
+	assert.Equal(t, []string{"www.example.com"}, site.Aliases)
+	assert.Equal(t, []string{"80"}, site.getPorts())
+	assert.Equal(t, RedirectSpec{Status: "301", Path: "/old", URL: "http://example.com/new"}, site.Redirects[0])
+	assert.Equal(t, ProxySpec{Path: "/api", URL: "http://127.0.0.1:8080/"}, site.Proxies[0])
+
+	jsonSpec := `{"sites": [{"serverName": "example.com", "ports": ["8080"], "tls": {"certPath": "/tmp/cert.pem", "certKeyPath": "/tmp/key.pem"}}]}`
+	spec, err = ParseSpec([]byte(jsonSpec))
+	assert.Nilf(t, err, "could not parse JSON spec: %v", err)
+	assert.Equal(t, []string{"8080"}, spec.Sites[0].getPorts())
+	assert.Equal(t, "/tmp/key.pem", spec.Sites[0].TLS.CertKeyPath)
+
+	_, err = ParseSpec([]byte(`{"sites": [{"docRoot": "/var/www/html"}]}`))
+	assert.NotNil(t, err)
+}
+
+func TestGetVhostContentFromSpec(t *testing.T) {
+	site := &SiteSpec{
+		ServerName: "example.com",
+		Aliases:    []string{"www.example.com", "example.org"},
+		DocRoot:    "/var/www/html",
+		Proxies:    []ProxySpec{{Path: "/api", URL: "http://127.0.0.1:8080/"}},
+	}
+	expected := `<VirtualHost *:8080>
+	ServerName example.com
+	ServerAlias www.example.com example.org
+	DocumentRoot /var/www/html
+	ProxyPass /api http://127.0.0.1:8080/
+	ProxyPassReverse /api http://127.0.0.1:8080/
+</VirtualHost>
+`
+	assert.Equal(t, expected, getVhostContentFromSpec(site, "8080"))
+
+	site = &SiteSpec{
+		ServerName: "example.com",
+		DocRoot:    "/var/www/my site",
+		Redirects:  []RedirectSpec{{Status: "301", Path: "/old page", URL: `https://example.com/"new"`}},
+	}
+	expected = `<VirtualHost *:80>
+	ServerName example.com
+	DocumentRoot "/var/www/my site"
+	Redirect 301 "/old page" https://example.com/"new"
+</VirtualHost>
+`
+	assert.Equal(t, expected, getVhostContentFromSpec(site, "80"))
+}
+
+func TestIsDirectiveArgsEqual(t *testing.T) {
+	assert.True(t, isDirectiveArgsEqual([][]string{{"a", "b"}, {"c"}}, [][]string{{"c", "b", "a"}}, true))
+	assert.False(t, isDirectiveArgsEqual([][]string{{"a", "b"}}, [][]string{{"a", "b", "c"}}, true))
+	assert.True(t, isDirectiveArgsEqual([][]string{{"/a", "http://a"}, {"/b", "http://b"}}, [][]string{{"/a", "http://a"}, {"/b", "http://b"}}, false))
+	assert.False(t, isDirectiveArgsEqual([][]string{{"/b", "http://b"}, {"/a", "http://a"}}, [][]string{{"/a", "http://a"}, {"/b", "http://b"}}, false))
+	assert.False(t, isDirectiveArgsEqual(nil, [][]string{{"/a", "http://a"}}, false))
+}
@@ -1,15 +1,23 @@
 package a2conf
 
 import (
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/r2dtools/a2conf/apache"
 	"github.com/r2dtools/a2conf/logger"
+	opts "github.com/r2dtools/a2conf/options"
+	"github.com/r2dtools/a2conf/utils"
 	"github.com/unknwon/com"
 )
 
+// legacyBackupExt is an extension of backups that were stored next to the original files by previous versions
+const legacyBackupExt = ".back"
+
 type rollbackError struct {
 	err error
 }
@@ -18,13 +26,26 @@ func (re *rollbackError) Error() string {
 	return fmt.Sprintf("rollback failed: %v", re.err)
 }
 
-// Reverter reverts change back for configuration files of virtual hosts
+// fileBackup keeps everything required to restore a backed up file
+type fileBackup struct {
+	backupPath string
+	// realPath is a path of the file with content. It differs from the original path if the latter is a symlink.
+	realPath   string
+	linkTarget string
+	attrs      *utils.FileAttrs
+}
+
+// Reverter reverts changes back. Each change is registered as a RollbackAction,
+// all actions are executed in reverse order on rollback.
 type Reverter struct {
-	filesToDelete    []string
-	filesToRestore   map[string]string
-	configsToDisable []string
-	apacheSite       *apache.Site
-	logger           logger.Logger
+	actions         []RollbackAction
+	filesToDelete   []string
+	filesToRestore  map[string]*fileBackup
+	rollbackResults []RollbackResult
+	apacheSite      *apache.Site
+	apacheModule    *apache.Module
+	logger          logger.Logger
+	backupDir       string
 }
 
 // SetLogger sets logger
@@ -32,9 +53,15 @@ func (r *Reverter) SetLogger(logger logger.Logger) {
 	r.logger = logger
 }
 
+// AddAction registers custom action that will be executed on rollback
+func (r *Reverter) AddAction(action RollbackAction) {
+	r.actions = append(r.actions, action)
+}
+
 // AddFileToDeletion marks file to delete on rollback
 func (r *Reverter) AddFileToDeletion(filePath string) {
 	r.filesToDelete = append(r.filesToDelete, filePath)
+	r.AddAction(&fileDeleteAction{filePath: filePath, reverter: r})
 }
 
 // BackupFiles makes files backups
@@ -63,107 +90,194 @@ func (r *Reverter) BackupFile(filePath string) error {
 		return nil
 	}
 
-	content, err := ioutil.ReadFile(filePath)
+	backup := fileBackup{backupPath: bFilePath, realPath: filePath}
+	info, err := os.Lstat(filePath)
+
+	if err != nil {
+		return err
+	}
+
+	if info.Mode()&os.ModeSymlink != 0 {
+		if backup.linkTarget, err = os.Readlink(filePath); err != nil {
+			return err
+		}
+
+		if backup.realPath, err = filepath.EvalSymlinks(filePath); err != nil {
+			return err
+		}
+	}
+
+	if backup.attrs, err = utils.GetFileAttrs(backup.realPath); err != nil {
+		return err
+	}
+
+	content, err := ioutil.ReadFile(backup.realPath)
 
 	if err != nil {
 		return err
 	}
 
-	err = ioutil.WriteFile(bFilePath, content, 0644)
+	if err = os.MkdirAll(filepath.Dir(bFilePath), 0700); err != nil {
+		return err
+	}
+
+	// backups may contain secrets, so they are readable by the owner only
+	err = utils.WriteFileAtomic(bFilePath, content, &utils.FileAttrs{Mode: 0600, UID: -1, GID: -1})
 
 	if err != nil {
 		return err
 	}
 
 	if r.filesToRestore == nil {
-		r.filesToRestore = make(map[string]string)
+		r.filesToRestore = make(map[string]*fileBackup)
 	}
 
-	r.filesToRestore[filePath] = bFilePath
+	r.filesToRestore[filePath] = &backup
+	r.AddAction(&fileRestoreAction{filePath: filePath, backup: &backup, reverter: r})
 
 	return nil
 }
 
 // AddSiteConfigToDisable marks apache site config as needed to be disabled on rollback
 func (r *Reverter) AddSiteConfigToDisable(siteConfigName string) {
-	r.configsToDisable = append(r.configsToDisable, siteConfigName)
+	r.AddAction(&siteDisableAction{siteConfigName: siteConfigName, site: r.apacheSite})
 }
 
-// Rollback rollback all changes
+// AddModuleToDisable marks apache module as needed to be disabled on rollback. Temporary enabled module is disabled on commit too.
+func (r *Reverter) AddModuleToDisable(moduleName string, temp bool) {
+	r.AddAction(&moduleDisableAction{moduleName: moduleName, module: r.apacheModule, temp: temp})
+}
+
+// Rollback executes all registered actions in reverse order.
+// All actions are executed even if some of them fail. Failed actions are kept, so rollback can be retried.
 func (r *Reverter) Rollback() error {
-	// Disable all enabled before sites
-	// Note: only hosts enabled via a2ensite utility are in this slice
-	for _, siteConfigToDisable := range r.configsToDisable {
-		if err := r.apacheSite.Disable(siteConfigToDisable); err != nil {
-			return &rollbackError{err}
-		}
-	}
+	var failedActions []RollbackAction
+	var errs []string
+	r.rollbackResults = nil
 
-	// remove created files
-	for _, fileToDelete := range r.filesToDelete {
-		_, err := os.Stat(fileToDelete)
+	for i := len(r.actions) - 1; i >= 0; i-- {
+		action := r.actions[i]
+		err := action.Rollback()
+		r.rollbackResults = append(r.rollbackResults, RollbackResult{Action: action.String(), Err: err})
 
-		if os.IsNotExist(err) {
-			r.logger.Debug(fmt.Sprintf("file '%s' does not exist. Skip its deletion.", fileToDelete))
+		if err != nil {
+			r.logger.Error(fmt.Sprintf("rollback action '%s' failed: %v", action, err))
+			// keep the original order of failed actions
+			failedActions = append([]RollbackAction{action}, failedActions...)
+			errs = append(errs, fmt.Sprintf("%s: %v", action, err))
 			continue
 		}
 
-		if err != nil {
-			return &rollbackError{err}
-		}
+		r.logger.Debug(fmt.Sprintf("rollback action '%s' succeeded.", action))
+	}
 
-		err = os.Remove(fileToDelete)
+	r.setActions(failedActions)
 
-		if err != nil {
-			return &rollbackError{err}
+	if len(errs) > 0 {
+		return &rollbackError{errors.New(strings.Join(errs, "; "))}
+	}
+
+	return nil
+}
+
+// GetRollbackResults returns results of the actions executed on the last rollback
+func (r *Reverter) GetRollbackResults() []RollbackResult {
+	return r.rollbackResults
+}
+
+// Commit commits changes. All backups will be removed.
+// Failed actions are kept, so commit can be retried or they can be rolled back.
+func (r *Reverter) Commit() error {
+	var failedActions []RollbackAction
+	var errs []string
+
+	for _, action := range r.actions {
+		if err := action.Commit(); err != nil {
+			r.logger.Error(fmt.Sprintf("could not commit action '%s': %v", action, err))
+			failedActions = append(failedActions, action)
+			errs = append(errs, fmt.Sprintf("%s: %v", action, err))
 		}
 	}
 
-	if r.filesToRestore == nil {
-		return nil
+	r.setActions(failedActions)
+
+	if len(errs) > 0 {
+		return fmt.Errorf("commit failed: %s", strings.Join(errs, "; "))
 	}
 
-	// restore the content of backed up files
-	for originFilePath, bFilePath := range r.filesToRestore {
-		bContent, err := ioutil.ReadFile(bFilePath)
+	return nil
+}
 
-		if err != nil {
-			return &rollbackError{err}
+// setActions replaces registered actions. Files to delete and to restore are kept for the file actions,
+// so the files are not backed up again over the backups that are not restored yet.
+func (r *Reverter) setActions(actions []RollbackAction) {
+	r.actions = actions
+	r.filesToDelete = nil
+	r.filesToRestore = nil
+
+	for _, action := range actions {
+		switch action := action.(type) {
+		case *fileDeleteAction:
+			r.filesToDelete = append(r.filesToDelete, action.filePath)
+		case *fileRestoreAction:
+			if r.filesToRestore == nil {
+				r.filesToRestore = make(map[string]*fileBackup)
+			}
+
+			r.filesToRestore[action.filePath] = action.backup
 		}
+	}
+}
 
-		err = ioutil.WriteFile(originFilePath, bContent, 0644)
+// RemoveLegacyBackups removes *.back files left next to the original configs by previous versions and returns their paths.
+// Previous versions backed up every saved file, e.g. ports.conf.back or sites-enabled/example.com.conf.back,
+// so backups of all existing files are removed. *.back files without the original file are kept.
+func (r *Reverter) RemoveLegacyBackups(rootPath string) ([]string, error) {
+	var removed []string
+
+	if rootPath == "" || !com.IsDir(rootPath) {
+		return nil, nil
+	}
 
+	err := filepath.Walk(rootPath, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
-			return &rollbackError{err}
+			r.logger.Debug(fmt.Sprintf("could not access '%s' while searching legacy backups: %v", path, err))
+			return nil
 		}
 
-		if err := os.Remove(bFilePath); err != nil {
-			r.logger.Error(fmt.Sprintf("could not remove file '%s' on reverter rollback: %v", bFilePath, err))
+		if info.IsDir() || !strings.HasSuffix(path, legacyBackupExt) {
+			return nil
 		}
 
-		delete(r.filesToRestore, originFilePath)
-	}
-
-	return nil
-}
+		// Remove only backups whose original file still exists, the others are not made by reverter
+		if !com.IsFile(strings.TrimSuffix(path, legacyBackupExt)) {
+			return nil
+		}
 
-// Commit commits changes. All *.back files will be removed.
-func (r *Reverter) Commit() error {
-	for filePath, bFilePath := range r.filesToRestore {
-		if com.IsFile(bFilePath) {
-			if err := os.Remove(bFilePath); err != nil {
-				r.logger.Error(fmt.Sprintf("could not remove file '%s' on reverter commit: %v", bFilePath, err))
-			}
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("could not remove legacy backup '%s': %v", path, err)
 		}
 
-		delete(r.filesToRestore, filePath)
-	}
+		r.logger.Debug(fmt.Sprintf("legacy backup '%s' is removed.", path))
+		removed = append(removed, path)
 
-	r.filesToDelete = nil
+		return nil
+	})
 
-	return nil
+	return removed, err
 }
 
+// getBackupFilePath returns file backup path. Backups are stored in the backup directory mirroring original paths.
 func (r *Reverter) getBackupFilePath(filePath string) string {
-	return filePath + ".back"
+	backupDir := r.backupDir
+
+	if backupDir == "" {
+		backupDir = opts.GetOption(opts.BackupDir, nil)
+	}
+
+	if absFilePath, err := filepath.Abs(filePath); err == nil {
+		filePath = absFilePath
+	}
+
+	return filepath.Join(backupDir, filePath)
 }
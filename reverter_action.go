@@ -0,0 +1,149 @@
+package a2conf
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/r2dtools/a2conf/apache"
+	"github.com/r2dtools/a2conf/utils"
+	"github.com/unknwon/com"
+)
+
+// RollbackAction is an undo operation for a single change. Actions are executed in reverse order on rollback.
+type RollbackAction interface {
+	// Rollback reverts the change
+	Rollback() error
+	// Commit is called when changes are committed. It can be used to remove temporary data, e.g. backups.
+	Commit() error
+	// String returns action description
+	String() string
+}
+
+// RollbackResult is a result of a single rollback action
+type RollbackResult struct {
+	Action string
+	Err    error
+}
+
+type fileDeleteAction struct {
+	filePath string
+	reverter *Reverter
+}
+
+func (a *fileDeleteAction) Rollback() error {
+	_, err := os.Lstat(a.filePath)
+
+	if os.IsNotExist(err) {
+		a.reverter.logger.Debug(fmt.Sprintf("file '%s' does not exist. Skip its deletion.", a.filePath))
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return os.Remove(a.filePath)
+}
+
+func (a *fileDeleteAction) Commit() error {
+	return nil
+}
+
+func (a *fileDeleteAction) String() string {
+	return fmt.Sprintf("delete file '%s'", a.filePath)
+}
+
+type fileRestoreAction struct {
+	filePath string
+	backup   *fileBackup
+	reverter *Reverter
+}
+
+// Rollback atomically restores file content, mode, owner and extended attributes.
+// If the original file was a symlink, the symlink is restored as well.
+func (a *fileRestoreAction) Rollback() error {
+	bContent, err := ioutil.ReadFile(a.backup.backupPath)
+
+	if err != nil {
+		return err
+	}
+
+	if err = utils.WriteFileAtomic(a.backup.realPath, bContent, a.backup.attrs); err != nil {
+		return err
+	}
+
+	if a.backup.linkTarget != "" {
+		linkTarget, err := os.Readlink(a.filePath)
+
+		if err != nil || linkTarget != a.backup.linkTarget {
+			if err = utils.SymlinkAtomic(a.backup.linkTarget, a.filePath); err != nil {
+				return err
+			}
+		}
+	}
+
+	a.removeBackup()
+
+	return nil
+}
+
+func (a *fileRestoreAction) Commit() error {
+	a.removeBackup()
+
+	return nil
+}
+
+func (a *fileRestoreAction) String() string {
+	return fmt.Sprintf("restore file '%s'", a.filePath)
+}
+
+func (a *fileRestoreAction) removeBackup() {
+	if !com.IsFile(a.backup.backupPath) {
+		return
+	}
+
+	if err := os.Remove(a.backup.backupPath); err != nil {
+		a.reverter.logger.Error(fmt.Sprintf("could not remove backup '%s': %v", a.backup.backupPath, err))
+	}
+}
+
+type siteDisableAction struct {
+	siteConfigName string
+	site           *apache.Site
+}
+
+func (a *siteDisableAction) Rollback() error {
+	return a.site.Disable(a.siteConfigName)
+}
+
+func (a *siteDisableAction) Commit() error {
+	return nil
+}
+
+func (a *siteDisableAction) String() string {
+	return fmt.Sprintf("disable site '%s'", a.siteConfigName)
+}
+
+type moduleDisableAction struct {
+	moduleName string
+	module     *apache.Module
+	// temp is set if the module is enabled until changes are committed
+	temp bool
+}
+
+func (a *moduleDisableAction) Rollback() error {
+	return a.module.Disable(a.moduleName)
+}
+
+func (a *moduleDisableAction) Commit() error {
+	if a.temp {
+		return a.module.Disable(a.moduleName)
+	}
+
+	return nil
+}
+
+func (a *moduleDisableAction) String() string {
+	return fmt.Sprintf("disable module '%s'", a.moduleName)
+}
@@ -1,7 +1,11 @@
 package a2conf
 
 import (
+	"errors"
 	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/r2dtools/a2conf/apache"
@@ -43,10 +47,237 @@ func TestReverterCommit(t *testing.T) {
 	assert.Equalf(t, true, com.IsExist(fileToBackup), "file '%s' does not exist", fileToBackup)
 }
 
+func TestReverterBackupDir(t *testing.T) {
+	reverter := getReverter()
+	fileToBackup := "/tmp/fileToRemove"
+	createFile(t, fileToBackup)
+	err := reverter.BackupFiles([]string{fileToBackup})
+	assert.Nilf(t, err, "could not backup files: %v", err)
+	assert.Equal(t, filepath.Join(reverter.backupDir, fileToBackup), reverter.getBackupFilePath(fileToBackup))
+	assert.Equalf(t, false, com.IsExist(fileToBackup+legacyBackupExt), "backup is stored next to the original file")
+	err = reverter.Commit()
+	assert.Nilf(t, err, "commit error: %v", err)
+}
+
+func TestRemoveLegacyBackups(t *testing.T) {
+	reverter := getReverter()
+	dir := writeTestConfig(t, map[string]string{
+		"ports.conf":                              "Listen 80\n",
+		"ports.conf.back":                         "Listen 80\n",
+		"sites-enabled/example.com.conf":          "",
+		"sites-enabled/example.com.conf.back":     "",
+		"sites-enabled/example.com-ssl.conf":      "",
+		"sites-enabled/example.com-ssl.conf.back": "",
+		"sites-enabled/orphan.conf.back":          "",
+	})
+	defer os.RemoveAll(dir)
+
+	removed, err := reverter.RemoveLegacyBackups(dir)
+	assert.Nilf(t, err, "could not remove legacy backups: %v", err)
+
+	expected := []string{
+		filepath.Join(dir, "ports.conf.back"),
+		filepath.Join(dir, "sites-enabled/example.com-ssl.conf.back"),
+		filepath.Join(dir, "sites-enabled/example.com.conf.back"),
+	}
+	assert.Equal(t, expected, removed)
+
+	for _, path := range expected {
+		assert.Equalf(t, false, com.IsExist(path), "legacy backup '%s' still exists", path)
+		assert.Equalf(t, true, com.IsExist(strings.TrimSuffix(path, legacyBackupExt)), "original of '%s' does not exist", path)
+	}
+
+	orphanFile := filepath.Join(dir, "sites-enabled/orphan.conf.back")
+	assert.Equalf(t, true, com.IsExist(orphanFile), "file '%s' does not exist", orphanFile)
+}
+
+func TestReverterRollbackPreservesModeAndSymlink(t *testing.T) {
+	reverter := getReverter()
+	realFile := "/tmp/a2conf-real.conf"
+	linkFile := "/tmp/a2conf-link.conf"
+	createFile(t, realFile)
+	err := os.Chmod(realFile, 0600)
+	assert.Nilf(t, err, "could not change file mode: %v", err)
+	os.Remove(linkFile)
+	err = os.Symlink(realFile, linkFile)
+	assert.Nilf(t, err, "could not create symlink: %v", err)
+
+	err = reverter.BackupFiles([]string{linkFile})
+	assert.Nilf(t, err, "could not backup files: %v", err)
+	// replace symlink with a regular file, as some tools do on write
+	err = os.Remove(linkFile)
+	assert.Nilf(t, err, "could not remove symlink: %v", err)
+	err = ioutil.WriteFile(linkFile, []byte("changed"), 0644)
+	assert.Nilf(t, err, "could not write file: %v", err)
+	err = ioutil.WriteFile(realFile, []byte("changed"), 0644)
+	assert.Nilf(t, err, "could not write file: %v", err)
+	err = os.Chmod(realFile, 0644)
+	assert.Nilf(t, err, "could not change file mode: %v", err)
+
+	err = reverter.Rollback()
+	assert.Nilf(t, err, "revert error: %v", err)
+	linkTarget, err := os.Readlink(linkFile)
+	assert.Nilf(t, err, "file '%s' is not a symlink: %v", linkFile, err)
+	assert.Equal(t, realFile, linkTarget)
+	info, err := os.Stat(realFile)
+	assert.Nilf(t, err, "could not stat file: %v", err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	content, err := ioutil.ReadFile(linkFile)
+	assert.Nilf(t, err, "could not read file: %v", err)
+	assert.Equal(t, "content", string(content))
+}
+
+type testAction struct {
+	name      string
+	executed  *[]string
+	err       error
+	commitErr error
+}
+
+func (a *testAction) Rollback() error {
+	*a.executed = append(*a.executed, a.name)
+	return a.err
+}
+
+func (a *testAction) Commit() error {
+	return a.commitErr
+}
+
+func (a *testAction) String() string {
+	return a.name
+}
+
+func TestReverterRollbackActionsOrder(t *testing.T) {
+	reverter := getReverter()
+	var executed []string
+	fileToRemove := "/tmp/a2conf-created.conf"
+	createFile(t, fileToRemove)
+	reverter.AddFileToDeletion(fileToRemove)
+	reverter.AddAction(&testAction{name: "first", executed: &executed})
+	reverter.AddAction(&testAction{name: "failed", executed: &executed, err: errors.New("failed")})
+	reverter.AddAction(&testAction{name: "last", executed: &executed})
+
+	err := reverter.Rollback()
+	assert.NotNil(t, err)
+	assert.Equal(t, []string{"last", "failed", "first"}, executed)
+	assert.Equalf(t, false, com.IsExist(fileToRemove), "file '%s' steel exists", fileToRemove)
+
+	results := reverter.GetRollbackResults()
+	assert.Equal(t, 4, len(results))
+	assert.Equal(t, "failed", results[1].Action)
+	assert.NotNil(t, results[1].Err)
+	assert.Nil(t, results[3].Err)
+
+	// only failed action is kept to be retried
+	executed = nil
+	err = reverter.Rollback()
+	assert.NotNil(t, err)
+	assert.Equal(t, []string{"failed"}, executed)
+}
+
+func TestReverterKeepsFailedFiles(t *testing.T) {
+	reverter := getReverter()
+	fileToBackup := "/tmp/a2conf-failed.conf"
+	createFile(t, fileToBackup)
+	err := reverter.BackupFile(fileToBackup)
+	assert.Nilf(t, err, "could not backup file: %v", err)
+	bFileToBackup := reverter.getBackupFilePath(fileToBackup)
+
+	// the backup could not be restored, since the file is replaced with a directory
+	err = os.Remove(fileToBackup)
+	assert.Nilf(t, err, "could not remove file: %v", err)
+	err = os.Mkdir(fileToBackup, 0755)
+	assert.Nilf(t, err, "could not create dir: %v", err)
+	defer os.RemoveAll(fileToBackup)
+
+	err = reverter.Rollback()
+	assert.NotNil(t, err)
+	assert.Contains(t, reverter.filesToRestore, fileToBackup)
+
+	// the backup is not overwritten by the next backup of the same file
+	err = reverter.BackupFile(fileToBackup)
+	assert.Nil(t, err)
+	content, err := ioutil.ReadFile(bFileToBackup)
+	assert.Nilf(t, err, "could not read backup: %v", err)
+	assert.Equal(t, "content", string(content))
+
+	err = os.Remove(fileToBackup)
+	assert.Nilf(t, err, "could not remove dir: %v", err)
+	err = reverter.Rollback()
+	assert.Nilf(t, err, "revert error: %v", err)
+	assert.Empty(t, reverter.filesToRestore)
+	content, err = ioutil.ReadFile(fileToBackup)
+	assert.Nilf(t, err, "could not read file: %v", err)
+	assert.Equal(t, "content", string(content))
+}
+
+func TestReverterCommitErrors(t *testing.T) {
+	reverter := getReverter()
+	var executed []string
+	failedAction := &testAction{name: "failed", executed: &executed, commitErr: errors.New("failed")}
+	reverter.AddAction(&testAction{name: "committed", executed: &executed})
+	reverter.AddAction(failedAction)
+
+	err := reverter.Commit()
+	assert.NotNil(t, err)
+	assert.Equal(t, []RollbackAction{failedAction}, reverter.actions)
+
+	failedAction.commitErr = nil
+	err = reverter.Commit()
+	assert.Nil(t, err)
+	assert.Empty(t, reverter.actions)
+}
+
+func TestEnableModuleRollback(t *testing.T) {
+	// the fake utilities record their calls
+	script := "#!/bin/sh\necho \"$(basename $0) $1\" >> \"$(dirname $0)/calls\"\n"
+	dir := writeTestConfig(t, map[string]string{"a2enmod": script, "a2dismod": script})
+	defer os.RemoveAll(dir)
+
+	callsPath := filepath.Join(dir, "calls")
+	module := &apache.Module{EnmodBin: filepath.Join(dir, "a2enmod"), DismodBin: filepath.Join(dir, "a2dismod")}
+
+	for _, bin := range []string{module.EnmodBin, module.DismodBin} {
+		err := os.Chmod(bin, 0755)
+		assert.Nilf(t, err, "could not make script executable: %v", err)
+	}
+
+	reverter := getReverter()
+	reverter.apacheModule = module
+	configurator := &apacheConfigurator{
+		parser:   &Parser{Modules: map[string]bool{"ssl_module": true, "mod_ssl.c": true}},
+		reverter: reverter,
+		ctl:      &apache.Ctl{},
+		module:   module,
+		logger:   &logger.NilLogger{},
+	}
+
+	assert.Nil(t, configurator.EnableModule("ssl", false))
+	assert.Nil(t, configurator.EnableModule("rewrite", false))
+	assert.Nil(t, configurator.EnableModule("headers", true))
+	assert.Contains(t, configurator.parser.Modules, "rewrite_module")
+
+	// the module enabled before is not disabled on rollback
+	assert.Nil(t, reverter.Rollback())
+	calls, err := ioutil.ReadFile(callsPath)
+	assert.Nilf(t, err, "could not read calls: %v", err)
+	assert.Equal(t, "a2enmod rewrite\na2enmod headers\na2dismod headers\na2dismod rewrite\n", string(calls))
+
+	// temporary enabled module is disabled on commit
+	os.Remove(callsPath)
+	configurator.parser.Modules = map[string]bool{}
+	assert.Nil(t, configurator.EnableModule("rewrite", false))
+	assert.Nil(t, configurator.EnableModule("headers", true))
+	assert.Nil(t, reverter.Commit())
+	calls, err = ioutil.ReadFile(callsPath)
+	assert.Nilf(t, err, "could not read calls: %v", err)
+	assert.Equal(t, "a2enmod rewrite\na2enmod headers\na2dismod headers\n", string(calls))
+}
+
 func getReverter() *Reverter {
 	logger := logger.NilLogger{}
 	apacheSite := apache.Site{}
-	reverter := Reverter{logger: &logger, apacheSite: &apacheSite}
+	reverter := Reverter{logger: &logger, apacheSite: &apacheSite, backupDir: "/tmp/a2conf-backup"}
 
 	return &reverter
 }
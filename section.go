@@ -0,0 +1,377 @@
+package a2conf
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// Element is a directive or a section of the config
+type Element interface {
+	// Parent returns the section containing the element. Nil is returned for the root section of the file.
+	Parent() (*Section, error)
+	// Set replaces arguments of the element
+	Set(args ...string) error
+	// Remove removes the element from the config
+	Remove() error
+	// InsertBefore inserts a new directive before the element
+	InsertBefore(name string, args ...string) (*Directive, error)
+	// InsertAfter inserts a new directive after the element
+	InsertAfter(name string, args ...string) (*Directive, error)
+	// MoveTo moves the element to the end of the section
+	MoveTo(section *Section) error
+}
+
+type element struct {
+	Name     string
+	Args     []string
+	FilePath string
+	// Line is a line number of the element in the file. It is 0 if the element is not saved yet.
+	Line int
+	node *Node
+	tree ConfigTree
+}
+
+// Directive is a config directive, e.g. ServerName
+type Directive struct {
+	element
+}
+
+// Section is a config section, e.g. VirtualHost or Directory.
+// The root section of the config file has no name and contains top level directives and sections of the file.
+type Section struct {
+	element
+}
+
+// Parent returns the section containing the element
+func (e *element) Parent() (*Section, error) {
+	parent, err := e.tree.GetParent(e.node)
+
+	if err != nil || parent == nil {
+		return nil, err
+	}
+
+	return newSection(e.tree, parent, make(map[string][]byte))
+}
+
+// Set replaces arguments of the element
+func (e *element) Set(args ...string) error {
+	if err := e.tree.SetArgs(e.node, args); err != nil {
+		return fmt.Errorf("could not set arguments of '%s': %v", e.Name, err)
+	}
+
+	e.Args = args
+
+	return nil
+}
+
+// Remove removes the element from the config
+func (e *element) Remove() error {
+	if err := e.tree.Remove(e.node); err != nil {
+		return fmt.Errorf("could not remove '%s': %v", e.Name, err)
+	}
+
+	return nil
+}
+
+// InsertBefore inserts a new directive before the element
+func (e *element) InsertBefore(name string, args ...string) (*Directive, error) {
+	return e.insert(name, args, true)
+}
+
+// InsertAfter inserts a new directive after the element
+func (e *element) InsertAfter(name string, args ...string) (*Directive, error) {
+	return e.insert(name, args, false)
+}
+
+// MoveTo moves the element to the end of the section
+func (e *element) MoveTo(section *Section) error {
+	if err := e.tree.MoveNode(e.node, section.node); err != nil {
+		return err
+	}
+
+	e.FilePath = section.FilePath
+	e.Line = 0
+
+	return nil
+}
+
+func (e *element) insert(name string, args []string, before bool) (*Directive, error) {
+	node, err := e.tree.InsertDirective(e.node, name, args, before)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Directive{element{Name: name, Args: args, FilePath: e.FilePath, node: node, tree: e.tree}}, nil
+}
+
+// Children returns directives and sections of the section
+func (s *Section) Children() ([]Element, error) {
+	nodes, err := s.tree.GetChildren(s.node)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var children []Element
+	files := make(map[string][]byte)
+
+	for _, node := range nodes {
+		switch node.Type {
+		case NodeDirective:
+			directive, err := newDirective(s.tree, node, files)
+
+			if err != nil {
+				return nil, err
+			}
+
+			children = append(children, directive)
+		case NodeSection:
+			section, err := newSection(s.tree, node, files)
+
+			if err != nil {
+				return nil, err
+			}
+
+			children = append(children, section)
+		}
+	}
+
+	return children, nil
+}
+
+// Directives returns child directives with the name. The name is case insensitive.
+func (s *Section) Directives(name string) ([]*Directive, error) {
+	nodes, err := s.tree.FindDirectives(s.node, name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var directives []*Directive
+	files := make(map[string][]byte)
+
+	for _, node := range nodes {
+		directive, err := newDirective(s.tree, node, files)
+
+		if err != nil {
+			return nil, err
+		}
+
+		directives = append(directives, directive)
+	}
+
+	return directives, nil
+}
+
+// Sections returns child sections with the name. The name is case insensitive.
+func (s *Section) Sections(name string) ([]*Section, error) {
+	nodes, err := s.tree.GetChildren(s.node)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var sections []*Section
+	files := make(map[string][]byte)
+
+	for _, node := range nodes {
+		if node.Type != NodeSection || !strings.EqualFold(node.Name, name) {
+			continue
+		}
+
+		section, err := newSection(s.tree, node, files)
+
+		if err != nil {
+			return nil, err
+		}
+
+		sections = append(sections, section)
+	}
+
+	return sections, nil
+}
+
+// AddDirective appends a new directive to the section
+func (s *Section) AddDirective(name string, args ...string) (*Directive, error) {
+	node, err := s.tree.AddDirective(s.node, name, args)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Directive{element{Name: name, Args: args, FilePath: s.FilePath, node: node, tree: s.tree}}, nil
+}
+
+// FindSection returns the child section with the name and the arguments. The name is case insensitive.
+// Arguments are compared after normalization: quotes and trailing slashes of paths are ignored.
+// Nil is returned if there is no such section.
+func (s *Section) FindSection(name string, args ...string) (*Section, error) {
+	sections, err := s.Sections(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, section := range sections {
+		if isSectionArgsEqual(section.Args, args) {
+			return section, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// AddSection appends a new section to the section
+func (s *Section) AddSection(name string, args ...string) (*Section, error) {
+	node, err := s.tree.AddSection(s.node, name, args)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Section{element{Name: name, Args: args, FilePath: s.FilePath, node: node, tree: s.tree}}, nil
+}
+
+// GetOrCreateSection returns the child section with the name and the arguments. The section is appended if it does not exist.
+func (s *Section) GetOrCreateSection(name string, args ...string) (*Section, error) {
+	section, err := s.FindSection(name, args...)
+
+	if err != nil || section != nil {
+		return section, err
+	}
+
+	return s.AddSection(name, args...)
+}
+
+// GetSection returns the section by the path of its node
+func GetSection(tree ConfigTree, path string) (*Section, error) {
+	node, err := tree.GetNode(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if node.Type != NodeSection {
+		return nil, fmt.Errorf("node '%s' is not a section", path)
+	}
+
+	return newSection(tree, node, make(map[string][]byte))
+}
+
+// GetFileSection returns the root section of the config file
+func GetFileSection(tree ConfigTree, filePath string) (*Section, error) {
+	node, err := tree.GetFileNode(filePath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if node == nil {
+		return nil, fmt.Errorf("file '%s' is not loaded", filePath)
+	}
+
+	return newSection(tree, node, nil)
+}
+
+func newDirective(tree ConfigTree, node *Node, files map[string][]byte) (*Directive, error) {
+	e, err := newElement(tree, node, files)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Directive{e}, nil
+}
+
+func newSection(tree ConfigTree, node *Node, files map[string][]byte) (*Section, error) {
+	if node.Type == NodeFile {
+		return &Section{element{FilePath: node.Name, node: node, tree: tree}}, nil
+	}
+
+	e, err := newElement(tree, node, files)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Section{e}, nil
+}
+
+// newElement creates an element of the node. files is a cache of the config files content used to detect line numbers.
+func newElement(tree ConfigTree, node *Node, files map[string][]byte) (element, error) {
+	e := element{Name: node.Name, node: node, tree: tree}
+	args, err := tree.GetArgs(node)
+
+	if err != nil {
+		return e, err
+	}
+
+	for _, arg := range args {
+		e.Args = append(e.Args, arg.Value)
+	}
+
+	// nodes created in the tree have no position
+	if span, err := tree.GetSpan(node); err == nil {
+		e.FilePath = span.Filename
+		e.Line = getLineNumber(files, span.Filename, span.Start)
+
+		return e, nil
+	}
+
+	for parent := node; parent != nil; {
+		if parent.Type == NodeFile {
+			e.FilePath = parent.Name
+			break
+		}
+
+		if parent, err = tree.GetParent(parent); err != nil {
+			return e, err
+		}
+	}
+
+	return e, nil
+}
+
+func getLineNumber(files map[string][]byte, filePath string, pos uint) int {
+	content, ok := files[filePath]
+
+	if !ok {
+		content, _ = ioutil.ReadFile(filePath)
+		files[filePath] = content
+	}
+
+	if int(pos) > len(content) {
+		return 0
+	}
+
+	return bytes.Count(content[:pos], []byte("\n")) + 1
+}
+
+func isSectionArgsEqual(actual, expected []string) bool {
+	if len(actual) != len(expected) {
+		return false
+	}
+
+	for i := range actual {
+		if normalizeSectionArg(actual[i]) != normalizeSectionArg(expected[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// normalizeSectionArg removes quotes and trailing slashes, e.g. "/var/www/" and /var/www are the same paths
+func normalizeSectionArg(arg string) string {
+	arg = strings.Trim(arg, "\"'")
+
+	// the root path keeps its slash
+	if trimmed := strings.TrimRight(arg, "/"); trimmed != "" {
+		return trimmed
+	}
+
+	return arg
+}
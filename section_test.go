@@ -0,0 +1,176 @@
+package a2conf
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSectionQueries(t *testing.T) {
+	tree, filePath := getTestConfigTree(t)
+	fileSection, err := GetFileSection(tree, filePath)
+	assert.Nilf(t, err, "could not get file section: %v", err)
+	assert.Equal(t, filePath, fileSection.FilePath)
+
+	vhosts, err := fileSection.Sections("virtualhost")
+	assert.Nilf(t, err, "could not get sections: %v", err)
+	assert.Equal(t, 1, len(vhosts), "sections within Macro must not be returned")
+
+	vhost := vhosts[0]
+	assert.Equal(t, "VirtualHost", vhost.Name)
+	assert.Equal(t, []string{"*:80"}, vhost.Args)
+	assert.Equal(t, filePath, vhost.FilePath)
+	assert.Equal(t, 7, vhost.Line)
+
+	directives, err := vhost.Directives("ServerAlias")
+	assert.Nilf(t, err, "could not get directives: %v", err)
+	assert.Equal(t, 1, len(directives))
+	assert.Equal(t, "ServerAlias", directives[0].Name)
+	assert.Equal(t, []string{"www.example.com"}, directives[0].Args)
+	assert.Equal(t, 10, directives[0].Line)
+
+	parent, err := directives[0].Parent()
+	assert.Nilf(t, err, "could not get parent: %v", err)
+	assert.Equal(t, "VirtualHost", parent.Name)
+
+	parent, err = vhost.Parent()
+	assert.Nilf(t, err, "could not get parent: %v", err)
+	assert.Equal(t, "", parent.Name)
+	assert.Equal(t, filePath, parent.FilePath)
+
+	parent, err = parent.Parent()
+	assert.Nilf(t, err, "could not get parent: %v", err)
+	assert.Nil(t, parent)
+
+	children, err := vhost.Children()
+	assert.Nilf(t, err, "could not get children: %v", err)
+	assert.Equal(t, 3, len(children))
+	assert.Equal(t, "ServerName", children[0].(*Directive).Name)
+	assert.Equal(t, "Directory", children[2].(*Section).Name)
+	assert.Equal(t, 11, children[2].(*Section).Line)
+}
+
+func TestSectionModification(t *testing.T) {
+	tree, filePath := getTestConfigTree(t)
+	fileSection, _ := GetFileSection(tree, filePath)
+	vhosts, _ := fileSection.Sections("VirtualHost")
+	vhost := vhosts[0]
+
+	serverName, _ := vhost.Directives("ServerName")
+	_, err := serverName[0].InsertBefore("ServerAdmin", "admin@example.com")
+	assert.Nilf(t, err, "could not insert directive: %v", err)
+	_, err = serverName[0].InsertAfter("UseCanonicalName", "On")
+	assert.Nilf(t, err, "could not insert directive: %v", err)
+
+	err = serverName[0].Set("example2.com")
+	assert.Nilf(t, err, "could not set directive arguments: %v", err)
+
+	directories, _ := vhost.Sections("Directory")
+	_, err = directories[0].InsertBefore("DirectoryIndex", "index.html", "index.php")
+	assert.Nilf(t, err, "could not insert directive: %v", err)
+	err = directories[0].Set("/var/www/example2", "extra")
+	assert.Nilf(t, err, "could not set section arguments: %v", err)
+	err = directories[0].Set("/var/www/example2")
+	assert.Nilf(t, err, "could not set section arguments: %v", err)
+
+	directive, err := vhost.AddDirective("Redirect", "/old", "/new")
+	assert.Nilf(t, err, "could not add directive: %v", err)
+	assert.Equal(t, filePath, directive.FilePath)
+	assert.Equal(t, 0, directive.Line)
+
+	aliases, _ := vhost.Directives("ServerAlias")
+	err = aliases[0].Remove()
+	assert.Nilf(t, err, "could not remove directive: %v", err)
+
+	children, err := vhost.Children()
+	assert.Nilf(t, err, "could not get children: %v", err)
+	var names []string
+
+	for _, child := range children {
+		switch child := child.(type) {
+		case *Directive:
+			names = append(names, child.Name+" "+child.Args[0])
+		case *Section:
+			names = append(names, child.Name+" "+child.Args[0])
+		}
+	}
+
+	expected := []string{
+		"ServerAdmin admin@example.com",
+		"ServerName example2.com",
+		"UseCanonicalName On",
+		"DirectoryIndex index.html",
+		"Directory /var/www/example2",
+		"Redirect /old",
+	}
+	assert.Equal(t, expected, names)
+
+	directories, _ = vhost.Sections("Directory")
+	assert.Equal(t, []string{"/var/www/example2"}, directories[0].Args)
+	requires, _ := directories[0].Directives("Require")
+	assert.Equal(t, []string{"all", "granted"}, requires[0].Args)
+}
+
+func TestNestedSections(t *testing.T) {
+	tree, filePath := getTestConfigTree(t)
+	fileSection, _ := GetFileSection(tree, filePath)
+	vhost, err := fileSection.FindSection("VirtualHost", "*:80")
+	assert.Nilf(t, err, "could not find section: %v", err)
+	assert.NotNil(t, vhost)
+
+	second, err := vhost.AddSection("Directory", "/var/www/static")
+	assert.Nilf(t, err, "could not add section: %v", err)
+	directory, err := vhost.GetOrCreateSection("directory", "\"/var/www/html/\"")
+	assert.Nilf(t, err, "could not get section: %v", err)
+	assert.Equal(t, []string{"/var/www/html"}, directory.Args, "existing section must be found")
+
+	location, err := vhost.GetOrCreateSection("Location", "/admin")
+	assert.Nilf(t, err, "could not create section: %v", err)
+	_, err = location.AddDirective("Require", "ip", "10.0.0.0/8")
+	assert.Nilf(t, err, "could not add directive: %v", err)
+
+	aliases, _ := vhost.Directives("ServerAlias")
+	err = aliases[0].MoveTo(location)
+	assert.Nilf(t, err, "could not move directive: %v", err)
+
+	files, err := directory.AddSection("Files", "*.php")
+	assert.Nilf(t, err, "could not add section: %v", err)
+	_, err = files.AddDirective("SetHandler", "proxy:fcgi://127.0.0.1:9000")
+	assert.Nilf(t, err, "could not add directive: %v", err)
+
+	// the moved section precedes the target one with the same name
+	err = directory.MoveTo(second)
+	assert.Nilf(t, err, "could not move section: %v", err)
+
+	found, _ := vhost.FindSection("Location", "/admin/")
+	assert.NotNil(t, found)
+	err = found.Remove()
+	assert.Nilf(t, err, "could not remove section: %v", err)
+
+	err = tree.(*backendTree).backend.Save()
+	assert.Nilf(t, err, "could not save config: %v", err)
+	content, err := ioutil.ReadFile(filePath)
+	assert.Nilf(t, err, "could not read config: %v", err)
+
+	expected := `<Macro VHost $name>
+	<VirtualHost *:80>
+		ServerName $name
+	</VirtualHost>
+</Macro>
+
+<VirtualHost *:80>
+	# main site
+	ServerName example.com
+	<Directory /var/www/static>
+	    <Directory /var/www/html>
+	        Require all granted
+	        <Files *.php>
+	            SetHandler proxy:fcgi://127.0.0.1:9000
+	        </Files>
+	    </Directory>
+	</Directory>
+</VirtualHost>
+`
+	assert.Equal(t, expected, string(content))
+}
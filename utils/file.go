@@ -0,0 +1,126 @@
+package utils
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// FileAttrs represents file attributes that should be kept when the file is rewritten
+type FileAttrs struct {
+	Mode   os.FileMode
+	UID    int
+	GID    int
+	Xattrs map[string][]byte
+}
+
+// GetFileAttrs returns mode, owner and extended attributes of the file. Symlinks are followed.
+func GetFileAttrs(path string) (*FileAttrs, error) {
+	info, err := os.Stat(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	attrs := &FileAttrs{Mode: info.Mode().Perm(), UID: -1, GID: -1}
+
+	if uid, gid, ok := getFileOwner(info); ok {
+		attrs.UID = uid
+		attrs.GID = gid
+	}
+
+	xattrs, err := getXattrs(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not get extended attributes of the file '%s': %v", path, err)
+	}
+
+	attrs.Xattrs = xattrs
+
+	return attrs, nil
+}
+
+// ApplyFileAttrs sets mode, owner and extended attributes to the file
+func ApplyFileAttrs(path string, attrs *FileAttrs) error {
+	if attrs == nil {
+		return nil
+	}
+
+	if err := os.Chmod(path, attrs.Mode); err != nil {
+		return err
+	}
+
+	info, err := os.Stat(path)
+
+	if err != nil {
+		return err
+	}
+
+	// chown is skipped if the owner is already correct, so non-root users can still restore their own files
+	if uid, gid, ok := getFileOwner(info); ok && attrs.UID != -1 && (uid != attrs.UID || gid != attrs.GID) {
+		if err = os.Chown(path, attrs.UID, attrs.GID); err != nil {
+			return err
+		}
+	}
+
+	if err = setXattrs(path, attrs.Xattrs); err != nil {
+		return fmt.Errorf("could not set extended attributes of the file '%s': %v", path, err)
+	}
+
+	return nil
+}
+
+// WriteFileAtomic writes content to a temporary file in the same directory and renames it to the path.
+// So the file is either completely written or is not changed at all.
+func WriteFileAtomic(path string, content []byte, attrs *FileAttrs) error {
+	tmpFile, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+
+	if err != nil {
+		return err
+	}
+
+	tmpPath := tmpFile.Name()
+	defer os.Remove(tmpPath)
+
+	if _, err = tmpFile.Write(content); err != nil {
+		tmpFile.Close()
+		return err
+	}
+
+	if err = tmpFile.Sync(); err != nil {
+		tmpFile.Close()
+		return err
+	}
+
+	if err = tmpFile.Close(); err != nil {
+		return err
+	}
+
+	if attrs == nil {
+		attrs = &FileAttrs{Mode: 0644, UID: -1, GID: -1}
+	}
+
+	if err = ApplyFileAttrs(tmpPath, attrs); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
+
+// SymlinkAtomic creates or replaces the symlink path pointing to the target
+func SymlinkAtomic(target, path string) error {
+	tmpPath := filepath.Join(filepath.Dir(path), fmt.Sprintf(".%s.tmp%d", filepath.Base(path), os.Getpid()))
+	os.Remove(tmpPath)
+
+	if err := os.Symlink(target, tmpPath); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,73 @@
+package utils
+
+import (
+	"bytes"
+	"os"
+	"syscall"
+)
+
+func getFileOwner(info os.FileInfo) (int, int, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+
+	if !ok {
+		return 0, 0, false
+	}
+
+	return int(stat.Uid), int(stat.Gid), true
+}
+
+func getXattrs(path string) (map[string][]byte, error) {
+	size, err := syscall.Listxattr(path, nil)
+
+	if err == syscall.ENOTSUP {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if size == 0 {
+		return nil, nil
+	}
+
+	buf := make([]byte, size)
+
+	if size, err = syscall.Listxattr(path, buf); err != nil {
+		return nil, err
+	}
+
+	xattrs := make(map[string][]byte)
+
+	for _, name := range bytes.Split(buf[:size], []byte{0}) {
+		if len(name) == 0 {
+			continue
+		}
+
+		vSize, err := syscall.Getxattr(path, string(name), nil)
+
+		if err != nil {
+			return nil, err
+		}
+
+		value := make([]byte, vSize)
+
+		if vSize, err = syscall.Getxattr(path, string(name), value); err != nil {
+			return nil, err
+		}
+
+		xattrs[string(name)] = value[:vSize]
+	}
+
+	return xattrs, nil
+}
+
+func setXattrs(path string, xattrs map[string][]byte) error {
+	for name, value := range xattrs {
+		if err := syscall.Setxattr(path, name, value, 0); err != nil && err != syscall.ENOTSUP {
+			return err
+		}
+	}
+
+	return nil
+}
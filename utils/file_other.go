@@ -0,0 +1,18 @@
+//go:build !linux
+// +build !linux
+
+package utils
+
+import "os"
+
+func getFileOwner(info os.FileInfo) (int, int, bool) {
+	return 0, 0, false
+}
+
+func getXattrs(path string) (map[string][]byte, error) {
+	return nil, nil
+}
+
+func setXattrs(path string, xattrs map[string][]byte) error {
+	return nil
+}
@@ -0,0 +1,41 @@
+package utils
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	path := "/tmp/a2conf-atomic.conf"
+	err := ioutil.WriteFile(path, []byte("origin"), 0600)
+	assert.Nilf(t, err, "could not create file: %v", err)
+	os.Chmod(path, 0600)
+	attrs, err := GetFileAttrs(path)
+	assert.Nilf(t, err, "could not get file attributes: %v", err)
+	assert.Equal(t, os.FileMode(0600), attrs.Mode)
+
+	err = WriteFileAtomic(path, []byte("content"), attrs)
+	assert.Nilf(t, err, "could not write file: %v", err)
+	content, err := ioutil.ReadFile(path)
+	assert.Nilf(t, err, "could not read file: %v", err)
+	assert.Equal(t, "content", string(content))
+	info, err := os.Stat(path)
+	assert.Nilf(t, err, "could not stat file: %v", err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+}
+
+func TestSymlinkAtomic(t *testing.T) {
+	path := "/tmp/a2conf-atomic.link"
+	os.Remove(path)
+	err := ioutil.WriteFile(path, []byte("content"), 0644)
+	assert.Nilf(t, err, "could not create file: %v", err)
+
+	err = SymlinkAtomic("/tmp/a2conf-atomic.conf", path)
+	assert.Nilf(t, err, "could not create symlink: %v", err)
+	target, err := os.Readlink(path)
+	assert.Nilf(t, err, "could not read symlink: %v", err)
+	assert.Equal(t, "/tmp/a2conf-atomic.conf", target)
+}
@@ -0,0 +1,152 @@
+package a2conf
+
+import "strings"
+
+// configVisitor evaluates the nodes passed by configWalker
+type configVisitor interface {
+	// getArgs returns the resolved arguments of the directive or section
+	getArgs(node *Node) ([]string, error)
+	// enterSection checks if directives of the section are read by apache. Active sections are walked and leaveSection is called after it.
+	enterSection(node *Node, args []string) (bool, error)
+	leaveSection(node *Node)
+	// visitDirective is called for all directives, Include and IncludeOptional ones are visited before their files are walked
+	visitDirective(node *Node, args []string) error
+	// visitInclude is called for Include and IncludeOptional directives with the absolute pattern and the files matching it
+	visitInclude(node *Node, arg, pattern string, filePaths []string) error
+	// visitIncludedFile is called for each file matching the pattern of Include directive before it is walked.
+	// Cyclic is set if the file is being walked already, it is not walked again then.
+	visitIncludedFile(node *Node, arg, filePath string, cyclic bool) error
+	// visitFile is called before the file is walked
+	visitFile(filePath string)
+}
+
+// configWalker walks the config in the order apache reads it: included files are walked at the place of Include directive
+type configWalker struct {
+	parser  *Parser
+	visitor configVisitor
+	// files are the files being walked, they are tracked to prevent include loops
+	files map[string]bool
+}
+
+// baseVisitor is embedded by the visitors to skip the nodes they are not interested in
+type baseVisitor struct{}
+
+// walkConfig walks the loaded config starting with ConfigRoot
+func (p *Parser) walkConfig(visitor configVisitor) error {
+	w := &configWalker{
+		parser:  p,
+		visitor: visitor,
+		files:   make(map[string]bool),
+	}
+
+	return w.walkFile(p.ConfigRoot)
+}
+
+func (w *configWalker) walkFile(filePath string) error {
+	fileNode, err := w.parser.Tree.GetFileNode(filePath)
+
+	// files with parse errors are not loaded
+	if err != nil || fileNode == nil {
+		return err
+	}
+
+	w.visitor.visitFile(filePath)
+	w.files[filePath] = true
+	defer delete(w.files, filePath)
+
+	return w.walkNode(fileNode)
+}
+
+func (w *configWalker) walkNode(node *Node) error {
+	children, err := w.parser.Tree.GetChildren(node)
+
+	if err != nil {
+		return err
+	}
+
+	for _, child := range children {
+		if child.Type != NodeDirective && child.Type != NodeSection {
+			continue
+		}
+
+		args, err := w.visitor.getArgs(child)
+
+		if err != nil {
+			return err
+		}
+
+		if child.Type == NodeSection {
+			isActive, err := w.visitor.enterSection(child, args)
+
+			if err != nil {
+				return err
+			}
+
+			if isActive {
+				if err = w.walkNode(child); err != nil {
+					return err
+				}
+
+				w.visitor.leaveSection(child)
+			}
+
+			continue
+		}
+
+		if err = w.visitor.visitDirective(child, args); err != nil {
+			return err
+		}
+
+		if isIncludeDirective(child.Name) && len(args) > 0 {
+			if err = w.walkInclude(child, args[0]); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (w *configWalker) walkInclude(node *Node, arg string) error {
+	pattern, filePaths := w.parser.getIncludedFiles(arg)
+
+	if err := w.visitor.visitInclude(node, arg, pattern, filePaths); err != nil {
+		return err
+	}
+
+	for _, filePath := range filePaths {
+		cyclic := w.files[filePath]
+
+		if err := w.visitor.visitIncludedFile(node, arg, filePath, cyclic); err != nil {
+			return err
+		}
+
+		if cyclic {
+			continue
+		}
+
+		if err := w.walkFile(filePath); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (v *baseVisitor) leaveSection(node *Node) {}
+
+func (v *baseVisitor) visitInclude(node *Node, arg, pattern string, filePaths []string) error {
+	return nil
+}
+
+func (v *baseVisitor) visitIncludedFile(node *Node, arg, filePath string, cyclic bool) error {
+	return nil
+}
+
+func (v *baseVisitor) visitFile(filePath string) {}
+
+func isIncludeDirective(name string) bool {
+	name = strings.ToLower(name)
+
+	return name == "include" || name == "includeoptional"
+}